@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// adminToken is the shared secret required in the X-Admin-Token header for
+// /admin routes. Admin routes are disabled when it is empty.
+var adminToken string
+
+// adminKey is the Gin context key set for requests with a valid admin token.
+const adminKey = "admin"
+
+// requireAdmin is a middleware that rejects requests without a valid admin
+// token, unless their bearer token or user has the admin role.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(roleKey) == roleAdmin && !validAdminToken(c) {
+			c.Next()
+			return
+		}
+		if adminToken == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "admin endpoints are disabled"})
+			return
+		}
+		if !validAdminToken(c) {
+			if len(basicAuthUsers) > 0 {
+				c.Header("WWW-Authenticate", basicChallenge)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "admin authentication required"})
+			return
+		}
+		c.Set(adminKey, true)
+		c.Next()
+	}
+}
+
+// validAdminToken reports whether the request carries the admin token.
+func validAdminToken(c *gin.Context) bool {
+	token := c.GetHeader("X-Admin-Token")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// adminAllowedNets are the networks, set by ADMIN_ALLOWED_CIDRS, from which
+// the admin routes may be reached. Every address is allowed when it is empty.
+// Client addresses are taken from X-Forwarded-For only when the request comes
+// from one of the TRUSTED_PROXIES, such as the load balancer.
+var adminAllowedNets []netip.Prefix
+
+// parseCIDRs parses networks such as 10.0.0.0/8, or single addresses.
+func parseCIDRs(entries []string) ([]netip.Prefix, error) {
+	nets := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, prefix.Masked())
+	}
+	return nets, nil
+}
+
+// allowAdminIPs is a middleware that rejects requests to admin routes from
+// clients outside adminAllowedNets, whatever their credentials.
+func allowAdminIPs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(adminAllowedNets) == 0 {
+			c.Next()
+			return
+		}
+		addr, err := netip.ParseAddr(c.ClientIP())
+		addr = addr.Unmap()
+		for _, prefix := range adminAllowedNets {
+			if err == nil && prefix.Contains(addr) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "admin endpoints are not reachable from this address"})
+	}
+}
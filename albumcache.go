@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"time"
+)
+
+// albumCache holds the album details read by GET /albums/:albumID, so albums
+// read repeatedly do not hit the database. It is selected by ALBUM_CACHE:
+// "redis" for the Redis at ALBUM_CACHE_REDIS_URL, shared by every instance,
+// or "memory" for an in-process LRU of ALBUM_CACHE_SIZE albums, for single
+// instance deployments. It is nil when disabled. Writes to an album
+// invalidate its entry with invalidateAlbum; entries expire after
+// albumCacheTTL (ALBUM_CACHE_TTL), which bounds how long a read racing with
+// a write may serve the old detail.
+var (
+	albumCache    detailCache
+	albumCacheTTL = 5 * time.Minute
+)
+
+// detailCache stores album details by albumID.
+type detailCache interface {
+	get(ctx context.Context, albumID string) (albumDetail, bool)
+	set(ctx context.Context, albumID string, album albumDetail)
+	remove(ctx context.Context, albumIDs ...string)
+}
+
+// albumCacheTimeout bounds the calls to the Redis cache, which are skipped
+// when Redis is slow or down.
+const albumCacheTimeout = 100 * time.Millisecond
+
+// albumCacheKey is the cache key of the detail of an album.
+func albumCacheKey(albumID string) string {
+	return "album:" + albumID
+}
+
+// redisAlbumCache stores album details as JSON in Redis.
+type redisAlbumCache struct {
+	client *redis.Client
+}
+
+func (r redisAlbumCache) get(ctx context.Context, albumID string) (albumDetail, bool) {
+	var album albumDetail
+	cacheCtx, cancel := context.WithTimeout(ctx, albumCacheTimeout)
+	defer cancel()
+	data, err := r.client.Get(cacheCtx, albumCacheKey(albumID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logFor(ctx).Warn().Err(err).Str("albumID", albumID).Msg("Error reading the album cache")
+		}
+		return album, false
+	}
+	return album, json.Unmarshal(data, &album) == nil
+}
+
+func (r redisAlbumCache) set(ctx context.Context, albumID string, album albumDetail) {
+	data, err := json.Marshal(album)
+	if err != nil {
+		return
+	}
+	cacheCtx, cancel := context.WithTimeout(ctx, albumCacheTimeout)
+	defer cancel()
+	if err := r.client.Set(cacheCtx, albumCacheKey(albumID), data, albumCacheTTL).Err(); err != nil {
+		logFor(ctx).Warn().Err(err).Str("albumID", albumID).Msg("Error writing the album cache")
+	}
+}
+
+func (r redisAlbumCache) remove(ctx context.Context, albumIDs ...string) {
+	keys := make([]string, len(albumIDs))
+	for i, albumID := range albumIDs {
+		keys[i] = albumCacheKey(albumID)
+	}
+	// The change is already made, so the entries are removed even when the
+	// request has been canceled.
+	cacheCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), albumCacheTimeout)
+	defer cancel()
+	if err := r.client.Del(cacheCtx, keys...).Err(); err != nil {
+		logFor(ctx).Error().Err(err).Strs("albumIDs", albumIDs).Msg("Error invalidating the album cache")
+	}
+}
+
+// memoryAlbumCache stores album details in an in-process LRU.
+type memoryAlbumCache struct {
+	lru *lruCache[cachedDetail]
+}
+
+// cachedDetail is an album detail with the time it expires at.
+type cachedDetail struct {
+	album   albumDetail
+	expires time.Time
+}
+
+func newMemoryAlbumCache(size int) memoryAlbumCache {
+	return memoryAlbumCache{newLRUCache(int64(size), func(cachedDetail) int64 { return 1 })}
+}
+
+func (m memoryAlbumCache) get(_ context.Context, albumID string) (albumDetail, bool) {
+	cached, ok := m.lru.get(albumID)
+	if !ok || time.Now().After(cached.expires) {
+		return albumDetail{}, false
+	}
+	return cached.album, true
+}
+
+func (m memoryAlbumCache) set(_ context.Context, albumID string, album albumDetail) {
+	m.lru.add(albumID, cachedDetail{album, time.Now().Add(albumCacheTTL)})
+}
+
+func (m memoryAlbumCache) remove(_ context.Context, albumIDs ...string) {
+	m.lru.remove(albumIDs...)
+}
+
+// cachingAlbumRepository reads album details through albumCache before
+// another AlbumRepository, and invalidates them when albums are deleted,
+// restored or purged.
+type cachingAlbumRepository struct {
+	AlbumRepository
+}
+
+func (r cachingAlbumRepository) GetByID(ctx context.Context, albumID string) (albumDetail, error) {
+	if album, ok := albumCache.get(ctx, albumID); ok {
+		cacheRequests.WithLabelValues("album", "hit").Inc()
+		return album, nil
+	}
+	cacheRequests.WithLabelValues("album", "miss").Inc()
+	album, err := r.AlbumRepository.GetByID(ctx, albumID)
+	if err != nil {
+		return album, err
+	}
+	albumCache.set(ctx, albumID, album)
+	return album, nil
+}
+
+func (r cachingAlbumRepository) Delete(ctx context.Context, albumIDs []string) ([]bool, error) {
+	defer invalidateAlbum(ctx, albumIDs...)
+	return r.AlbumRepository.Delete(ctx, albumIDs)
+}
+
+func (r cachingAlbumRepository) Restore(ctx context.Context, albumID string) (bool, error) {
+	defer invalidateAlbum(ctx, albumID)
+	return r.AlbumRepository.Restore(ctx, albumID)
+}
+
+func (r cachingAlbumRepository) Purge(ctx context.Context, albumIDs []string) ([]bool, error) {
+	defer invalidateAlbum(ctx, albumIDs...)
+	return r.AlbumRepository.Purge(ctx, albumIDs)
+}
+
+// invalidateAlbum removes the cached details of albums after they changed.
+// Failures are logged, leaving the entries to expire.
+func invalidateAlbum(ctx context.Context, albumIDs ...string) {
+	if albumCache == nil || len(albumIDs) == 0 {
+		return
+	}
+	albumCache.remove(ctx, albumIDs...)
+}
+
+// thumbnailCache holds the bytes of the thumbnails served by the image
+// routes in process, up to THUMBNAIL_CACHE_BYTES, so popular thumbnails are
+// not fetched from the image store again. Only thumbnails of up to
+// maxCachedThumbnailSize are cached. It is nil when disabled.
+var thumbnailCache *lruCache[[]byte]
+
+const maxCachedThumbnailSize = 256 << 10
+
+// serveThumbnail serves the thumbnail stored under key from thumbnailCache,
+// or from the image store while caching it.
+func serveThumbnail(c *gin.Context, key string) {
+	if thumbnailCache == nil {
+		serveStoredImage(c, key)
+		return
+	}
+	if notModified(c, `"`+key+`"`) {
+		return
+	}
+	if imageData, ok := thumbnailCache.get(key); ok {
+		cacheRequests.WithLabelValues("thumbnail", "hit").Inc()
+		serveImage(c, key, imageData)
+		return
+	}
+	cacheRequests.WithLabelValues("thumbnail", "miss").Inc()
+	imageData, err := imageStore.Get(c.Request.Context(), key)
+	if errors.Is(err, errImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "image not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album image"})
+		return
+	}
+	if len(imageData) <= maxCachedThumbnailSize {
+		thumbnailCache.add(key, imageData)
+	}
+	serveImage(c, key, imageData)
+}
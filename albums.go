@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readImageFile opens an uploaded multipart file and reads its full content
+// with the metadata stripped. The file is read into a pooled buffer, which
+// stripping copies from. Images over maxImageSize fail with
+// errImageTooLarge and images of a type that is not allowed fail with an
+// unsupportedImageError.
+func readImageFile(fileHeader *multipart.FileHeader) ([]byte, error) {
+	if fileHeader.Size > int64(maxImageSize) {
+		return nil, errImageTooLarge
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := getImageBuffer()
+	defer putImageBuffer(buf)
+	buf.Grow(int(fileHeader.Size) + bytes.MinRead)
+	if _, err := buf.ReadFrom(file); err != nil {
+		return nil, err
+	}
+	if _, err := checkImageType(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return stripImageMetadata(buf.Bytes()), nil
+}
+
+// maxProfileSize is the largest 'profile' field accepted by POST /albums.
+const maxProfileSize = 64 << 10
+
+// uploadError is returned by readAlbumUpload for a request that cannot be
+// processed, with the HTTP status and message to respond with.
+type uploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *uploadError) Error() string {
+	return e.Msg
+}
+
+// readAlbumUpload reads the multipart body of POST /albums part by part. The
+// 'profile' field is buffered and the 'image' file is streamed to the image
+// store as it arrives, so the image is never held in memory. The image is
+// optional; without one the returned storedImage has no key. When the request
+// turns out to be invalid, an image that was already stored is deleted again.
+func readAlbumUpload(c *gin.Context) (string, storedImage, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return "", storedImage{}, &uploadError{http.StatusBadRequest, "invalid request: body must be multipart/form-data"}
+	}
+	ctx := c.Request.Context()
+	var profileStr string
+	var image storedImage
+	fail := func(err error) (string, storedImage, error) {
+		if image.Key != "" {
+			deleteImages(ctx, []string{image.Key})
+		}
+		return "", storedImage{}, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		var bodyErr *http.MaxBytesError
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &bodyErr) {
+			return fail(&uploadError{http.StatusRequestEntityTooLarge, imageTooLargeMsg()})
+		} else if err != nil {
+			return fail(&uploadError{http.StatusBadRequest, "invalid request: malformed multipart body"})
+		}
+		switch {
+		case part.FormName() == "profile":
+			data, err := io.ReadAll(io.LimitReader(part, maxProfileSize+1))
+			if err != nil {
+				return fail(&uploadError{http.StatusBadRequest, "invalid request: malformed multipart body"})
+			}
+			if len(data) > maxProfileSize {
+				return fail(&uploadError{http.StatusBadRequest, "invalid request: profile is too large"})
+			}
+			profileStr = string(data)
+		case part.FormName() == "image" && image.Key == "":
+			image, err = streamImage(ctx, part)
+			var typeErr *unsupportedImageError
+			var bodyErr *http.MaxBytesError
+			if errors.Is(err, errImageTooLarge) || errors.As(err, &bodyErr) {
+				return fail(&uploadError{http.StatusRequestEntityTooLarge, imageTooLargeMsg()})
+			} else if errors.As(err, &typeErr) {
+				return fail(&uploadError{http.StatusUnsupportedMediaType, "invalid request: " + typeErr.Error()})
+			} else if errors.Is(err, errMalformedImage) {
+				return fail(&uploadError{http.StatusBadRequest, "invalid request: image is malformed"})
+			} else if err != nil {
+				return fail(err)
+			}
+		}
+		part.Close()
+	}
+
+	if profileStr == "" {
+		return fail(&uploadError{http.StatusBadRequest, "invalid request: profile is required"})
+	}
+	return profileStr, image, nil
+}
+
+// rejectDuplicateImages makes uploads of an image that is already stored fail
+// with a duplicateImageError instead of creating a second album.
+var rejectDuplicateImages bool
+
+// duplicateImageError is returned by insertAlbum when the uploaded image is
+// identical to the image of an existing album.
+type duplicateImageError struct {
+	AlbumID string
+}
+
+func (e *duplicateImageError) Error() string {
+	return "image already uploaded as album " + e.AlbumID
+}
+
+// hashImage returns the hex-encoded SHA-256 hash of the image content.
+func hashImage(imageData []byte) string {
+	sum := sha256.Sum256(imageData)
+	return hex.EncodeToString(sum[:])
+}
+
+// insertAlbum stores the image and inserts a new album record for it.
+func insertAlbum(ctx context.Context, album newAlbum, imageData []byte) error {
+	image, err := storeImage(ctx, imageData)
+	if err != nil {
+		return err
+	}
+	album.Image = image
+	return insertStoredAlbum(ctx, album)
+}
+
+// insertStoredAlbum inserts a new album record for an image already written
+// to the image store, linking it to the artist record with the profile's
+// artist name. The stored image is deleted again when the album is not
+// created.
+func insertStoredAlbum(ctx context.Context, album newAlbum) error {
+	err := createAlbumRecord(ctx, album)
+	if err != nil {
+		deleteImages(ctx, []string{album.Image.Key})
+		return err
+	}
+	uploads.record(1)
+	queueImageJob(ctx, album.AlbumID)
+	return nil
+}
+
+// createAlbumRecord checks the storage quotas and duplicate images and
+// inserts the album.
+func createAlbumRecord(ctx context.Context, album newAlbum) error {
+	if err := checkQuota(ctx, album.OwnerID, 1, album.Image.Size); err != nil {
+		return err
+	}
+	if rejectDuplicateImages && !album.Image.Placeholder {
+		existingID, err := albumRepo.FindByImageHash(ctx, album.Image.Hash, album.AlbumID)
+		if err != nil {
+			return err
+		}
+		if existingID != "" {
+			return &duplicateImageError{AlbumID: existingID}
+		}
+	}
+	return albumRepo.Create(ctx, album)
+}
+
+// parseProfile decodes and validates a profile sent as a JSON form field.
+func parseProfile(s string) (Profile, error) {
+	var profile Profile
+	if err := json.Unmarshal([]byte(s), &profile); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return profile, typeFieldError(typeErr)
+		}
+		return profile, errors.New("profile is not valid JSON")
+	}
+	return profile, profile.validate()
+}
+
+// validate checks the profile fields and normalizes the genre. An empty genre
+// is allowed; any other genre must be in the allowed list. The year must be
+// unknown or between minAlbumYear and maxAlbumYear.
+func (p *Profile) validate() error {
+	p.Genre = normalizeGenre(p.Genre)
+	return validateStruct(p)
+}
+
+// Album is an album profile together with its albumID and creation time.
+type Album struct {
+	AlbumID string `json:"albumID"`
+	Profile
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ImageWidth and ImageHeight are the dimensions of the primary image, or
+	// zero when they are unknown.
+	ImageWidth  int `json:"imageWidth,omitempty"`
+	ImageHeight int `json:"imageHeight,omitempty"`
+}
+
+// requireAlbum writes a 404 or 500 response and returns false when the album
+// cannot be found.
+func requireAlbum(c *gin.Context, albumID string) bool {
+	exists, err := albumRepo.Exists(c.Request.Context(), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album data"})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "album not found"})
+		return false
+	}
+	return true
+}
+
+// albumColumns are the columns read by scanAlbums, in scan order.
+const albumColumns = `album_id, artist, title, year, genre, created_at, image_width, image_height`
+
+// qualifiedAlbumColumns returns albumColumns prefixed with a table alias, for
+// queries that join albums with other tables.
+func qualifiedAlbumColumns(alias string) string {
+	return alias + "." + strings.ReplaceAll(albumColumns, ", ", ", "+alias+".")
+}
+
+// scanAlbums reads every row of a query selecting albumColumns.
+func scanAlbums(rows *sql.Rows) ([]Album, error) {
+	defer rows.Close()
+	albums := []Album{}
+	for rows.Next() {
+		var album Album
+		if err := rows.Scan(&album.AlbumID, &album.Artist, &album.Title, &album.Year, &album.Genre, &album.CreatedAt, &album.ImageWidth, &album.ImageHeight); err != nil {
+			return nil, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
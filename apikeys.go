@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requireAPIKeys makes requests that change data present a valid key in the
+// X-API-Key header, set by REQUIRE_API_KEY. Keys are issued and revoked
+// through /admin/api-keys; requests with the admin token or a valid bearer
+// token need no key.
+var requireAPIKeys bool
+
+// apiKeyIDKey is the Gin context key holding the ID of the request's API key.
+const apiKeyIDKey = "apiKeyID"
+
+// apiKeyRequest is the JSON body accepted by POST /admin/api-keys.
+type apiKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// apiKey is an issued API key as listed by GET /admin/api-keys. The key itself
+// is only returned when it is issued; the server keeps its hash.
+type apiKey struct {
+	KeyID     string     `json:"keyID"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// isMutation reports whether the request's route changes data. GET /reset is
+// the only GET route that does.
+func isMutation(c *gin.Context) bool {
+	route := c.FullPath()
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return route == "/reset"
+	case http.MethodPost:
+		return route != "" && !readOnlyPostRoutes[route]
+	}
+	return route != ""
+}
+
+// requireAPIKey is a middleware that rejects requests changing data without a
+// valid, unrevoked key in the X-API-Key header when requireAPIKeys is set.
+func requireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAPIKeys || !isMutation(c) || validAdminToken(c) || c.GetBool(bearerKey) || c.GetString(signerKey) != "" || c.GetString(basicUserKey) != "" {
+			c.Next()
+			return
+		}
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "API key required"})
+			return
+		}
+		hash := hashToken(key)
+		keyID, err := withRetry(c.Request.Context(), func() (string, error) {
+			ctx, cancel := withQueryTimeout(c.Request.Context())
+			defer cancel()
+			stmt, err := prepared(ctx, db, `SELECT key_id FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`)
+			if err != nil {
+				return "", err
+			}
+			var keyID string
+			err = stmt.QueryRowContext(ctx, hash).Scan(&keyID)
+			return keyID, err
+		})
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid API key"})
+			return
+		} else if err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "failed to authenticate API key"})
+			return
+		}
+		c.Set(apiKeyIDKey, keyID)
+		c.Next()
+	}
+}
+
+// createAPIKey handles POST /admin/api-keys and issues a key. The key is only
+// shown in this response.
+func createAPIKey(c *gin.Context) {
+	var req apiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: name must be between 1 and 255 characters"})
+		return
+	}
+
+	key, hash, err := newToken()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to generate API key"})
+		return
+	}
+	created := apiKey{KeyID: uuid.New().String(), Name: name, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `INSERT INTO api_keys (key_id, name, key_hash, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := db.ExecContext(ctx, query, created.KeyID, created.Name, hash, created.CreatedAt); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist API key"})
+		return
+	}
+	auditTarget(c, created.KeyID)
+	c.JSON(http.StatusCreated, gin.H{"keyID": created.KeyID, "name": created.Name, "createdAt": created.CreatedAt, "key": key})
+}
+
+// listAPIKeys handles GET /admin/api-keys and lists the issued keys, including
+// the revoked ones, newest first.
+func listAPIKeys(c *gin.Context) {
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, `SELECT key_id, name, created_at, revoked_at FROM api_keys ORDER BY created_at DESC, key_id`)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve API keys"})
+		return
+	}
+	defer rows.Close()
+	keys := []apiKey{}
+	for rows.Next() {
+		var key apiKey
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.KeyID, &key.Name, &key.CreatedAt, &revokedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve API keys"})
+			return
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// revokeAPIKey handles DELETE /admin/api-keys/:keyID. A revoked key is
+// rejected from then on but stays listed.
+func revokeAPIKey(c *gin.Context) {
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = `+dialect.now()+` WHERE key_id = ? AND revoked_at IS NULL`, c.Param("keyID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to revoke API key"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "API key not found or already revoked"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "API key revoked"})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Artist is an artist record that albums refer to by artistID.
+type Artist struct {
+	ArtistID  string    `json:"artistID"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// artistRequest is the JSON body accepted by POST /artists.
+type artistRequest struct {
+	Name string `json:"name"`
+}
+
+// ensureArtistTx returns the artistID for name within tx, creating the artist
+// if needed. An empty name has no artist record and yields a NULL artistID.
+func ensureArtistTx(ctx context.Context, tx *sql.Tx, name string) (sql.NullString, error) {
+	if name == "" {
+		return sql.NullString{}, nil
+	}
+	if _, err := tx.ExecContext(ctx, dialect.insertIgnore(`INSERT INTO artists (artist_id, name) VALUES (?, ?)`), uuid.New().String(), name); err != nil {
+		return sql.NullString{}, err
+	}
+	var artistID string
+	if err := tx.QueryRowContext(ctx, `SELECT artist_id FROM artists WHERE name = ?`, name).Scan(&artistID); err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: artistID, Valid: true}, nil
+}
+
+// ensureArtistsTx returns the artistIDs of the artist names of albums within
+// tx, creating the artists that do not exist with one insert. Empty names are
+// left out and yield a NULL artistID.
+func ensureArtistsTx(ctx context.Context, tx *sql.Tx, albums []newAlbum) (map[string]sql.NullString, error) {
+	artistIDs := map[string]sql.NullString{}
+	var rows []string
+	var args, names []any
+	for _, album := range albums {
+		name := album.Profile.Artist
+		if _, ok := artistIDs[name]; ok || name == "" {
+			continue
+		}
+		artistIDs[name] = sql.NullString{}
+		rows = append(rows, "(?, ?)")
+		args = append(args, uuid.New().String(), name)
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return artistIDs, nil
+	}
+	if _, err := tx.ExecContext(ctx, dialect.insertIgnore(`INSERT INTO artists (artist_id, name) VALUES `+strings.Join(rows, ", ")), args...); err != nil {
+		return nil, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	result, err := tx.QueryContext(ctx, `SELECT artist_id, name FROM artists WHERE name IN (`+placeholders+`)`, names...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var artistID, name string
+		if err := result.Scan(&artistID, &name); err != nil {
+			return nil, err
+		}
+		artistIDs[name] = sql.NullString{String: artistID, Valid: true}
+	}
+	return artistIDs, result.Err()
+}
+
+// createArtist handles POST /artists. Creating an artist whose name already
+// exists returns 409 with the existing artistID.
+func createArtist(c *gin.Context) {
+	var req artistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: name must be between 1 and 255 characters"})
+		return
+	}
+
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	artistID := uuid.New().String()
+	result, err := db.ExecContext(ctx, dialect.insertIgnore(`INSERT INTO artists (artist_id, name) VALUES (?, ?)`), artistID, name)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist artist"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var existingID string
+		if err := db.QueryRowContext(ctx, `SELECT artist_id FROM artists WHERE name = ?`, name).Scan(&existingID); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve artist"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"msg": "artist already exists", "artistID": existingID})
+		return
+	}
+	auditTarget(c, artistID)
+	c.JSON(http.StatusCreated, gin.H{"artistID": artistID, "name": name})
+}
+
+// listArtists handles GET /artists and lists artists by name, optionally
+// filtered by a name substring.
+func listArtists(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	query := `SELECT artist_id, name, created_at FROM artists`
+	var args []any
+	if name := strings.TrimSpace(c.Query("name")); name != "" {
+		query += ` WHERE name ` + dialect.like()
+		args = append(args, "%"+escapeLike(name)+"%")
+	}
+	query += ` ORDER BY name LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list artists"})
+		return
+	}
+	defer rows.Close()
+
+	artists := []Artist{}
+	for rows.Next() {
+		var artist Artist
+		if err := rows.Scan(&artist.ArtistID, &artist.Name, &artist.CreatedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list artists"})
+			return
+		}
+		artists = append(artists, artist)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list artists"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"artists": artists, "limit": limit, "offset": offset})
+}
+
+// getArtist handles GET /artists/:artistID.
+func getArtist(c *gin.Context) {
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	var artist Artist
+	query := `SELECT artist_id, name, created_at FROM artists WHERE artist_id = ?`
+	err := readDB(ctx).QueryRowContext(ctx, query, c.Param("artistID")).Scan(&artist.ArtistID, &artist.Name, &artist.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "artist not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve artist"})
+		return
+	}
+	c.JSON(http.StatusOK, artist)
+}
+
+// getArtistAlbums handles GET /artists/:artistID/albums and lists the albums
+// of an artist, newest first unless another sort order is requested.
+func getArtistAlbums(c *gin.Context) {
+	artistID := c.Param("artistID")
+	page, err := parseAlbumPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	var one int
+	err = readDB(ctx).QueryRowContext(ctx, `SELECT 1 FROM artists WHERE artist_id = ?`, artistID).Scan(&one)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "artist not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve artist"})
+		return
+	}
+
+	clause, args := page.clause([]string{"artist_id = ?", "deleted_at IS NULL"}, []any{artistID})
+	rows, err := readDB(ctx).QueryContext(ctx, `SELECT `+albumColumns+` FROM albums`+clause, args...)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list albums"})
+		return
+	}
+	albums, err := scanAlbums(rows)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list albums"})
+		return
+	}
+	response := page.response(albums)
+	response["artistID"] = artistID
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Gin context keys used by handlers to describe their change for the audit log.
+const (
+	auditTargetKey  = "auditTarget"  // ID of the record created by the request
+	auditDetailsKey = "auditDetails" // map[string]any of details to record
+)
+
+// readOnlyPostRoutes are POST routes that do not change any data and are not
+// recorded in the audit log.
+var readOnlyPostRoutes = map[string]bool{
+	"/albums/lookup": true,
+}
+
+// auditEntry is a recorded change, as listed by GET /admin/audit. Actor is
+// "admin", "user:<userID>", "subject:<sub>" (of a bearer token),
+// "apikey:<keyID>", "anonymous" or the name of a background job.
+type auditEntry struct {
+	AuditID   int64           `json:"auditID"`
+	CreatedAt time.Time       `json:"createdAt"`
+	RequestID string          `json:"requestID"`
+	Actor     string          `json:"actor"`
+	ClientIP  string          `json:"clientIP"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Status    int             `json:"status"`
+	Details   json.RawMessage `json:"details"`
+}
+
+// auditTarget records id as the target of the request's change, for requests
+// that create a record whose ID is not in the path.
+func auditTarget(c *gin.Context, id string) {
+	c.Set(auditTargetKey, id)
+}
+
+// auditDetail adds a detail about the request's change to its audit entry.
+// GET requests are only recorded when their handler adds a detail.
+func auditDetail(c *gin.Context, key string, value any) {
+	v, _ := c.Get(auditDetailsKey)
+	details, _ := v.(map[string]any)
+	if details == nil {
+		details = map[string]any{}
+		c.Set(auditDetailsKey, details)
+	}
+	details[key] = value
+}
+
+// auditMutations is a middleware that records every successful request that
+// changes data in the audit log once its handler has run. Failing to record an
+// entry is logged but does not fail the request, whose change is already
+// committed.
+func auditMutations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		v, _ := c.Get(auditDetailsKey)
+		details, _ := v.(map[string]any)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if details == nil {
+				return
+			}
+		}
+		route := c.FullPath()
+		if route == "" || c.Writer.Status() >= http.StatusBadRequest ||
+			c.Request.Method == http.MethodPost && readOnlyPostRoutes[route] {
+			return
+		}
+
+		if details == nil {
+			details = map[string]any{}
+		}
+		for _, param := range c.Params {
+			details[param.Key] = param.Value
+		}
+		if c.Request.URL.RawQuery != "" {
+			details["query"] = c.Request.URL.RawQuery
+		}
+		target := c.GetString(auditTargetKey)
+		if target == "" && len(c.Params) > 0 {
+			target = c.Params[0].Value
+		}
+		entry := auditEntry{
+			RequestID: c.GetString(requestIDKey),
+			Actor:     requestActor(c),
+			ClientIP:  c.ClientIP(),
+			Action:    c.Request.Method + " " + route,
+			Target:    target,
+			Status:    c.Writer.Status(),
+		}
+		if err := recordAudit(context.Background(), entry, details); err != nil {
+			logFor(c.Request.Context()).Error().Err(err).Msg("Error recording audit entry")
+		}
+	}
+}
+
+// requestActor returns who made a request, for its audit entry.
+func requestActor(c *gin.Context) string {
+	if c.GetBool(adminKey) {
+		return "admin"
+	}
+	if userID := c.GetString(userIDKey); userID != "" {
+		return "user:" + userID
+	}
+	if user := c.GetString(basicUserKey); user != "" {
+		return "basic:" + user
+	}
+	if subject := c.GetString(subjectKey); subject != "" {
+		return "subject:" + subject
+	}
+	if keyID := c.GetString(apiKeyIDKey); keyID != "" {
+		return "apikey:" + keyID
+	}
+	if keyID := c.GetString(signerKey); keyID != "" {
+		return "signer:" + keyID
+	}
+	return "anonymous"
+}
+
+// recordAudit inserts an audit entry with the details encoded as JSON.
+func recordAudit(ctx context.Context, entry auditEntry, details map[string]any) error {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO audit_log (created_at, request_id, actor, client_ip, action, target, status, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = db.ExecContext(ctx, query, time.Now().UTC().Truncate(time.Second), entry.RequestID, entry.Actor,
+		entry.ClientIP, entry.Action, entry.Target, entry.Status, string(encoded))
+	return err
+}
+
+// adminAudit handles GET /admin/audit and lists the audit entries, newest
+// first. The optional actor, action and target parameters select entries
+// with exactly that value, and since and until (RFC 3339) bound their time.
+func adminAudit(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	var conds []string
+	var args []any
+	for _, column := range []string{"actor", "action", "target"} {
+		if v := c.Query(column); v != "" {
+			conds = append(conds, column+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, bound := range []struct{ param, cmp string }{{"since", ">="}, {"until", "<"}} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + bound.param + " must be an RFC 3339 time"})
+			return
+		}
+		conds = append(conds, "created_at "+bound.cmp+" ?")
+		args = append(args, t.UTC())
+	}
+
+	query := `SELECT audit_id, created_at, request_id, actor, client_ip, action, target, status, details FROM audit_log`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY created_at DESC, audit_id DESC LIMIT ? OFFSET ?`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve audit log"})
+		return
+	}
+	defer rows.Close()
+	entries := []auditEntry{}
+	for rows.Next() {
+		var entry auditEntry
+		var details string
+		if err := rows.Scan(&entry.AuditID, &entry.CreatedAt, &entry.RequestID, &entry.Actor, &entry.ClientIP,
+			&entry.Action, &entry.Target, &entry.Status, &details); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve audit log"})
+			return
+		}
+		entry.Details = json.RawMessage(details)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "limit": limit, "offset": offset})
+}
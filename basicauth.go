@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+)
+
+// basicUserKey is the Gin context key holding the name of the user of a
+// request with valid Basic credentials.
+const basicUserKey = "basicUser"
+
+// basicChallenge asks clients for Basic credentials in 401 responses.
+const basicChallenge = `Basic realm="AlbumServer", charset="UTF-8"`
+
+// basicAuthUsers are the passwords of the users who may sign in with HTTP
+// Basic auth, set by BASIC_AUTH_USERS as a list of user:password, where the
+// password may be a bcrypt hash. Users named in BASIC_AUTH_ADMINS get the
+// admin role and the others the writer role. When there are users, changing
+// data needs credentials, while reading stays open to anonymousRole.
+var (
+	basicAuthUsers  map[string]string
+	basicAuthAdmins map[string]bool
+)
+
+// parseBasicAuthUsers parses the user:password entries of BASIC_AUTH_USERS.
+func parseBasicAuthUsers(entries []string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, entry := range entries {
+		name, password, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || password == "" {
+			return nil, fmt.Errorf("%q must be a user name and a password, as user:password", name)
+		}
+		users[name] = password
+	}
+	return users, nil
+}
+
+// checkBasicPassword reports whether password is the one of user, comparing
+// in constant time or with bcrypt for hashed passwords.
+func checkBasicPassword(user, password string) bool {
+	want, ok := basicAuthUsers[user]
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(want, "$2a$") || strings.HasPrefix(want, "$2b$") || strings.HasPrefix(want, "$2y$") {
+		return bcrypt.CompareHashAndPassword([]byte(want), []byte(password)) == nil
+	}
+	got, expected := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(got[:], expected[:]) == 1
+}
+
+// basicAuth is a middleware that checks the credentials of requests with an
+// Authorization: Basic header, rejecting invalid ones with 401. Requests
+// without them are left to the other credentials and to authorize.
+func basicAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(basicAuthUsers) == 0 || !strings.HasPrefix(c.GetHeader("Authorization"), "Basic ") {
+			c.Next()
+			return
+		}
+		user, password, ok := c.Request.BasicAuth()
+		if !ok || !checkBasicPassword(user, password) {
+			c.Header("WWW-Authenticate", basicChallenge)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid user name or password"})
+			return
+		}
+		c.Set(basicUserKey, user)
+		c.Next()
+	}
+}
+
+// basicRole returns the role of the Basic auth user of a request, or
+// roleNone without one.
+func basicRole(c *gin.Context) string {
+	user := c.GetString(basicUserKey)
+	switch {
+	case user == "":
+		return roleNone
+	case basicAuthAdmins[user]:
+		return roleAdmin
+	}
+	return roleWriter
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+)
+
+// maxBatchSize is the maximum number of albums accepted by a single batch upload.
+const maxBatchSize = 100
+
+// batchResult describes the outcome of a single item in a batch upload.
+type batchResult struct {
+	Index     int    `json:"index"`
+	AlbumID   string `json:"albumID,omitempty"`
+	ImageSize string `json:"imageSize,omitempty"`
+	Msg       string `json:"msg,omitempty"`
+
+	// Field names the first invalid profile field when the profile was
+	// rejected, and Fields lists all of them.
+	Field  string      `json:"field,omitempty"`
+	Fields fieldErrors `json:"fields,omitempty"`
+
+	// ExistingAlbumID is set when the image was rejected as a duplicate.
+	ExistingAlbumID string `json:"existingAlbumID,omitempty"`
+}
+
+// batchUploadAlbums handles POST /albums/batch. The multipart request carries
+// repeated 'image' files and 'profile' fields which are paired by position.
+// Each item is inserted independently and reported in the per-item results.
+// An optional ttl query parameter marks every album of the batch as temporary.
+func batchUploadAlbums(c *gin.Context) {
+	ttl, err := parseTTL(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	form, err := c.MultipartForm()
+	if respondTooLarge(c, err) {
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: multipart form is required"})
+		return
+	}
+
+	images := form.File["image"]
+	profiles := form.Value["profile"]
+	if len(images) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: at least one image is required"})
+		return
+	}
+	if len(images) != len(profiles) {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: number of images and profiles must match"})
+		return
+	}
+	if len(images) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: batch exceeds " + strconv.Itoa(maxBatchSize) + " albums"})
+		return
+	}
+
+	results := make([]batchResult, len(images))
+	succeeded := 0
+	for i, fileHeader := range images {
+		results[i].Index = i
+
+		// Unmarshal the profile paired with this image.
+		profile, err := parseProfile(profiles[i])
+		if err != nil {
+			results[i].Msg = "invalid request: " + err.Error()
+			if fields := invalidFields(err); len(fields) > 0 {
+				results[i].Field = fields[0].Field
+				results[i].Fields = fields
+			}
+			continue
+		}
+
+		// Read the image file content.
+		imageData, err := readImageFile(fileHeader)
+		if err != nil {
+			var typeErr *unsupportedImageError
+			if errors.As(err, &typeErr) {
+				results[i].Msg = "invalid request: " + typeErr.Error()
+				continue
+			}
+			if errors.Is(err, errImageTooLarge) {
+				results[i].Msg = imageTooLargeMsg()
+				continue
+			}
+			results[i].Msg = "failed to read image file"
+			continue
+		}
+
+		// Insert the album record.
+		albumID := uuid.New().String()
+		album := newAlbum{AlbumID: albumID, OwnerID: c.GetString(userIDKey), Profile: profile, TTL: ttl}
+		if err := insertAlbum(c.Request.Context(), album, imageData); err != nil {
+			var dup *duplicateImageError
+			if errors.As(err, &dup) {
+				results[i].Msg = "image already uploaded"
+				results[i].ExistingAlbumID = dup.AlbumID
+				continue
+			}
+			var quotaErr *quotaError
+			if errors.As(err, &quotaErr) {
+				results[i].Msg = quotaErr.Msg
+				continue
+			}
+			results[i].Msg = "failed to persist album data"
+			continue
+		}
+
+		results[i].AlbumID = albumID
+		results[i].ImageSize = strconv.Itoa(len(imageData))
+		succeeded++
+	}
+
+	var created []string
+	for _, result := range results {
+		if result.AlbumID != "" {
+			created = append(created, result.AlbumID)
+		}
+	}
+	auditDetail(c, "albumIDs", created)
+	c.JSON(http.StatusOK, gin.H{
+		"succeeded": succeeded,
+		"failed":    len(images) - succeeded,
+		"results":   results,
+	})
+}
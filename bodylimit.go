@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Request bodies are capped by their type: JSON bodies at maxJSONBodySize
+// (MAX_JSON_BODY_BYTES), multipart forms at maxMultipartBodySize
+// (MAX_MULTIPART_BODY_BYTES) and other bodies at maxBodySize (MAX_BODY_BYTES).
+// Upload routes set their own caps with limitUploadSize, and imports are
+// capped at maxImportBodySize (MAX_IMPORT_BODY_BYTES).
+var (
+	maxJSONBodySize      int64 = 1 << 20
+	maxMultipartBodySize int64 = 8 << 20
+	maxBodySize          int64 = 8 << 20
+	maxImportBodySize    int64 = 1 << 30
+)
+
+// rawBodyKey is the Gin context key holding the request body before it was
+// capped by limitBodies, so limitBodySize can set another cap.
+const rawBodyKey = "rawBody"
+
+// limitBodies is a middleware that caps every request body at the limit for
+// its content type, so a single oversized request cannot exhaust the memory
+// or the disk of the instance. JSON requests whose Content-Length is over the
+// limit are rejected with 413 before their body is read; other bodies fail
+// when they are read past the limit, as the routes may raise it.
+func limitBodies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		limit := maxBodySize
+		mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		switch mediaType {
+		case "application/json":
+			limit = maxJSONBodySize
+		case "multipart/form-data":
+			limit = maxMultipartBodySize
+		}
+		if mediaType == "application/json" && c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"msg": bodyTooLargeMsg(limit)})
+			return
+		}
+		c.Set(rawBodyKey, c.Request.Body)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// limitBodySize is a middleware that caps the request body at limit bytes,
+// replacing the cap set by limitBodies, which may be lower.
+func limitBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"msg": bodyTooLargeMsg(limit)})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, rawBody(c), limit)
+		c.Next()
+	}
+}
+
+// rawBody returns the request body without the cap of limitBodies.
+func rawBody(c *gin.Context) io.ReadCloser {
+	if raw, ok := c.Get(rawBodyKey); ok {
+		return raw.(io.ReadCloser)
+	}
+	return c.Request.Body
+}
+
+// bodyTooLargeMsg is the message of responses to bodies over limit bytes.
+func bodyTooLargeMsg(limit int64) string {
+	return "request body exceeds the maximum size of " + strconv.FormatInt(limit, 10) + " bytes"
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Images are copied through buffers reused across requests rather than
+// allocated by each upload and download, which at high request rates would
+// keep the garbage collector busy.
+
+// copyBufferSize is the size of the chunks images are copied in.
+const copyBufferSize = 32 << 10
+
+// maxPooledBufferSize is the capacity above which an image buffer is left to
+// the garbage collector instead of being pooled, so a few large images do not
+// keep their memory.
+const maxPooledBufferSize = 8 << 20
+
+var (
+	copyBuffers  = sync.Pool{New: func() any { buf := make([]byte, copyBufferSize); return &buf }}
+	headReaders  = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, imageHeadSize) }}
+	stripReaders = sync.Pool{New: func() any { return bufio.NewReader(nil) }}
+	imageBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// copyImage copies src to dst in chunks of a pooled buffer. The ReadFrom
+// methods of dst, such as that of files, are bypassed as they allocate their
+// own buffer for readers that are not files.
+func copyImage(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
+
+// getBufferedReader returns a reader of r buffered by a reader of pool, to be
+// handed back with putBufferedReader.
+func getBufferedReader(pool *sync.Pool, r io.Reader) *bufio.Reader {
+	buffered := pool.Get().(*bufio.Reader)
+	buffered.Reset(r)
+	return buffered
+}
+
+func putBufferedReader(pool *sync.Pool, buffered *bufio.Reader) {
+	buffered.Reset(nil)
+	pool.Put(buffered)
+}
+
+// getImageBuffer returns an empty pooled buffer, to be handed back with
+// putImageBuffer once its bytes are no longer used.
+func getImageBuffer() *bytes.Buffer {
+	buf := imageBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putImageBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		imageBuffers.Put(buf)
+	}
+}
+
+// readImage reads r to the end into a slice allocated once for the size of
+// the image, when it is known, instead of growing it as io.ReadAll does.
+func readImage(r io.Reader, size int64) ([]byte, error) {
+	if size <= 0 || size > int64(maxImageSize) {
+		return io.ReadAll(r)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size+bytes.MinRead))
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"mime/multipart"
+	"testing"
+)
+
+// benchmarkImage returns a JPEG cover of about 480 KB.
+func benchmarkImage(b *testing.B) []byte {
+	imageData, err := seedImage(rand.New(rand.NewPCG(1, 2)), 1000, 1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return imageData
+}
+
+func BenchmarkStreamImage(b *testing.B) {
+	imageData := benchmarkImage(b)
+	imageStore = &fsImageStore{dir: b.TempDir()}
+	b.SetBytes(int64(len(imageData)))
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := streamImage(context.Background(), bytes.NewReader(imageData)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadImageFile(b *testing.B) {
+	imageData := benchmarkImage(b)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("image", "cover.jpg")
+	part.Write(imageData)
+	w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(64 << 20)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer form.RemoveAll()
+	b.SetBytes(int64(len(imageData)))
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := readImageFile(form.File["image"][0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// buildCommit and buildTime describe the build of the server, set with
+//
+//	go build -ldflags "-X main.buildCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// They fall back to the revision and commit time recorded by the Go
+// toolchain when the server is built from a git checkout without them.
+var (
+	buildCommit string
+	buildTime   string
+)
+
+// startTime is the time the server started at.
+var startTime = time.Now()
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		case "vcs.time":
+			if buildTime == "" {
+				buildTime = setting.Value
+			}
+		}
+	}
+	if buildCommit == "" && revision != "" {
+		buildCommit = revision
+		if modified == "true" {
+			buildCommit += "-dirty"
+		}
+	}
+}
+
+// getVersion handles GET /version and returns the commit and build time of
+// the server, the Go version it was built with and how long it has been up,
+// so the deployed build can be checked behind the load balancer.
+func getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"commit":    buildCommit,
+		"buildTime": buildTime,
+		"goVersion": runtime.Version(),
+		"startedAt": startTime.UTC().Truncate(time.Second),
+		"uptime":    time.Since(startTime).Truncate(time.Second).String(),
+	})
+}
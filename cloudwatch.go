@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxEMFValues is the number of latencies sent for a route per interval, the
+// most a metric of the embedded metric format may hold. Busier routes send a
+// uniform sample of their latencies.
+const maxEMFValues = 100
+
+// cloudWatch sends the request rates, latencies and error counts of every
+// route to CloudWatch in the embedded metric format, for AWS deployments
+// without Prometheus. It is enabled by CLOUDWATCH_NAMESPACE, nil otherwise.
+var cloudWatch *cloudWatchEmitter
+
+// cloudWatchEmitter aggregates the requests of each route and writes them
+// every interval as EMF documents, to stdout for the awslogs driver or the
+// Lambda runtime, or to the CloudWatch agent.
+type cloudWatchEmitter struct {
+	namespace  string
+	dimensions [][2]string // Dimensions added to every metric, in order
+	interval   time.Duration
+	endpoint   *url.URL // tcp:// or udp:// address of the CloudWatch agent, or nil for stdout
+
+	mu     sync.Mutex
+	routes map[string]*routeStats
+}
+
+// routeStats are the requests of a route during an interval.
+type routeStats struct {
+	requests     int
+	clientErrors int
+	serverErrors int
+	latencies    []float64 // Milliseconds, sampled down to maxEMFValues
+}
+
+// newCloudWatchEmitter reads the CloudWatch settings from the environment:
+// CLOUDWATCH_NAMESPACE, CLOUDWATCH_DIMENSIONS as a list of name=value added
+// to every metric, CLOUDWATCH_INTERVAL (1m by default) and
+// CLOUDWATCH_EMF_ENDPOINT, such as tcp://127.0.0.1:25888 for the agent. It
+// returns nil when CLOUDWATCH_NAMESPACE is not set.
+func newCloudWatchEmitter() (*cloudWatchEmitter, error) {
+	namespace := os.Getenv("CLOUDWATCH_NAMESPACE")
+	if namespace == "" {
+		return nil, nil
+	}
+	e := &cloudWatchEmitter{
+		namespace: namespace,
+		interval:  getEnvDuration("CLOUDWATCH_INTERVAL", time.Minute),
+		routes:    map[string]*routeStats{},
+	}
+	if e.interval < time.Second {
+		return nil, fmt.Errorf("CLOUDWATCH_INTERVAL must be at least 1s, got %v", e.interval)
+	}
+	for _, entry := range getEnvList("CLOUDWATCH_DIMENSIONS", nil) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || value == "" || name == "Route" {
+			return nil, fmt.Errorf("CLOUDWATCH_DIMENSIONS entry %q must be name=value, with a name other than Route", entry)
+		}
+		e.dimensions = append(e.dimensions, [2]string{name, value})
+	}
+	if endpoint := os.Getenv("CLOUDWATCH_EMF_ENDPOINT"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme != "tcp" && u.Scheme != "udp" || u.Host == "" {
+			return nil, fmt.Errorf("CLOUDWATCH_EMF_ENDPOINT must be a tcp:// or udp:// address, got %q", endpoint)
+		}
+		e.endpoint = u
+	}
+	return e, nil
+}
+
+// observe records a request to route answered with status after took.
+func (e *cloudWatchEmitter) observe(route string, status int, took time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := e.routes[route]
+	if stats == nil {
+		stats = &routeStats{}
+		e.routes[route] = stats
+	}
+	stats.requests++
+	switch {
+	case status >= 500:
+		stats.serverErrors++
+	case status >= 400:
+		stats.clientErrors++
+	}
+	ms := float64(took.Microseconds()) / 1000
+	if len(stats.latencies) < maxEMFValues {
+		stats.latencies = append(stats.latencies, ms)
+	} else if i := rand.IntN(stats.requests); i < maxEMFValues {
+		stats.latencies[i] = ms
+	}
+}
+
+// run writes the metrics of the past interval every interval.
+func (e *cloudWatchEmitter) run() {
+	for range time.Tick(e.interval) {
+		if err := e.flush(time.Now()); err != nil {
+			logger.Error().Err(err).Msg("Error sending CloudWatch metrics")
+		}
+	}
+}
+
+// flush writes one EMF document per route with requests since the last
+// flush. The metrics are published by route and, summed over the routes,
+// with the configured dimensions only.
+func (e *cloudWatchEmitter) flush(now time.Time) error {
+	e.mu.Lock()
+	routes := e.routes
+	e.routes = map[string]*routeStats{}
+	e.mu.Unlock()
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, d := range e.dimensions {
+		names = append(names, d[0])
+	}
+	byRoute := append(append([]string{}, names...), "Route")
+	directive := map[string]any{
+		"Namespace":  e.namespace,
+		"Dimensions": [][]string{byRoute, append([]string{}, names...)},
+		"Metrics": []map[string]string{
+			{"Name": "Requests", "Unit": "Count"},
+			{"Name": "ClientErrors", "Unit": "Count"},
+			{"Name": "ServerErrors", "Unit": "Count"},
+			{"Name": "Latency", "Unit": "Milliseconds"},
+		},
+	}
+	var docs [][]byte
+	for route, stats := range routes {
+		doc := map[string]any{
+			"_aws": map[string]any{
+				"Timestamp":         now.UnixMilli(),
+				"CloudWatchMetrics": []any{directive},
+			},
+			"Route":        route,
+			"Requests":     stats.requests,
+			"ClientErrors": stats.clientErrors,
+			"ServerErrors": stats.serverErrors,
+			"Latency":      stats.latencies,
+		}
+		for _, d := range e.dimensions {
+			doc[d[0]] = d[1]
+		}
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, append(line, '\n'))
+	}
+	return e.write(docs)
+}
+
+// write sends EMF documents, one per line, to stdout or to the endpoint,
+// where every document is a datagram over UDP.
+func (e *cloudWatchEmitter) write(docs [][]byte) error {
+	var out io.Writer = os.Stdout
+	if e.endpoint != nil {
+		conn, err := net.DialTimeout(e.endpoint.Scheme, e.endpoint.Host, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		out = conn
+	}
+	for _, doc := range docs {
+		if _, err := out.Write(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
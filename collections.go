@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Collection is a named, ordered list of albums.
+type Collection struct {
+	CollectionID string    `json:"collectionID"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// collectionRequest is the JSON body accepted by POST /collections.
+type collectionRequest struct {
+	Name string `json:"name"`
+}
+
+// collectionAlbumRequest is the JSON body accepted by POST /collections/:collectionID/albums.
+type collectionAlbumRequest struct {
+	AlbumID string `json:"albumID"`
+}
+
+// findCollection reads a collection, writing a 404 or 500 response and
+// returning false when it cannot be found.
+func findCollection(c *gin.Context, collectionID string) (Collection, bool) {
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	var collection Collection
+	query := `SELECT collection_id, name, created_at FROM collections WHERE collection_id = ?`
+	err := readDB(ctx).QueryRowContext(ctx, query, collectionID).Scan(&collection.CollectionID, &collection.Name, &collection.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "collection not found"})
+		return collection, false
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve collection"})
+		return collection, false
+	}
+	return collection, true
+}
+
+// createCollection handles POST /collections.
+func createCollection(c *gin.Context) {
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: name must be between 1 and 255 characters"})
+		return
+	}
+
+	collection := Collection{
+		CollectionID: uuid.New().String(),
+		Name:         name,
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `INSERT INTO collections (collection_id, name, created_at) VALUES (?, ?, ?)`
+	if _, err := db.ExecContext(ctx, query, collection.CollectionID, collection.Name, collection.CreatedAt); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist collection"})
+		return
+	}
+	auditTarget(c, collection.CollectionID)
+	c.JSON(http.StatusCreated, collection)
+}
+
+// listCollections handles GET /collections, newest first.
+func listCollections(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	query := `SELECT collection_id, name, created_at FROM collections
+		ORDER BY created_at DESC, collection_id LIMIT ? OFFSET ?`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list collections"})
+		return
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		var collection Collection
+		if err := rows.Scan(&collection.CollectionID, &collection.Name, &collection.CreatedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list collections"})
+			return
+		}
+		collections = append(collections, collection)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to list collections"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collections": collections, "limit": limit, "offset": offset})
+}
+
+// getCollection handles GET /collections/:collectionID and returns the
+// collection with its albums in the order they were added.
+func getCollection(c *gin.Context) {
+	collection, ok := findCollection(c, c.Param("collectionID"))
+	if !ok {
+		return
+	}
+
+	albums, err := albumRepo.CollectionAlbums(c.Request.Context(), collection.CollectionID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve collection albums"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"collectionID": collection.CollectionID,
+		"name":         collection.Name,
+		"createdAt":    collection.CreatedAt,
+		"albums":       albums,
+	})
+}
+
+// addCollectionAlbum handles POST /collections/:collectionID/albums and
+// appends an album to the end of the collection. Adding an album that is
+// already in the collection keeps its current position.
+func addCollectionAlbum(c *gin.Context) {
+	var req collectionAlbumRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.AlbumID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: albumID is required"})
+		return
+	}
+	collection, ok := findCollection(c, c.Param("collectionID"))
+	if !ok || !requireAlbum(c, req.AlbumID) {
+		return
+	}
+
+	// Lock the collection row so concurrent appends get distinct positions.
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to add album to collection"})
+		return
+	}
+	defer tx.Rollback()
+	var lockedID string
+	if err := tx.QueryRowContext(ctx, `SELECT collection_id FROM collections WHERE collection_id = ?`+dialect.forUpdate(), collection.CollectionID).Scan(&lockedID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to add album to collection"})
+		return
+	}
+	query := `INSERT INTO collection_albums (collection_id, album_id, position)
+		SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM collection_albums WHERE collection_id = ?`
+	if _, err := tx.ExecContext(ctx, dialect.insertIgnore(query), collection.CollectionID, req.AlbumID, collection.CollectionID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to add album to collection"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to add album to collection"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"collectionID": collection.CollectionID, "albumID": req.AlbumID})
+}
+
+// removeCollectionAlbum handles DELETE /collections/:collectionID/albums/:albumID.
+func removeCollectionAlbum(c *gin.Context) {
+	collection, ok := findCollection(c, c.Param("collectionID"))
+	if !ok {
+		return
+	}
+	albumID := c.Param("albumID")
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `DELETE FROM collection_albums WHERE collection_id = ? AND album_id = ?`
+	result, err := db.ExecContext(ctx, query, collection.CollectionID, albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to remove album from collection"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "album not in collection"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "album removed from collection"})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+)
+
+// coverColors are the colors of a cover returned with the album, so clients
+// can render a matching placeholder while the image loads.
+type coverColors struct {
+	Dominant string `json:"dominant"`
+	Average  string `json:"average"`
+}
+
+// extractColors returns the dominant and average colors of an image as
+// "#rrggbb". The image is scaled down to 32x32 pixels first; the dominant
+// color is the mean of the most common bucket of pixels with 4 bits per
+// channel. Mostly transparent pixels are ignored.
+func extractColors(src image.Image) coverColors {
+	small := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.BiLinear.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	type bucket struct {
+		r, g, b, n int
+	}
+	var buckets [4096]bucket
+	var total bucket
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := small.RGBAAt(x, y)
+			if c.A < 128 {
+				continue
+			}
+			// Undo the premultiplied alpha of partly transparent pixels.
+			r, g, b := int(c.R)*255/int(c.A), int(c.G)*255/int(c.A), int(c.B)*255/int(c.A)
+			for _, bk := range []*bucket{&buckets[r>>4<<8|g>>4<<4|b>>4], &total} {
+				bk.r, bk.g, bk.b, bk.n = bk.r+r, bk.g+g, bk.b+b, bk.n+1
+			}
+		}
+	}
+	if total.n == 0 {
+		return coverColors{}
+	}
+	dominant := buckets[0]
+	for _, b := range buckets {
+		if b.n > dominant.n {
+			dominant = b
+		}
+	}
+	mean := func(b bucket) string {
+		return hexColor(color.RGBA{uint8(b.r / b.n), uint8(b.g / b.n), uint8(b.b / b.n), 255})
+	}
+	return coverColors{Dominant: mean(dominant), Average: mean(total)}
+}
+
+// hexColor formats a color as "#rrggbb".
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
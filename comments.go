@@ -0,0 +1,111 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxAuthorLength      = 255  // Maximum length of a comment author
+	maxCommentBodyLength = 4000 // Maximum length of a comment body
+)
+
+// Comment is a comment left on an album.
+type Comment struct {
+	CommentID int64     `json:"commentID"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// commentRequest is the JSON body accepted by POST /albums/:albumID/comments.
+type commentRequest struct {
+	Author string `json:"author"`
+	Body   string `json:"body"`
+}
+
+// postComment handles POST /albums/:albumID/comments and stores a new comment.
+func postComment(c *gin.Context) {
+	albumID := c.Param("albumID")
+	var req commentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	author := strings.TrimSpace(req.Author)
+	body := strings.TrimSpace(req.Body)
+	if author == "" || len(author) > maxAuthorLength {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: author must be between 1 and 255 characters"})
+		return
+	}
+	if body == "" || len(body) > maxCommentBodyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body must be between 1 and 4000 characters"})
+		return
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO comments (album_id, author, body, created_at) VALUES (?, ?, ?, ?)`
+	commentID, err := dialect.insertID(ctx, query, "comment_id", albumID, author, body, createdAt)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist comment"})
+		return
+	}
+	auditDetail(c, "commentID", commentID)
+	c.JSON(http.StatusCreated, Comment{
+		CommentID: commentID,
+		Author:    author,
+		Body:      body,
+		CreatedAt: createdAt,
+	})
+}
+
+// getComments handles GET /albums/:albumID/comments and lists the comments of
+// an album, newest first.
+func getComments(c *gin.Context) {
+	albumID := c.Param("albumID")
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	query := `SELECT comment_id, author, body, created_at FROM comments WHERE album_id = ?
+		ORDER BY created_at DESC, comment_id DESC LIMIT ? OFFSET ?`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, albumID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve comments"})
+		return
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(&comment.CommentID, &comment.Author, &comment.Body, &comment.CreatedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve comments"})
+			return
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve comments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "comments": comments, "limit": limit, "offset": offset})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compression selects the encodings of the responses, set by
+// COMPRESSION_ENCODINGS in order of preference ("off" disables compression),
+// and the smallest body compressed, set by COMPRESSION_MIN_BYTES, as the
+// headers of a compressed short body outweigh what it saves.
+var compression = struct {
+	encodings []string
+	minSize   int
+}{encodings: []string{"br", "gzip"}, minSize: 1024}
+
+// compressibleTypes are the media types of the responses that are compressed:
+// the JSON bodies and the exports. Images are stored compressed already, so
+// their responses are sent as they are.
+var compressibleTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"text/csv":             true,
+}
+
+// brotliLevel trades some of the compression of brotli for the speed needed
+// to compress responses as they are sent.
+const brotliLevel = 4
+
+// The encoders are reused across responses, as they allocate large buffers.
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, brotliLevel) }}
+)
+
+// encoder is implemented by the gzip and brotli writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// parseCompressionEncodings checks the encodings of COMPRESSION_ENCODINGS.
+func parseCompressionEncodings(encodings []string) ([]string, error) {
+	if len(encodings) == 1 && strings.EqualFold(encodings[0], "off") {
+		return nil, nil
+	}
+	for i, encoding := range encodings {
+		encoding = strings.ToLower(encoding)
+		if encoding != "br" && encoding != "gzip" {
+			return nil, fmt.Errorf("COMPRESSION_ENCODINGS must be some of br and gzip, or off, got %q", encodings[i])
+		}
+		encodings[i] = encoding
+	}
+	return encodings, nil
+}
+
+// negotiateEncoding returns the encoding of compression.encodings the client
+// prefers according to its Accept-Encoding header, or "" when it accepts none
+// of them.
+func negotiateEncoding(header string) string {
+	best, bestQuality := "", 0.0
+	for _, encoding := range compression.encodings {
+		if q := acceptedQuality(header, encoding); q > bestQuality {
+			best, bestQuality = encoding, q
+		}
+	}
+	return best
+}
+
+// acceptedQuality returns the quality Accept-Encoding gives to encoding,
+// either by its name or by "*", or 0 when it is not accepted.
+func acceptedQuality(header, encoding string) float64 {
+	quality := 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		if strings.EqualFold(name, encoding) {
+			return q
+		}
+		quality = q
+	}
+	return quality
+}
+
+// compressResponses is a middleware that compresses the JSON and export
+// responses with the encoding negotiated with the client. Bodies are held
+// until they reach compression.minSize, so short ones are sent as they are.
+// While compression is enabled these responses vary by Accept-Encoding, even
+// when they are not compressed.
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(compression.encodings) == 0 {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if c.Request.Method == http.MethodHead {
+			encoding = ""
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		c.Next()
+		if err := w.close(); err != nil {
+			logFor(c.Request.Context()).Warn().Err(err).Msg("failed to compress response")
+		}
+	}
+}
+
+// compressWriter compresses the body of a response once its headers show it
+// is compressible and it has reached compression.minSize or is flushed. Its
+// encoding is empty when the body is never compressed.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	varied   bool    // whether Vary is set
+	decided  bool    // whether the body is sent compressed or as is
+	buf      []byte  // the start of the body, before it is decided
+	enc      encoder // the encoder of a compressed body
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.varyEncoding()
+	if !w.decided && !w.compressible() {
+		w.decided = true
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= compression.minSize {
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written reports whether the body has started, even while it is held.
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.enc != nil || w.ResponseWriter.Written()
+}
+
+// Flush starts compressing a held body, as streamed responses flush their
+// records before they add up to compression.minSize.
+func (w *compressWriter) Flush() {
+	w.varyEncoding()
+	if !w.decided && len(w.buf) > 0 {
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// varyEncoding adds Accept-Encoding to the Vary header of a response of a
+// compressible type, compressed or not, before its headers are sent, so that
+// caches do not serve its uncompressed body to clients accepting compressed
+// ones or the reverse.
+func (w *compressWriter) varyEncoding() {
+	if w.varied || w.ResponseWriter.Written() {
+		return
+	}
+	w.varied = true
+	if w.compressibleType() {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+}
+
+// compressibleType reports whether the Content-Type of the response is one of
+// compressibleTypes.
+func (w *compressWriter) compressibleType() bool {
+	mediaType, _, _ := strings.Cut(w.Header().Get("Content-Type"), ";")
+	return compressibleTypes[strings.ToLower(strings.TrimSpace(mediaType))]
+}
+
+// compressible reports whether the headers of the response allow compressing
+// its body.
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if w.encoding == "" || !w.compressibleType() ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	switch w.Status() {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < compression.minSize {
+		return false
+	}
+	return true
+}
+
+// start sends the headers of a compressed body and the part of the body held
+// so far.
+func (w *compressWriter) start() error {
+	w.decided = true
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	// The compressed body is another representation of the resource, with
+	// the same content.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	if w.encoding == "br" {
+		w.enc = brotliWriters.Get().(*brotli.Writer)
+	} else {
+		w.enc = gzipWriters.Get().(*gzip.Writer)
+	}
+	w.enc.Reset(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.enc.Write(buf)
+	return err
+}
+
+// close ends the body of the response: it sends a held body as it is, or ends
+// the compressed one and returns its encoder to its pool.
+func (w *compressWriter) close() error {
+	w.varyEncoding()
+	if !w.decided {
+		w.decided = true
+		if len(w.buf) > 0 {
+			_, err := w.ResponseWriter.Write(w.buf)
+			return err
+		}
+		return nil
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	switch enc := w.enc.(type) {
+	case *brotli.Writer:
+		enc.Reset(nil)
+		brotliWriters.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(nil)
+		gzipWriters.Put(enc)
+	}
+	w.enc = nil
+	return err
+}
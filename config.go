@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnv returns the value of the environment variable key, or def when unset.
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// getEnvList returns the comma-separated values of the environment variable
+// key with surrounding whitespace removed, or def when unset.
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var values []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// getEnvInt returns the integer value of the environment variable key, or def
+// when it is unset or not a valid integer.
+func getEnvInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// getEnvBool returns the boolean value of the environment variable key, or def
+// when it is unset or not a valid boolean.
+func getEnvBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// getEnvDuration returns the duration value of the environment variable key
+// (e.g. "5s"), or def when it is unset or not a valid duration.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// getEnvFloat returns the floating-point value of the environment variable
+// key, or def when it is unset or not a valid number.
+func getEnvFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
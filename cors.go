@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig lists what browser front-ends on other origins may do, set by
+// the CORS_* environment variables. Cross-origin requests are not allowed
+// when AllowedOrigins is empty.
+type corsConfig struct {
+	// AllowedOrigins are origins such as https://app.example.com, patterns
+	// such as https://*.example.com for every subdomain, or "*" for any origin,
+	// which is not allowed together with AllowCredentials.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a front-end may send, or "*"
+	// for any header.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration // How long browsers may cache a preflight response
+}
+
+// cors is the CORS configuration of the server.
+var cors = corsConfig{
+	AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "DELETE"},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "X-API-Key", "X-Request-ID", "X-User-Token"},
+	ExposedHeaders: []string{"ETag", "Retry-After", "X-Request-ID"},
+	MaxAge:         10 * time.Minute,
+}
+
+// allowsOrigin reports whether requests from origin are allowed.
+func (cfg corsConfig) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range cfg.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok &&
+			strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether the comma-separated request headers of a
+// preflight request may be sent.
+func (cfg corsConfig) allowsHeaders(headers string) bool {
+	if slices.Contains(cfg.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range strings.Split(headers, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !slices.ContainsFunc(cfg.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, header) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleCORS is a middleware that answers the preflight requests of browsers
+// and adds the CORS headers to the responses to allowed origins. Preflight
+// requests are answered before any other middleware, as browsers send them
+// without credentials; disallowed ones get no CORS headers, so the browser
+// blocks the request that follows.
+func handleCORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		allowed := cors.allowsOrigin(origin)
+		allowOrigin := origin
+		if slices.Contains(cors.AllowedOrigins, "*") && !cors.AllowCredentials {
+			allowOrigin = "*"
+		}
+
+		method := c.GetHeader("Access-Control-Request-Method")
+		if c.Request.Method == http.MethodOptions && method != "" {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			headers := c.GetHeader("Access-Control-Request-Headers")
+			if allowed && slices.Contains(cors.AllowedMethods, strings.ToUpper(method)) && cors.allowsHeaders(headers) {
+				c.Header("Access-Control-Allow-Origin", allowOrigin)
+				c.Header("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+				if headers != "" {
+					c.Header("Access-Control-Allow-Headers", headers)
+				}
+				if cors.AllowCredentials {
+					c.Header("Access-Control-Allow-Credentials", "true")
+				}
+				c.Header("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			if len(cors.ExposedHeaders) > 0 {
+				c.Header("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			}
+			if cors.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		c.Next()
+	}
+}
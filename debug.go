@@ -0,0 +1,37 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// serveDebug serves the pprof profiles under /debug/pprof/ and the runtime
+// memory statistics under /debug/vars on a separate listener, so profiles
+// can be captured during load tests without exposing them on the API port.
+// It is started when DEBUG_ADDR is set, which should be a loopback or
+// private address such as localhost:6060.
+func serveDebug(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Error().Err(err).Str("addr", addr).Msg("Error serving the debug endpoints")
+		}
+	}()
+	logger.Info().Str("addr", addr).Msg("Serving the debug endpoints")
+	return nil
+}
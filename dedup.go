@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Stored images are deduplicated by content hash. The image_objects table maps
+// each image hash to the one stored object holding that content and counts the
+// album_images rows referring to it. An upload whose content is already stored
+// is pointed at the existing object and its own copy is deleted, and an object
+// is only deleted once its last reference is released. Images without a hash,
+// such as direct uploads, and images stored before deduplication have no
+// image_objects row and are owned by their single album_images row.
+
+// acquireImageTx adds a reference to the stored object with the content of the
+// image stored under key, and returns the key album_images should refer to.
+// When that key differs from key, the caller's copy is unused and is deleted
+// once the transaction is committed.
+func acquireImageTx(ctx context.Context, tx *sql.Tx, key, imageHash string) (string, error) {
+	if imageHash == "" {
+		return key, nil
+	}
+	query := `INSERT INTO image_objects (storage_key, image_hash, ref_count) VALUES (?, ?, 1) ` +
+		dialect.onConflictUpdate("image_hash") + ` ref_count = image_objects.ref_count + 1`
+	if _, err := tx.ExecContext(ctx, query, key, imageHash); err != nil {
+		return "", err
+	}
+	var objectKey string
+	err := tx.QueryRowContext(ctx, `SELECT storage_key FROM image_objects WHERE image_hash = ?`, imageHash).Scan(&objectKey)
+	return objectKey, err
+}
+
+// acquireImagesTx adds the references of the primary images of albums like
+// acquireImageTx, with one insert, and returns the key album_images should
+// refer to by image hash.
+func acquireImagesTx(ctx context.Context, tx *sql.Tx, albums []newAlbum) (map[string]string, error) {
+	refs := map[string]int{}
+	var hashes []any
+	var firstKeys []string
+	for _, album := range albums {
+		if hash := album.Image.Hash; hash != "" {
+			if refs[hash] == 0 {
+				hashes = append(hashes, hash)
+				firstKeys = append(firstKeys, album.Image.Key)
+			}
+			refs[hash]++
+		}
+	}
+	objectKeys := map[string]string{}
+	if len(hashes) == 0 {
+		return objectKeys, nil
+	}
+	var rows []string
+	var args []any
+	for i, hash := range hashes {
+		rows = append(rows, "(?, ?, ?)")
+		args = append(args, firstKeys[i], hash, refs[hash.(string)])
+	}
+	query := `INSERT INTO image_objects (storage_key, image_hash, ref_count) VALUES ` + strings.Join(rows, ", ") + ` ` +
+		dialect.onConflictUpdate("image_hash") + ` ref_count = image_objects.ref_count + ` + dialect.inserted("ref_count")
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(hashes)), ",")
+	result, err := tx.QueryContext(ctx, `SELECT storage_key, image_hash FROM image_objects WHERE image_hash IN (`+placeholders+`)`, hashes...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var key, hash string
+		if err := result.Scan(&key, &hash); err != nil {
+			return nil, err
+		}
+		objectKeys[hash] = key
+	}
+	return objectKeys, result.Err()
+}
+
+// releaseImageTx removes a reference to the object stored under key and
+// reports whether the object is no longer referenced, in which case the caller
+// deletes it once the transaction is committed.
+func releaseImageTx(ctx context.Context, tx *sql.Tx, key string) (bool, error) {
+	var refCount int
+	err := tx.QueryRowContext(ctx, `SELECT ref_count FROM image_objects WHERE storage_key = ?`+dialect.forUpdate(), key).Scan(&refCount)
+	if err == sql.ErrNoRows {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if refCount > 1 {
+		_, err = tx.ExecContext(ctx, `UPDATE image_objects SET ref_count = ref_count - 1 WHERE storage_key = ?`, key)
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM image_objects WHERE storage_key = ?`, key)
+	return true, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxBulkDelete = 1000 // Maximum number of albumIDs in one bulk delete
+
+// deleteResult describes the outcome of deleting a single album.
+type deleteResult struct {
+	AlbumID string `json:"albumID"`
+	Deleted bool   `json:"deleted"`
+	Msg     string `json:"msg,omitempty"`
+}
+
+// deleteAlbumTx permanently deletes an album and its rows in albumChildTables within tx.
+// It reports whether the album existed and returns the storage keys of the
+// images no other album refers to, which the caller deletes from the image
+// store after committing.
+func deleteAlbumTx(ctx context.Context, tx *sql.Tx, albumID string) (bool, []string, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM albums WHERE album_id = ?`, albumID)
+	if err != nil {
+		return false, nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT storage_key FROM album_images WHERE album_id = ?`, albumID)
+	if err != nil {
+		return false, nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return false, nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return false, nil, err
+	}
+	rows.Close()
+
+	var unused []string
+	for _, key := range keys {
+		released, err := releaseImageTx(ctx, tx, key)
+		if err != nil {
+			return false, nil, err
+		}
+		if released {
+			unused = append(unused, key)
+		}
+	}
+
+	for _, table := range albumChildTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE album_id = ?", albumID); err != nil {
+			return false, nil, err
+		}
+	}
+	return true, unused, nil
+}
+
+// bulkDeleteAlbums handles DELETE /admin/albums. The body is a JSON array of
+// albumIDs which are marked as deleted together; the response reports
+// whether each album was found and deleted. Deleted albums can be restored
+// until they are purged.
+func bulkDeleteAlbums(c *gin.Context) {
+	var albumIDs []string
+	if err := c.ShouldBindJSON(&albumIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body must be a JSON array of albumIDs"})
+		return
+	}
+	if len(albumIDs) == 0 || len(albumIDs) > maxBulkDelete {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: between 1 and " + strconv.Itoa(maxBulkDelete) + " albumIDs are required"})
+		return
+	}
+
+	found, err := albumRepo.Delete(c.Request.Context(), albumIDs)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to delete albums"})
+		return
+	}
+
+	results := make([]deleteResult, len(albumIDs))
+	deleted := 0
+	for i, albumID := range albumIDs {
+		results[i].AlbumID = albumID
+		if !found[i] {
+			results[i].Msg = "album not found"
+			continue
+		}
+		results[i].Deleted = true
+		deleted++
+	}
+
+	auditDetail(c, "albumIDs", albumIDs)
+	c.JSON(http.StatusOK, gin.H{
+		"deleted":  deleted,
+		"notFound": len(albumIDs) - deleted,
+		"results":  results,
+	})
+}
+
+// restoreAlbum handles POST /albums/:albumID/restore and undoes the deletion
+// of an album that was not purged yet.
+func restoreAlbum(c *gin.Context) {
+	albumID := c.Param("albumID")
+	restored, err := albumRepo.Restore(c.Request.Context(), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to restore album"})
+		return
+	}
+	if !restored {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "deleted album not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "restored": true})
+}
+
+// adminPurge handles POST /admin/purge and permanently removes the albums
+// deleted at least the optional olderThan duration ago (e.g. "720h"), in
+// batches of retentionBatchSize.
+func adminPurge(c *gin.Context) {
+	var olderThan time.Duration
+	if v := c.Query("olderThan"); v != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(v); err != nil || olderThan < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: olderThan must be a non-negative duration"})
+			return
+		}
+	}
+
+	purged := 0
+	for {
+		albumIDs, err := albumRepo.Deleted(c.Request.Context(), olderThan, retentionBatchSize)
+		if err == nil {
+			var n int
+			n, err = deleteAlbums(c.Request.Context(), albumIDs)
+			purged += n
+		}
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to purge albums", "purged": purged})
+			return
+		}
+		if len(albumIDs) < retentionBatchSize {
+			break
+		}
+	}
+	auditDetail(c, "purged", purged)
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
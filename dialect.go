@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"math/bits"
+	"modernc.org/sqlite"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDialect is the SQL flavor of the database selected by DB_DRIVER. Queries
+// are written for MySQL with ? placeholders; the few constructs that differ
+// are produced by the methods below.
+type sqlDialect string
+
+const (
+	mysqlDialect    sqlDialect = "mysql"
+	postgresDialect sqlDialect = "postgres"
+	sqliteDialect   sqlDialect = "sqlite"
+)
+
+// dialect is the dialect of the open database.
+var dialect = mysqlDialect
+
+// openDB opens the database selected by DB_DRIVER ("mysql", "postgres" or
+// "sqlite") with the DB_DSN connection string, and with the user and password
+// of dbSecrets when they come from a secrets manager.
+func openDB(driverName, dsn string) (*sql.DB, error) {
+	switch driverName {
+	case "", "mysql":
+		// Always parse DATETIME/TIMESTAMP columns into time.Time
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ParseTime = true
+		dialect = mysqlDialect
+		if dbSecrets != nil {
+			return sqlOpenDB(credentialsConnector{mysql.MySQLDriver{}, func(ctx context.Context, creds dbCredentials) (driver.Conn, error) {
+				cfg := cfg.Clone()
+				cfg.User, cfg.Passwd = creds.Username, creds.Password
+				connector, err := mysql.NewConnector(cfg)
+				if err != nil {
+					return nil, err
+				}
+				return connector.Connect(ctx)
+			}}), nil
+		}
+		return sqlOpen("mysql", cfg.FormatDSN())
+	case "postgres", "postgresql", "pgx":
+		cfg, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+		dialect = postgresDialect
+		if dbSecrets != nil {
+			return sqlOpenDB(credentialsConnector{stdlib.GetDefaultDriver(), func(ctx context.Context, creds dbCredentials) (driver.Conn, error) {
+				cfg := cfg.Copy()
+				cfg.User, cfg.Password = creds.Username, creds.Password
+				return rebindConnector{stdlib.GetConnector(*cfg)}.Connect(ctx)
+			}}), nil
+		}
+		return sqlOpenDB(rebindConnector{stdlib.GetConnector(*cfg)}), nil
+	case "sqlite", "sqlite3":
+		dialect = sqliteDialect
+		if dbSecrets != nil {
+			return nil, errors.New("SQLite databases have no credentials to fetch from a secrets manager")
+		}
+		return sqlOpen("sqlite", sqliteDSN(dsn))
+	default:
+		return nil, errors.New("unknown DB_DRIVER " + driverName)
+	}
+}
+
+// configurePool sizes the connection pool of a database opened by openDB,
+// tunable with DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and
+// DB_CONN_MAX_IDLE_TIME. An in-memory SQLite database is discarded with its
+// last connection, so its connections are kept forever unless configured
+// otherwise. The pool reconnects when the credentials of dbSecrets rotate.
+func configurePool(pool *sql.DB) {
+	connMaxLifetime := 30 * time.Minute
+	if dialect == sqliteDialect {
+		connMaxLifetime = 0
+	}
+	maxIdleConns := getEnvInt("DB_MAX_IDLE_CONNS", 100)
+	if dbSecrets != nil {
+		dbSecrets.track(pool, maxIdleConns)
+	}
+	pool.SetMaxOpenConns(getEnvInt("DB_MAX_OPEN_CONNS", 300))
+	pool.SetMaxIdleConns(maxIdleConns)
+	pool.SetConnMaxLifetime(getEnvDuration("DB_CONN_MAX_LIFETIME", connMaxLifetime))
+	pool.SetConnMaxIdleTime(getEnvDuration("DB_CONN_MAX_IDLE_TIME", 0))
+}
+
+// SQLite has no bit counting function, so hamming_distance is registered for
+// sqlDialect.hammingDistance with every SQLite connection.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("hamming_distance", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		a, _ := args[0].(int64)
+		b, _ := args[1].(int64)
+		return int64(bits.OnesCount64(uint64(a ^ b))), nil
+	})
+}
+
+// sqliteDSN turns DB_DSN, a file path or ":memory:", into a modernc.org/sqlite
+// URI. An in-memory database is shared by all connections of the pool instead
+// of each connection getting its own. Transactions take the write lock when
+// they begin, since SQLite has no SELECT ... FOR UPDATE and cannot wait for a
+// lock upgrade, and writers wait for each other instead of failing with
+// SQLITE_BUSY.
+func sqliteDSN(dsn string) string {
+	params := "_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_txlock=immediate&_time_format=sqlite"
+	if dsn == ":memory:" {
+		return "file:/albumserver?vfs=memdb&" + params
+	}
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+		params += "&_pragma=journal_mode(WAL)"
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params
+	}
+	return dsn + "?" + params
+}
+
+// insertIgnore turns "INSERT INTO ..." into an insert that skips rows
+// conflicting with a unique key.
+func (d sqlDialect) insertIgnore(query string) string {
+	switch d {
+	case postgresDialect:
+		return query + " ON CONFLICT DO NOTHING"
+	case sqliteDialect:
+		return strings.Replace(query, "INSERT INTO", "INSERT OR IGNORE INTO", 1)
+	}
+	return strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+// onConflictUpdate starts the assignments applied when an insert conflicts
+// with the unique key on the given columns. Columns of the existing row must
+// be qualified with the table name in the assignments.
+func (d sqlDialect) onConflictUpdate(columns string) string {
+	if d != mysqlDialect {
+		return "ON CONFLICT (" + columns + ") DO UPDATE SET"
+	}
+	return "ON DUPLICATE KEY UPDATE"
+}
+
+// inserted refers to a column of the row an onConflictUpdate clause failed to
+// insert.
+func (d sqlDialect) inserted(column string) string {
+	if d != mysqlDialect {
+		return "excluded." + column
+	}
+	return "VALUES(" + column + ")"
+}
+
+// now is the current time.
+func (d sqlDialect) now() string {
+	if d == sqliteDialect {
+		return "datetime('now')"
+	}
+	return "NOW()"
+}
+
+// secondsFromNow is the time ? seconds from now; a negative argument is in
+// the past.
+func (d sqlDialect) secondsFromNow() string {
+	switch d {
+	case postgresDialect:
+		return "NOW() + (? * INTERVAL '1 second')"
+	case sqliteDialect:
+		return "datetime('now', ? || ' seconds')"
+	}
+	return "NOW() + INTERVAL ? SECOND"
+}
+
+// forUpdate locks the rows read by a SELECT until the transaction ends.
+// SQLite transactions already hold the database write lock.
+func (d sqlDialect) forUpdate() string {
+	if d == sqliteDialect {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// like is a case-insensitive LIKE comparison with the ? pattern, in which a
+// backslash escapes the wildcards. MySQL's default collation already ignores
+// case, as does SQLite's LIKE for ASCII letters.
+func (d sqlDialect) like() string {
+	switch d {
+	case postgresDialect:
+		return "ILIKE ?"
+	case sqliteDialect:
+		return `LIKE ? ESCAPE '\'`
+	}
+	return "LIKE ?"
+}
+
+// hammingDistance counts the bits that differ between a BIGINT column and
+// the ? argument.
+func (d sqlDialect) hammingDistance(column string) string {
+	switch d {
+	case postgresDialect:
+		return "length(replace(((" + column + " # ?)::bit(64))::text, '0', ''))"
+	case sqliteDialect:
+		return "hamming_distance(" + column + ", ?)"
+	}
+	return "BIT_COUNT(" + column + " ^ ?)"
+}
+
+// currentSchema is the schema whose tables are checked in information_schema.
+func (d sqlDialect) currentSchema() string {
+	if d == postgresDialect {
+		return "current_schema()"
+	}
+	return "DATABASE()"
+}
+
+// insertID runs an INSERT into a table with an auto-increment key and
+// returns the generated key.
+func (d sqlDialect) insertID(ctx context.Context, query, column string, args ...any) (int64, error) {
+	var id int64
+	if d == postgresDialect {
+		err := db.QueryRowContext(ctx, query+" RETURNING "+column, args...).Scan(&id)
+		return id, err
+	}
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+	inlineIndexPattern = regexp.MustCompile(`,\s*INDEX (\w+) \(([^)]*)\)`)
+	charPattern        = regexp.MustCompile(`\bCHAR\(`)
+)
+
+// columnTypes translates the MySQL column types in a table or column
+// definition. Postgres gets serial keys, BYTEA, and VARCHAR instead of the
+// space-padded CHAR; SQLite gets rowid keys and accepts the other types.
+func (d sqlDialect) columnTypes(definition string) string {
+	switch d {
+	case mysqlDialect:
+		return definition
+	case sqliteDialect:
+		return strings.NewReplacer(
+			"BIGINT AUTO_INCREMENT", "INTEGER",
+			"INT AUTO_INCREMENT", "INTEGER",
+		).Replace(definition)
+	}
+	definition = strings.NewReplacer(
+		"BIGINT AUTO_INCREMENT", "BIGSERIAL",
+		"INT AUTO_INCREMENT", "SERIAL",
+		"LONGBLOB", "BYTEA",
+	).Replace(definition)
+	return charPattern.ReplaceAllString(definition, "VARCHAR(")
+}
+
+// schema translates a MySQL CREATE TABLE statement. Postgres and SQLite get
+// their column types and separate CREATE INDEX statements for inline indexes.
+func (d sqlDialect) schema(query string) []string {
+	if d == mysqlDialect {
+		return []string{query}
+	}
+	query = d.columnTypes(query)
+	var indexes []string
+	if match := createTablePattern.FindStringSubmatch(query); match != nil {
+		for _, index := range inlineIndexPattern.FindAllStringSubmatch(query, -1) {
+			indexes = append(indexes, "CREATE INDEX IF NOT EXISTS "+index[1]+" ON "+match[1]+" ("+index[2]+")")
+		}
+	}
+	query = inlineIndexPattern.ReplaceAllString(query, "")
+	return append([]string{query}, indexes...)
+}
+
+// rebindConnector opens Postgres connections that accept ? placeholders.
+type rebindConnector struct {
+	driver.Connector
+}
+
+func (c rebindConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rebindConn{conn.(*stdlib.Conn)}, nil
+}
+
+// rebindConn rewrites the ? placeholders of every query to $1, $2, ...
+type rebindConn struct {
+	*stdlib.Conn
+}
+
+func (c rebindConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c rebindConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.PrepareContext(ctx, rebind(query))
+}
+
+func (c rebindConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.ExecContext(ctx, rebind(query), args)
+}
+
+func (c rebindConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.QueryContext(ctx, rebind(query), args)
+}
+
+// rebind replaces the ? placeholders outside of string literals with $n.
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
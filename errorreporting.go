@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// errorReportingEnabled is set when panics and server errors are sent to
+// Sentry.
+var errorReportingEnabled bool
+
+// setupErrorReporting sends the panics and server errors of requests to
+// Sentry, or to a compatible service, when SENTRY_DSN is set. The events are
+// tagged with SENTRY_ENVIRONMENT and SENTRY_RELEASE, and SENTRY_SAMPLE_RATE is
+// the fraction of them that is sent. It returns a function waiting for the
+// events that are not sent yet.
+func setupErrorReporting() (func(), error) {
+	if os.Getenv("SENTRY_DSN") == "" {
+		return func() {}, nil
+	}
+	sampleRate := getEnvFloat("SENTRY_SAMPLE_RATE", 1)
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("SENTRY_SAMPLE_RATE must be above 0 and at most 1, got %v", sampleRate)
+	}
+	if err := sentry.Init(sentry.ClientOptions{SampleRate: sampleRate, AttachStacktrace: true}); err != nil {
+		return nil, err
+	}
+	errorReportingEnabled = true
+	return func() { sentry.Flush(2 * time.Second) }, nil
+}
+
+// reportErrors is a middleware that sends the panics of handlers to Sentry
+// before panicking again for the recovery middleware, and the responses with
+// a 5xx status along with the last error the handler recorded with c.Error.
+// The events hold the request, without its credentials, its route and its
+// request ID.
+func reportErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !errorReportingEnabled {
+			c.Next()
+			return
+		}
+		hub := sentry.CurrentHub().Clone()
+		hub.Scope().SetRequest(c.Request)
+		defer func() {
+			if err := recover(); err != nil {
+				if err != http.ErrAbortHandler {
+					tagEvent(hub.Scope(), c)
+					hub.RecoverWithContext(c.Request.Context(), err)
+				}
+				panic(err)
+			}
+		}()
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < 500 {
+			return
+		}
+		scope := hub.Scope()
+		tagEvent(scope, c)
+		scope.SetTag("status", strconv.Itoa(status))
+		// Errors recorded by handlers have no stack trace of their own, so
+		// they are grouped by route rather than by the stack of this middleware.
+		scope.SetFingerprint([]string{"{{ default }}", c.Request.Method, c.FullPath()})
+		if len(c.Errors) > 1 {
+			scope.SetContext("errors", sentry.Context{"messages": c.Errors.Errors()})
+		}
+		if last := c.Errors.Last(); last != nil {
+			hub.CaptureException(last.Err)
+		} else {
+			hub.CaptureException(errors.New(http.StatusText(status) + " from " + c.Request.Method + " " + c.FullPath()))
+		}
+	}
+}
+
+// tagEvent adds the route and request ID of a request to the scope of its
+// events.
+func tagEvent(scope *sentry.Scope, c *gin.Context) {
+	scope.SetTag("method", c.Request.Method)
+	scope.SetTag("route", c.FullPath())
+	if requestID := c.GetString(requestIDKey); requestID != "" {
+		scope.SetTag("request_id", requestID)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportRecord is a single album in the export and import formats.
+type exportRecord struct {
+	AlbumID   string    `json:"albumID"`
+	Artist    string    `json:"artist"`
+	Title     string    `json:"title"`
+	Year      albumYear `json:"year"`
+	Genre     string    `json:"genre"`
+	ImageSize int64     `json:"imageSize"`
+	ImageHash string    `json:"imageHash"`
+	CreatedAt time.Time `json:"createdAt"`
+	Image     string    `json:"image,omitempty"`    // Base64-encoded image bytes
+	ImageURL  string    `json:"imageUrl,omitempty"` // Path of the image download endpoint
+}
+
+// exportCSVHeader is the header row of the CSV export format.
+var exportCSVHeader = []string{"albumID", "artist", "title", "year", "genre", "imageSize", "imageHash", "createdAt", "image", "imageUrl"}
+
+// csvRow returns the record as a row matching exportCSVHeader.
+func (r exportRecord) csvRow() []string {
+	return []string{
+		r.AlbumID, r.Artist, r.Title, r.Year.String(), r.Genre,
+		strconv.FormatInt(r.ImageSize, 10), r.ImageHash,
+		r.CreatedAt.UTC().Format(time.RFC3339), r.Image, r.ImageURL,
+	}
+}
+
+// exportFlushEvery is the number of records written between flushes.
+const exportFlushEvery = 100
+
+// adminExport handles GET /admin/export and streams every album as NDJSON
+// (default) or CSV. The images parameter adds each image as base64 ("base64")
+// or as a download path ("url"). Rows are written as they are read from the
+// database so the dataset is never held in memory.
+func adminExport(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: format must be 'ndjson' or 'csv'"})
+		return
+	}
+	images := c.Query("images")
+	if images != "" && images != "base64" && images != "url" {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: images must be 'base64' or 'url'"})
+		return
+	}
+
+	// Only look up the primary image key when the image is exported.
+	keyColumn, imageJoin := "NULL", ""
+	if images == "base64" || images == "url" {
+		keyColumn = "i.storage_key"
+		imageJoin = ` LEFT JOIN album_images i ON i.album_id = a.album_id AND i.is_primary`
+	}
+	query := `SELECT a.album_id, a.artist, a.title, a.year, a.genre, a.image_size, a.image_hash, a.created_at, ` +
+		keyColumn + ` FROM albums a` + imageJoin + ` WHERE a.deleted_at IS NULL ORDER BY a.created_at, a.album_id`
+	// The export streams for as long as the client reads, so it is bound by the
+	// request context only rather than DB_QUERY_TIMEOUT.
+	rows, err := readDB(c.Request.Context()).QueryContext(c.Request.Context(), query)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to export albums"})
+		return
+	}
+	defer rows.Close()
+
+	// Headers are sent with the first write; errors after that can only abort the stream.
+	var encodeRecord func(exportRecord) error
+	csvWriter := csv.NewWriter(c.Writer)
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="albums.csv"`)
+		encodeRecord = func(r exportRecord) error { return csvWriter.Write(r.csvRow()) }
+		if err := csvWriter.Write(exportCSVHeader); err != nil {
+			return
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="albums.ndjson"`)
+		encoder := json.NewEncoder(c.Writer)
+		encodeRecord = func(r exportRecord) error { return encoder.Encode(r) }
+	}
+	c.Status(http.StatusOK)
+
+	count := 0
+	for rows.Next() {
+		var record exportRecord
+		var key sql.NullString
+		if err := rows.Scan(&record.AlbumID, &record.Artist, &record.Title, &record.Year, &record.Genre,
+			&record.ImageSize, &record.ImageHash, &record.CreatedAt, &key); err != nil {
+			c.Error(err)
+			return
+		}
+		switch images {
+		case "base64":
+			if key.Valid {
+				imageData, err := imageStore.Get(c.Request.Context(), key.String)
+				if err != nil && !errors.Is(err, errImageNotFound) {
+					c.Error(err)
+					return
+				}
+				record.Image = base64.StdEncoding.EncodeToString(imageData)
+			}
+		case "url":
+			if key.Valid {
+				record.ImageURL = imageURL(key.String, "/albums/"+record.AlbumID+"/image")
+			}
+		}
+		if err := encodeRecord(record); err != nil {
+			c.Error(err)
+			return
+		}
+		if count++; count%exportFlushEvery == 0 {
+			csvWriter.Flush()
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+	}
+	csvWriter.Flush()
+	c.Writer.Flush()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// addFavorite handles POST /albums/:albumID/favorite for the authenticated user.
+func addFavorite(c *gin.Context) {
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := dialect.insertIgnore(`INSERT INTO favorites (user_id, album_id) VALUES (?, ?)`)
+	if _, err := db.ExecContext(ctx, query, c.GetString(userIDKey), albumID); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist favorite"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "favorite": true})
+}
+
+// removeFavorite handles DELETE /albums/:albumID/favorite for the authenticated user.
+func removeFavorite(c *gin.Context) {
+	albumID := c.Param("albumID")
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `DELETE FROM favorites WHERE user_id = ? AND album_id = ?`
+	result, err := db.ExecContext(ctx, query, c.GetString(userIDKey), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to remove favorite"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "album is not a favorite"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "favorite": false})
+}
+
+// listFavorites handles GET /me/favorites and lists the authenticated user's
+// favorite albums, most recently added first.
+func listFavorites(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	albums, err := albumRepo.Favorites(c.Request.Context(), c.GetString(userIDKey), limit, offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve favorites"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albums": albums, "limit": limit, "offset": offset})
+}
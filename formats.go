@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gen2brain/avif"
+	"github.com/gen2brain/webp"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"slices"
+	"strings"
+)
+
+// Encoding quality of the output formats.
+const (
+	jpegQuality = 85
+	webpQuality = 80
+	avifQuality = 60
+)
+
+// outputFormats are the formats images can be converted to with ?format=.
+var outputFormats = []string{"jpeg", "webp", "avif"}
+
+// acceptFormats are the formats chosen from the Accept header, in order of
+// preference, set by ACCEPT_IMAGE_FORMATS. AVIF is not negotiated by default
+// because encoding it is much slower than WebP.
+var acceptFormats = []string{"webp"}
+
+// negotiateFormat returns the output format requested with ?format= or, when
+// the parameter is absent, the first of acceptFormats the client accepts. It
+// returns "" when the image should be served in its stored format.
+func negotiateFormat(c *gin.Context) (string, error) {
+	if format := c.Query("format"); format != "" {
+		if format == "original" {
+			return "", nil
+		}
+		if !slices.Contains(outputFormats, format) {
+			return "", errors.New("format must be one of " + strings.Join(outputFormats, ", "))
+		}
+		return format, nil
+	}
+	if len(acceptFormats) == 0 {
+		return "", nil
+	}
+	c.Header("Vary", "Accept")
+	accept := c.GetHeader("Accept")
+	for _, format := range acceptFormats {
+		if strings.Contains(accept, "image/"+format) {
+			return format, nil
+		}
+	}
+	return "", nil
+}
+
+// formatOrDefault returns format, or "jpeg" for rendered variants when no
+// output format was requested.
+func formatOrDefault(format string) string {
+	if format == "" {
+		return "jpeg"
+	}
+	return format
+}
+
+// variantName returns the image_variants name of a variant in format. JPEG
+// variants keep the plain name.
+func variantName(name, format string) string {
+	if format == "jpeg" {
+		return name
+	}
+	return name + "." + format
+}
+
+// ensureConverted returns the storage key of the image stored under sourceKey
+// converted to format, converting it on first use.
+func ensureConverted(ctx context.Context, sourceKey, format string) (string, error) {
+	return ensureVariant(ctx, sourceKey, "original."+format, func(imageData []byte) ([]byte, error) {
+		src, err := decodeImage(imageData)
+		if err != nil {
+			return nil, err
+		}
+		return encodeImage(src, format)
+	})
+}
+
+// encodeImage encodes img in an output format. JPEG has no alpha channel, so
+// images with transparency are rendered on white first.
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "webp":
+		err = webp.Encode(&buf, img, webp.Options{Quality: webpQuality, Method: webp.DefaultMethod})
+	case "avif":
+		err = avif.Encode(&buf, img, avif.Options{Quality: avifQuality, QualityAlpha: avifQuality, Speed: avif.DefaultSpeed})
+	default:
+		if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+			bounds := img.Bounds()
+			dst := newCanvas(bounds.Dx(), bounds.Dy())
+			draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+			img = dst
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "strings"
+
+// defaultGenres is used when the ALLOWED_GENRES environment variable is not set.
+var defaultGenres = []string{
+	"blues", "classical", "country", "electronic", "folk", "hip-hop",
+	"jazz", "metal", "pop", "r&b", "reggae", "rock", "soundtrack", "world",
+}
+
+// allowedGenres is the set of genres accepted in album profiles.
+var allowedGenres map[string]bool
+
+// loadAllowedGenres reads the allowed genres from ALLOWED_GENRES.
+func loadAllowedGenres() {
+	allowedGenres = make(map[string]bool)
+	for _, genre := range getEnvList("ALLOWED_GENRES", defaultGenres) {
+		allowedGenres[normalizeGenre(genre)] = true
+	}
+}
+
+// normalizeGenre trims and lowercases a genre so genres match case-insensitively.
+func normalizeGenre(genre string) string {
+	return strings.ToLower(strings.TrimSpace(genre))
+}
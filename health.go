@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// schemaReady is set once the database schema has been created or upgraded.
+var schemaReady atomic.Bool
+
+// readyTimeout bounds the database ping performed by the readiness probe.
+var readyTimeout = 2 * time.Second
+
+// livenessProbe handles GET /healthz. It only reports that the process is
+// able to serve requests and never touches the database.
+func livenessProbe(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readinessProbe handles GET /readyz. It reports 503 until the schema is in
+// place and while the database does not answer a ping within readyTimeout.
+func readinessProbe(c *gin.Context) {
+	if !schemaReady.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "msg": "schema not applied"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "msg": "database unreachable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
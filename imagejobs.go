@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"time"
+)
+
+// The perceptual hash, colors, thumbnails and converted copies of the images
+// of an album are made by imageWorkers goroutines (IMAGE_WORKERS) after the
+// request that stored the images, taking the albums from a queue of up to
+// imageQueueSize albums (IMAGE_QUEUE_SIZE). The image_status of the album
+// tells how far they are: pending, processing, ready or failed. Albums that do
+// not fit in the queue, or were still queued at a restart, stay pending and
+// are queued again by the sweep of the workers, every imageSweepInterval. A
+// worker only takes an album it moves from pending to processing, so an album
+// is not processed by two servers at once; an album whose worker stopped stays
+// processing, its missing thumbnails made by their first download. Servers
+// without workers leave their albums to the workers of other servers.
+var (
+	imageWorkers   = 4
+	imageQueueSize = 1000
+	imageJobs      chan string
+)
+
+// preconvertFormats (IMAGE_PRECONVERT_FORMATS) are the output formats the
+// images and thumbnails of new albums are converted to by the workers, so
+// their first download in these formats is not converted on the fly.
+var preconvertFormats []string
+
+// imageSweepInterval is the period of the sweep queueing pending albums.
+const imageSweepInterval = time.Minute
+
+// queuedImageJobs holds the albums waiting in imageJobs, which are not queued
+// twice.
+var queuedImageJobs = struct {
+	sync.Mutex
+	albums map[string]bool
+}{albums: map[string]bool{}}
+
+// queueImageJob marks the images of an album pending and queues the album for
+// the workers. It does not wait for room in the queue: the sweep queues the
+// album later instead.
+func queueImageJob(ctx context.Context, albumID string) {
+	if _, err := setImageStatus(ctx, albumID, "pending", ""); err != nil {
+		logFor(ctx).Error().Err(err).Str("albumID", albumID).Msg("Error queueing album images")
+		return
+	}
+	enqueueImageJob(albumID)
+}
+
+// enqueueImageJob puts an album in imageJobs unless it is full or already
+// holds the album. It reports whether the album is queued.
+func enqueueImageJob(albumID string) bool {
+	if imageJobs == nil {
+		return false
+	}
+	queuedImageJobs.Lock()
+	defer queuedImageJobs.Unlock()
+	if queuedImageJobs.albums[albumID] {
+		return true
+	}
+	select {
+	case imageJobs <- albumID:
+		queuedImageJobs.albums[albumID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// setImageStatus sets the image_status of an album. When from is not empty,
+// the status is only changed from that status. It reports whether the album
+// was changed.
+func setImageStatus(ctx context.Context, albumID, status, from string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query, args := `UPDATE albums SET image_status = ? WHERE album_id = ?`, []any{status, albumID}
+	if from != "" {
+		query, args = query+` AND image_status = ?`, append(args, from)
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	invalidateAlbum(ctx, albumID)
+	return changed > 0, nil
+}
+
+// startImageWorkers starts the imageWorkers goroutines processing the queued
+// albums, and the sweep.
+func startImageWorkers() {
+	imageJobs = make(chan string, imageQueueSize)
+	for range imageWorkers {
+		go func() {
+			for albumID := range imageJobs {
+				queuedImageJobs.Lock()
+				delete(queuedImageJobs.albums, albumID)
+				queuedImageJobs.Unlock()
+				processImageJob(albumID)
+			}
+		}()
+	}
+	go func() {
+		sweepImageJobs()
+		for range time.Tick(imageSweepInterval) {
+			sweepImageJobs()
+		}
+	}()
+}
+
+// sweepImageJobs queues the oldest albums whose images are pending, as many as
+// there is room for in the queue.
+func sweepImageJobs() {
+	room := cap(imageJobs) - len(imageJobs)
+	if room <= 0 {
+		return
+	}
+	ctx, cancel := withQueryTimeout(context.Background())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, `SELECT album_id FROM albums WHERE image_status = 'pending'
+		AND deleted_at IS NULL ORDER BY created_at LIMIT ?`, room)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error sweeping pending album images")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var albumID string
+		if err := rows.Scan(&albumID); err != nil {
+			logger.Error().Err(err).Msg("Error sweeping pending album images")
+			return
+		}
+		if !enqueueImageJob(albumID) {
+			return
+		}
+	}
+}
+
+// processImageJob processes the images of an album and records the outcome.
+// The album is skipped unless it is still pending, as it is otherwise being
+// or was already processed by another worker. The outcome is dropped when the
+// album was marked pending again meanwhile, as it is then queued to process
+// its new images.
+func processImageJob(albumID string) {
+	l := logger.With().Str("albumID", albumID).Logger()
+	ctx := l.WithContext(context.Background())
+	if taken, err := setImageStatus(ctx, albumID, "processing", "pending"); err != nil {
+		l.Error().Err(err).Msg("Error processing album images")
+		return
+	} else if !taken {
+		return
+	}
+	status := "ready"
+	if err := processAlbumImages(ctx, albumID); err != nil {
+		l.Error().Err(err).Msg("Error processing album images")
+		status = "failed"
+	}
+	if _, err := setImageStatus(ctx, albumID, status, "processing"); err != nil {
+		l.Error().Err(err).Msg("Error recording album image status")
+	}
+	imageJobsProcessed.WithLabelValues(status).Inc()
+}
+
+// processAlbumImages computes the perceptual hash and colors of an album's
+// cover and generates the missing thumbnails of its images, and their copies
+// in preconvertFormats. Images that cannot be decoded are skipped; the other
+// failures are returned together.
+func processAlbumImages(ctx context.Context, albumID string) error {
+	var errs []error
+	check := func(err error) {
+		if err != nil && !errors.Is(err, image.ErrFormat) {
+			errs = append(errs, err)
+		}
+	}
+	_, _, err := ensurePerceptualHash(ctx, albumID)
+	check(err)
+
+	rows, err := db.QueryContext(ctx, `SELECT DISTINCT storage_key FROM album_images WHERE album_id = ?`, albumID)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return errors.Join(append(errs, err)...)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, key := range keys {
+		for _, size := range thumbnailSizes {
+			_, err := ensureThumbnail(ctx, key, size, "jpeg")
+			check(err)
+		}
+		for _, format := range preconvertFormats {
+			_, err := ensureConverted(ctx, key, format)
+			check(err)
+			if format == "jpeg" {
+				continue
+			}
+			for _, size := range thumbnailSizes {
+				_, err := ensureThumbnail(ctx, key, size, format)
+				check(err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
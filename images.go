@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"hash"
+	"image"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// primaryImageLabel is the label of the image uploaded with an album.
+const primaryImageLabel = "cover"
+
+// AlbumImage describes one image of an album's gallery.
+type AlbumImage struct {
+	ImageID   string    `json:"imageID"`
+	Label     string    `json:"label"`
+	ImageSize int64     `json:"imageSize"`
+	Primary   bool      `json:"primary"`
+	CreatedAt time.Time `json:"createdAt"`
+	URL       string    `json:"url"`
+
+	// ContentType, Width and Height are detected at upload. They are empty
+	// for images stored before they were recorded.
+	ContentType string `json:"contentType,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// imageBaseURL is the public base URL, such as a CloudFront distribution in
+// front of the image bucket, under which images are available by storage key.
+// It is set by IMAGE_BASE_URL and must include any store prefix, for example
+// "https://d111111abcdef8.cloudfront.net/albums/".
+var imageBaseURL string
+
+// imageURL returns the URL clients use to download the image stored under key:
+// the CDN URL when imageBaseURL is set, otherwise apiPath on this server.
+func imageURL(key, apiPath string) string {
+	if imageBaseURL == "" {
+		return apiPath
+	}
+	return strings.TrimSuffix(imageBaseURL, "/") + "/" + key
+}
+
+// detectImageType returns the Content-Type of image bytes. It extends
+// http.DetectContentType, which does not know AVIF.
+func detectImageType(imageData []byte) string {
+	if len(imageData) >= 12 && string(imageData[4:8]) == "ftyp" &&
+		(string(imageData[8:12]) == "avif" || string(imageData[8:12]) == "avis") {
+		return "image/avif"
+	}
+	return http.DetectContentType(imageData)
+}
+
+// imageDimensions returns the width and height of an image, decoded from its
+// header, or zeros when the image cannot be decoded.
+func imageDimensions(imageData []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// allowedImageTypes are the image types accepted for upload, detected from
+// the first bytes of the image. They are set by ALLOWED_IMAGE_TYPES.
+var allowedImageTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+
+// parseImageTypes parses a list of image MIME types such as "image/png".
+func parseImageTypes(values []string) (map[string]bool, error) {
+	types := map[string]bool{}
+	for _, value := range values {
+		contentType := strings.ToLower(value)
+		if !strings.HasPrefix(contentType, "image/") {
+			return nil, errors.New("invalid image type " + value)
+		}
+		types[contentType] = true
+	}
+	return types, nil
+}
+
+// unsupportedImageError is returned for an upload whose content is not one
+// of the allowed image types.
+type unsupportedImageError struct {
+	ContentType string
+}
+
+func (e *unsupportedImageError) Error() string {
+	allowed := make([]string, 0, len(allowedImageTypes))
+	for contentType := range allowedImageTypes {
+		allowed = append(allowed, contentType)
+	}
+	sort.Strings(allowed)
+	return "unsupported image type " + e.ContentType + ", allowed types are " + strings.Join(allowed, ", ")
+}
+
+// checkImageType returns the type of image bytes, or an unsupportedImageError
+// when it is not allowed. Only the first 512 bytes are inspected.
+func checkImageType(head []byte) (string, error) {
+	contentType := detectImageType(head)
+	if !allowedImageTypes[contentType] {
+		return "", &unsupportedImageError{ContentType: contentType}
+	}
+	return contentType, nil
+}
+
+// respondUnsupportedImage writes a 415 response and returns true when err is
+// an unsupportedImageError.
+func respondUnsupportedImage(c *gin.Context, err error) bool {
+	var typeErr *unsupportedImageError
+	if !errors.As(err, &typeErr) {
+		return false
+	}
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{"msg": "invalid request: " + typeErr.Error()})
+	return true
+}
+
+// serveImage writes image bytes with a Content-Type detected from the content.
+// Range and If-Range requests are answered with 206 partial content. Stored
+// images never change under their key, so the key serves as a strong ETag.
+func serveImage(c *gin.Context, key string, imageData []byte) {
+	c.Header("Content-Type", detectImageType(imageData))
+	c.Header("ETag", `"`+key+`"`)
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(imageData))
+}
+
+// serveStoredImage loads the image with the given storage key and writes it,
+// or answers 304 without loading it when the client already has it.
+func serveStoredImage(c *gin.Context, key string) {
+	if notModified(c, `"`+key+`"`) {
+		return
+	}
+	imageData, err := imageStore.Get(c.Request.Context(), key)
+	if errors.Is(err, errImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "image not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album image"})
+		return
+	}
+	serveImage(c, key, imageData)
+}
+
+// storeImage writes image bytes to the image store under a new key.
+func storeImage(ctx context.Context, imageData []byte) (storedImage, error) {
+	image := storedImage{
+		Key:         uuid.New().String(),
+		Size:        int64(len(imageData)),
+		Hash:        hashImage(imageData),
+		ContentType: detectImageType(imageData),
+	}
+	image.Width, image.Height = imageDimensions(imageData)
+	if err := imageStore.Put(ctx, image.Key, bytes.NewReader(imageData), image.ContentType); err != nil {
+		return image, err
+	}
+	uploadedImages.Inc()
+	uploadedImageBytes.Add(float64(image.Size))
+	addUploadSize(ctx, image.Size)
+	return image, nil
+}
+
+// maxImageSize is the largest image in bytes accepted for upload, set by
+// MAX_IMAGE_BYTES (or the older MAX_IMAGE_SIZE).
+var maxImageSize = 32 << 20
+
+// imageHeadSize is the number of leading bytes of a streamed image inspected
+// for its type and dimensions. Metadata is stripped before, so the dimensions
+// of JPEG images are usually found within it.
+const imageHeadSize = 64 << 10
+
+// errImageTooLarge is returned for images over maxImageSize.
+var errImageTooLarge = errors.New("image is too large")
+
+// multipartOverhead is the room left in an upload body for the form fields
+// and part headers next to the images.
+const multipartOverhead = 1 << 20
+
+// limitUploadSize is a middleware that caps the request body at the size of
+// the given number of images plus multipartOverhead, so oversized uploads fail
+// while they are read instead of being buffered or spooled to disk. It
+// replaces the cap set by limitBodies.
+func limitUploadSize(images int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := int64(images)*int64(maxImageSize) + multipartOverhead
+		if c.Request.ContentLength > limit {
+			respondTooLarge(c, errImageTooLarge)
+			c.Abort()
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, rawBody(c), limit)
+		c.Next()
+	}
+}
+
+// imageTooLargeMsg is the message of responses to images over maxImageSize.
+func imageTooLargeMsg() string {
+	return "image exceeds the maximum size of " + strconv.Itoa(maxImageSize) + " bytes"
+}
+
+// respondTooLarge writes a 413 response and returns true when err reports an
+// image over maxImageSize or a request body over the limitUploadSize cap.
+func respondTooLarge(c *gin.Context, err error) bool {
+	var bodyErr *http.MaxBytesError
+	if !errors.Is(err, errImageTooLarge) && !errors.As(err, &bodyErr) {
+		return false
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"msg": imageTooLargeMsg()})
+	return true
+}
+
+// storedImage describes an image written to the image store.
+type storedImage struct {
+	Key         string
+	Size        int64
+	Hash        string
+	ContentType string
+	Width       int
+	Height      int
+
+	// Placeholder marks a cover generated for an album without an image.
+	Placeholder bool
+}
+
+// label returns the gallery label of the image as an album's primary image.
+func (image storedImage) label() string {
+	if image.Placeholder {
+		return placeholderImageLabel
+	}
+	return primaryImageLabel
+}
+
+// limitedHashReader hashes (when hash is set) and counts the bytes read
+// through it and fails with errImageTooLarge as soon as more than limit bytes
+// were read.
+type limitedHashReader struct {
+	r     io.Reader
+	hash  hash.Hash
+	size  int64
+	limit int64
+}
+
+func (r *limitedHashReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.hash != nil {
+		r.hash.Write(p[:n])
+	}
+	r.size += int64(n)
+	if r.size > r.limit {
+		return n, errImageTooLarge
+	}
+	return n, err
+}
+
+// streamImage copies an image from r to the image store under a new key
+// without holding it in memory, stripping its metadata and hashing it on the
+// way. The Content-Type and dimensions are detected from the first bytes, and
+// images of a type that is not allowed fail with an unsupportedImageError
+// before anything is stored.
+func streamImage(ctx context.Context, r io.Reader) (storedImage, error) {
+	raw := &limitedHashReader{r: r, limit: int64(maxImageSize)}
+	stripped := stripImageMetadataStream(raw)
+	defer stripped.Close()
+	buffered := getBufferedReader(&headReaders, stripped)
+	defer putBufferedReader(&headReaders, buffered)
+	head, err := buffered.Peek(imageHeadSize)
+	if err != nil && err != io.EOF {
+		if raw.size > raw.limit {
+			return storedImage{}, errImageTooLarge
+		}
+		return storedImage{}, err
+	}
+	contentType, err := checkImageType(head)
+	if err != nil {
+		return storedImage{}, err
+	}
+	// The head is overwritten as the rest of the image is read through the
+	// buffer, so it is inspected first.
+	width, height := imageDimensions(head)
+	reader := &limitedHashReader{r: buffered, hash: sha256.New(), limit: int64(maxImageSize)}
+	key := uuid.New().String()
+	if err := imageStore.Put(ctx, key, reader, contentType); err != nil {
+		if raw.size > raw.limit || reader.size > reader.limit {
+			return storedImage{}, errImageTooLarge
+		}
+		return storedImage{}, err
+	}
+	stored := storedImage{Key: key, Size: reader.size, Hash: hex.EncodeToString(reader.hash.Sum(nil)), ContentType: contentType}
+	stored.Width, stored.Height = width, height
+	uploadedImages.Inc()
+	uploadedImageBytes.Add(float64(stored.Size))
+	addUploadSize(ctx, stored.Size)
+	return stored, nil
+}
+
+// insertImageTx records a stored image in an album's gallery and
+// returns its imageID, along with the keys of objects that are unused once the
+// transaction is committed. Callers adding a primary image to an album that
+// may already have one must clear the existing flag first.
+func insertImageTx(ctx context.Context, tx *sql.Tx, albumID string, image storedImage, label string, primary bool) (string, []string, error) {
+	objectKey, err := acquireImageTx(ctx, tx, image.Key, image.Hash)
+	if err != nil {
+		return "", nil, err
+	}
+	var unused []string
+	if objectKey != image.Key {
+		unused = append(unused, image.Key)
+	}
+	imageID := uuid.New().String()
+	query := `INSERT INTO album_images (image_id, album_id, storage_key, image_size, image_hash, content_type, width, height, label, is_primary)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query, imageID, albumID, objectKey, image.Size, image.Hash, image.ContentType, image.Width, image.Height, label, primary)
+	return imageID, unused, err
+}
+
+// putPrimaryImageTx points an album's primary image at a stored image,
+// creating the primary image if the album has none, and updates the image
+// metadata on the album. It returns the keys of objects that are unused once
+// the transaction is committed, such as the replaced image.
+func putPrimaryImageTx(ctx context.Context, tx *sql.Tx, albumID string, image storedImage) ([]string, error) {
+	var imageID, oldKey string
+	query := `SELECT image_id, storage_key FROM album_images WHERE album_id = ? AND is_primary` + dialect.forUpdate()
+	err := tx.QueryRowContext(ctx, query, albumID).Scan(&imageID, &oldKey)
+	var unused []string
+	if err == sql.ErrNoRows {
+		if _, unused, err = insertImageTx(ctx, tx, albumID, image, image.label(), true); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		objectKey, err := acquireImageTx(ctx, tx, image.Key, image.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if objectKey != image.Key {
+			unused = append(unused, image.Key)
+		}
+		query := `UPDATE album_images SET storage_key = ?, image_size = ?, image_hash = ?, content_type = ?, width = ?, height = ?,
+			label = ? WHERE image_id = ?`
+		_, err = tx.ExecContext(ctx, query, objectKey, image.Size, image.Hash, image.ContentType, image.Width, image.Height, image.label(), imageID)
+		if err != nil {
+			return nil, err
+		}
+		released, err := releaseImageTx(ctx, tx, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		if released {
+			unused = append(unused, oldKey)
+		}
+	}
+	query = `UPDATE albums SET image_size = ?, image_hash = ?, image_width = ?, image_height = ?,
+		image_phash = NULL, dominant_color = '', average_color = '', version = version + 1 WHERE album_id = ?`
+	_, err = tx.ExecContext(ctx, query, image.Size, image.Hash, image.Width, image.Height, albumID)
+	return unused, err
+}
+
+// replaceAlbumImage handles PUT /albums/:albumID/image and replaces the stored
+// cover with the multipart 'image' file, keeping the albumID and profile. The
+// If-Match header must hold the album's current ETag, so a cover changed by
+// another request in the meantime is not overwritten.
+func replaceAlbumImage(c *gin.Context) {
+	albumID := c.Param("albumID")
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	fileHeader, err := c.FormFile("image")
+	if respondTooLarge(c, err) {
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: image is required"})
+		return
+	}
+	imageData, err := readImageFile(fileHeader)
+	if respondUnsupportedImage(c, err) || respondTooLarge(c, err) {
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to read image file"})
+		return
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	// Refuse an image that is already stored for another album.
+	if rejectDuplicateImages {
+		existingID, err := albumRepo.FindByImageHash(c.Request.Context(), hashImage(imageData), albumID)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+			return
+		}
+		if existingID != "" {
+			c.JSON(http.StatusConflict, gin.H{"msg": "image already uploaded", "albumID": existingID})
+			return
+		}
+	}
+
+	// Only the growth over the current cover counts against the storage quotas.
+	ctx := c.Request.Context()
+	var currentSize int64
+	var ownerID sql.NullString
+	queryCtx, cancel := withQueryTimeout(ctx)
+	err = db.QueryRowContext(queryCtx, `SELECT image_size, owner_id FROM albums WHERE album_id = ?`, albumID).Scan(&currentSize, &ownerID)
+	cancel()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	if !respondQuota(c, checkQuota(ctx, ownerID.String, 0, int64(len(imageData))-currentSize)) {
+		return
+	}
+
+	// Store the new image, then switch the album over to it.
+	image, err := storeImage(ctx, imageData)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	if err := replacePrimaryImage(ctx, albumID, version, image); err != nil {
+		deleteImages(ctx, []string{image.Key})
+		if errors.Is(err, errVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"msg": "album was changed since the If-Match ETag was retrieved"})
+			return
+		} else if errors.Is(err, errAlbumNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"msg": "album not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	queueImageJob(ctx, albumID)
+	auditDetail(c, "version", version+1)
+	c.Header("ETag", albumETag(version+1))
+	c.JSON(http.StatusOK, gin.H{
+		"albumID":   albumID,
+		"imageSize": strconv.Itoa(len(imageData)),
+	})
+}
+
+// replacePrimaryImage runs putPrimaryImageTx in its own transaction when the
+// album is still at version, and deletes the objects it leaves unused.
+func replacePrimaryImage(ctx context.Context, albumID string, version int64, image storedImage) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(queryCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkVersionTx(queryCtx, tx, albumID, version); err != nil {
+		return err
+	}
+	unused, err := putPrimaryImageTx(queryCtx, tx, albumID, image)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateAlbum(ctx, albumID)
+	deleteImages(ctx, unused)
+	return nil
+}
+
+// addGalleryImage records a stored image in an album's gallery.
+// A primary image replaces the album's current primary flag and metadata,
+// and fails with errVersionConflict unless the album is at version.
+func addGalleryImage(ctx context.Context, albumID string, image storedImage, label string, primary bool, version int64) (string, error) {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(queryCtx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if primary {
+		if err := checkVersionTx(queryCtx, tx, albumID, version); err != nil {
+			return "", err
+		}
+		if _, err := tx.ExecContext(queryCtx, `UPDATE album_images SET is_primary = FALSE WHERE album_id = ?`, albumID); err != nil {
+			return "", err
+		}
+	}
+	imageID, unused, err := insertImageTx(queryCtx, tx, albumID, image, label, primary)
+	if err != nil {
+		return "", err
+	}
+	if primary {
+		query := `UPDATE albums SET image_size = ?, image_hash = ?, image_width = ?, image_height = ?,
+			image_phash = NULL, dominant_color = '', average_color = '', version = version + 1 WHERE album_id = ?`
+		if _, err := tx.ExecContext(queryCtx, query, image.Size, image.Hash, image.Width, image.Height, albumID); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	if primary {
+		invalidateAlbum(ctx, albumID)
+	}
+	deleteImages(ctx, unused)
+	return imageID, nil
+}
+
+// addAlbumImage handles POST /albums/:albumID/images and adds the multipart
+// 'image' file to the album's gallery. The optional 'label' field describes
+// the image (e.g. "back cover") and 'primary=true' makes it the album cover,
+// which like PUT /albums/:albumID/image needs the album's current ETag in
+// If-Match.
+func addAlbumImage(c *gin.Context) {
+	albumID := c.Param("albumID")
+	fileHeader, err := c.FormFile("image")
+	if respondTooLarge(c, err) {
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: image is required"})
+		return
+	}
+	label := strings.TrimSpace(c.PostForm("label"))
+	if len(label) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: label must be at most 64 characters"})
+		return
+	}
+	primary, _ := strconv.ParseBool(c.PostForm("primary"))
+	var version int64
+	if primary {
+		var ok bool
+		if version, ok = requireIfMatch(c); !ok {
+			return
+		}
+	}
+	imageData, err := readImageFile(fileHeader)
+	if respondUnsupportedImage(c, err) || respondTooLarge(c, err) {
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to read image file"})
+		return
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var ownerID sql.NullString
+	queryCtx, cancel := withQueryTimeout(ctx)
+	err = db.QueryRowContext(queryCtx, `SELECT owner_id FROM albums WHERE album_id = ?`, albumID).Scan(&ownerID)
+	cancel()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	if !respondQuota(c, checkQuota(ctx, ownerID.String, 0, int64(len(imageData)))) {
+		return
+	}
+
+	image, err := storeImage(ctx, imageData)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	imageID, err := addGalleryImage(ctx, albumID, image, label, primary, version)
+	if err != nil {
+		deleteImages(ctx, []string{image.Key})
+		if errors.Is(err, errVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"msg": "album was changed since the If-Match ETag was retrieved"})
+			return
+		} else if errors.Is(err, errAlbumNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"msg": "album not found"})
+			return
+		}
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+		return
+	}
+	queueImageJob(ctx, albumID)
+	auditDetail(c, "imageID", imageID)
+	if primary {
+		c.Header("ETag", albumETag(version+1))
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"albumID":   albumID,
+		"imageID":   imageID,
+		"imageSize": strconv.Itoa(len(imageData)),
+		"primary":   primary,
+	})
+}
+
+// listAlbumImages handles GET /albums/:albumID/images and lists the gallery
+// with the primary image first.
+func listAlbumImages(c *gin.Context) {
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	query := `SELECT image_id, storage_key, label, image_size, content_type, width, height, is_primary, created_at FROM album_images
+		WHERE album_id = ? ORDER BY is_primary DESC, created_at, image_id`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album images"})
+		return
+	}
+	defer rows.Close()
+
+	gallery := []AlbumImage{}
+	for rows.Next() {
+		var image AlbumImage
+		var key string
+		if err := rows.Scan(&image.ImageID, &key, &image.Label, &image.ImageSize, &image.ContentType, &image.Width, &image.Height, &image.Primary, &image.CreatedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album images"})
+			return
+		}
+		image.URL = imageURL(key, "/albums/"+albumID+"/images/"+image.ImageID)
+		gallery = append(gallery, image)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album images"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "images": gallery})
+}
+
+// getAlbumImage handles GET /albums/:albumID/images/:imageID and downloads one
+// gallery image, or a thumbnail, resized or converted copy of it.
+func getAlbumImage(c *gin.Context) {
+	var key string
+	query := `SELECT storage_key FROM album_images WHERE album_id = ? AND image_id = ?`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	err := readDB(ctx).QueryRowContext(ctx, query, c.Param("albumID"), c.Param("imageID")).Scan(&key)
+	cancel()
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "image not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album image"})
+		return
+	}
+	serveImageVariant(c, key)
+}
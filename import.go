@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxImportErrors = 100 // Maximum number of per-record errors reported by an import
+
+// importError describes a record that could not be imported.
+type importError struct {
+	Record  int    `json:"record"`
+	AlbumID string `json:"albumID,omitempty"`
+	Msg     string `json:"msg"`
+}
+
+// recordReader returns the next exportRecord, or io.EOF when the input is exhausted.
+type recordReader func() (exportRecord, error)
+
+// newNDJSONReader reads exportRecords from newline-delimited JSON.
+func newNDJSONReader(r io.Reader) recordReader {
+	decoder := json.NewDecoder(r)
+	return func() (exportRecord, error) {
+		var record exportRecord
+		err := decoder.Decode(&record)
+		return record, err
+	}
+}
+
+// newCSVReader reads exportRecords from CSV with an exportCSVHeader-style
+// header row. Columns may appear in any order and unknown columns are ignored.
+func newCSVReader(r io.Reader) (recordReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	return func() (exportRecord, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return exportRecord{}, err
+		}
+		record := exportRecord{
+			AlbumID: field(row, "albumID"),
+			Artist:  field(row, "artist"),
+			Title:   field(row, "title"),
+			Genre:   field(row, "genre"),
+			Image:   field(row, "image"),
+		}
+		if record.Year, err = parseAlbumYear(field(row, "year")); err != nil {
+			return record, err
+		}
+		if v := field(row, "createdAt"); v != "" {
+			if record.CreatedAt, err = time.Parse(time.RFC3339, v); err != nil {
+				return record, errors.New("createdAt is not an RFC 3339 timestamp")
+			}
+		}
+		return record, nil
+	}, nil
+}
+
+// adminImport handles POST /admin/import and re-creates albums from the
+// export format. The body is either raw NDJSON or CSV (selected by the format
+// parameter), or a multipart form with the export in a 'data' file and images
+// in 'image' files named after their albumID. Existing albums are updated in
+// place, so importing the same data twice is idempotent. With dryRun=true the
+// records are validated and classified without writing anything.
+func adminImport(c *gin.Context) {
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "ndjson" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: format must be 'ndjson' or 'csv'"})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+
+	// Read the export from the body or from the multipart 'data' file.
+	input := io.Reader(c.Request.Body)
+	imageFiles := map[string]*multipart.FileHeader{}
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		form, err := c.MultipartForm()
+		if err != nil || len(form.File["data"]) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: data file is required"})
+			return
+		}
+		data, err := form.File["data"][0].Open()
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to open data file"})
+			return
+		}
+		defer data.Close()
+		input = data
+		for _, fileHeader := range form.File["image"] {
+			albumID := strings.TrimSuffix(fileHeader.Filename, path.Ext(fileHeader.Filename))
+			imageFiles[albumID] = fileHeader
+		}
+	}
+
+	next := newNDJSONReader(input)
+	if format == "csv" {
+		var err error
+		if next, err = newCSVReader(input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: CSV header is missing"})
+			return
+		}
+	}
+
+	created, updated, failed := 0, 0, 0
+	importErrors := []importError{}
+	fail := func(record int, albumID, msg string) {
+		failed++
+		if len(importErrors) < maxImportErrors {
+			importErrors = append(importErrors, importError{Record: record, AlbumID: albumID, Msg: msg})
+		}
+	}
+	for i := 1; ; i++ {
+		record, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The decoder cannot resynchronize after malformed JSON.
+				fail(i, "", "record is not valid JSON")
+				break
+			}
+			fail(i, "", err.Error())
+			continue
+		}
+		if record.AlbumID == "" {
+			fail(i, "", "albumID is required")
+			continue
+		}
+
+		// Resolve the image from the record or from the uploaded image files.
+		var imageData []byte
+		if record.Image != "" {
+			if imageData, err = base64.StdEncoding.DecodeString(record.Image); err != nil {
+				fail(i, record.AlbumID, "image is not valid base64")
+				continue
+			}
+			if len(imageData) > maxImageSize {
+				fail(i, record.AlbumID, imageTooLargeMsg())
+				continue
+			}
+			if _, err := checkImageType(imageData); err != nil {
+				fail(i, record.AlbumID, err.Error())
+				continue
+			}
+			imageData = stripImageMetadata(imageData)
+		} else if fileHeader, ok := imageFiles[record.AlbumID]; ok {
+			if imageData, err = readImageFile(fileHeader); err != nil {
+				var typeErr *unsupportedImageError
+				if errors.As(err, &typeErr) {
+					fail(i, record.AlbumID, typeErr.Error())
+					continue
+				}
+				if errors.Is(err, errImageTooLarge) {
+					fail(i, record.AlbumID, imageTooLargeMsg())
+					continue
+				}
+				fail(i, record.AlbumID, "failed to read image file")
+				continue
+			}
+		}
+
+		profile := Profile{Artist: record.Artist, Title: record.Title, Year: record.Year, Genre: record.Genre}
+		if err := profile.validate(); err != nil {
+			fail(i, record.AlbumID, err.Error())
+			continue
+		}
+
+		exists, err := albumRepo.Exists(c.Request.Context(), record.AlbumID)
+		if err != nil {
+			fail(i, record.AlbumID, "failed to retrieve album data")
+			continue
+		}
+		if !dryRun {
+			if err := upsertAlbum(c.Request.Context(), record, imageData, profile, exists); err != nil {
+				var quotaErr *quotaError
+				if errors.As(err, &quotaErr) {
+					fail(i, record.AlbumID, quotaErr.Msg)
+					continue
+				}
+				fail(i, record.AlbumID, "failed to persist album data")
+				continue
+			}
+		}
+		if exists {
+			updated++
+		} else {
+			created++
+		}
+	}
+
+	auditDetail(c, "created", created)
+	auditDetail(c, "updated", updated)
+	c.JSON(http.StatusOK, gin.H{
+		"dryRun":  dryRun,
+		"created": created,
+		"updated": updated,
+		"failed":  failed,
+		"errors":  importErrors,
+	})
+}
+
+// upsertAlbum creates or updates an album from an imported record. The image
+// of an existing album is only replaced when imageData is not nil, and new
+// albums without an image get a placeholder cover like uploads do.
+func upsertAlbum(ctx context.Context, record exportRecord, imageData []byte, profile Profile, exists bool) error {
+	newAlbums := 1
+	if exists {
+		newAlbums = 0
+	}
+	if err := checkQuota(ctx, "", newAlbums, int64(len(imageData))); err != nil {
+		return err
+	}
+	var image storedImage
+	var err error
+	if imageData != nil {
+		if image, err = storeImage(ctx, imageData); err != nil {
+			return err
+		}
+	} else if !exists {
+		if image, err = storePlaceholder(ctx, profile); err != nil {
+			return err
+		}
+	}
+	if err := upsertAlbumRecord(ctx, record, image, profile, exists); err != nil {
+		if image.Key != "" {
+			deleteImages(ctx, []string{image.Key})
+		}
+		return err
+	}
+	if image.Key != "" {
+		queueImageJob(ctx, record.AlbumID)
+	}
+	return nil
+}
+
+// upsertAlbumRecord writes the album metadata of an imported record and, when
+// an image was stored, points the primary image at it. Objects left
+// unused, such as a replaced primary image, are deleted after committing.
+func upsertAlbumRecord(ctx context.Context, record exportRecord, image storedImage, profile Profile, exists bool) error {
+	queryCtx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(queryCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	artistID, err := ensureArtistTx(queryCtx, tx, profile.Artist)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		query := `UPDATE albums SET artist = ?, artist_id = ?, title = ?, year = ?, genre = ?, version = version + 1 WHERE album_id = ?`
+		if _, err := tx.ExecContext(queryCtx, query, profile.Artist, artistID, profile.Title, profile.Year, profile.Genre, record.AlbumID); err != nil {
+			return err
+		}
+	} else {
+		createdAt := record.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		query := `INSERT INTO albums (album_id, image_size, image_hash, artist, artist_id, title, year, genre, created_at)
+			VALUES (?, 0, '', ?, ?, ?, ?, ?, ?)`
+		if _, err := tx.ExecContext(queryCtx, query, record.AlbumID, profile.Artist, artistID, profile.Title, profile.Year, profile.Genre, createdAt); err != nil {
+			return err
+		}
+	}
+	var unused []string
+	if image.Key != "" {
+		if unused, err = putPrimaryImageTx(queryCtx, tx, record.AlbumID, image); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateAlbum(ctx, record.AlbumID)
+	deleteImages(ctx, unused)
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gin context keys set for requests with a valid bearer token.
+const (
+	subjectKey = "subject" // The sub claim
+	rolesKey   = "roles"   // []string of roles from the roles claim
+	claimsKey  = "claims"  // jwt.MapClaims with every claim of the token
+	bearerKey  = "bearer"  // true for every request with a valid bearer token
+)
+
+const (
+	jwksRefreshInterval = time.Hour        // Age after which the JWKS is fetched again
+	jwksMinRefetch      = time.Minute      // Shortest time between fetches for unknown key IDs
+	jwksFetchTimeout    = 10 * time.Second // Timeout of a JWKS request
+)
+
+// jwtAuth validates Authorization: Bearer tokens, nil unless JWT_SECRET or
+// JWT_JWKS_URL is set.
+var jwtAuth *jwtVerifier
+
+// jwtVerifier checks the signature and the standard claims of bearer tokens,
+// either with a shared HMAC secret or with the public keys of a JWKS.
+type jwtVerifier struct {
+	secret     []byte
+	jwks       *jwksCache
+	issuer     string // Required iss claim, if set
+	audience   string // Required aud or client_id claim, if set
+	rolesClaim string // Claim holding the roles, as a list or a space-separated string
+	nameClaim  string // Claim naming the users created for new subjects
+	mapUsers   bool   // Whether the subjects of tokens are mapped to users, in OpenID Connect mode
+}
+
+// newJWTVerifier returns the verifier for an HMAC secret or a JWKS URL, or nil
+// when neither is set.
+func newJWTVerifier(secret, jwksURL, issuer, audience, rolesClaim string) (*jwtVerifier, error) {
+	switch {
+	case secret != "" && jwksURL != "":
+		return nil, errors.New("JWT_SECRET and JWT_JWKS_URL cannot be combined")
+	case secret != "":
+		return &jwtVerifier{secret: []byte(secret), issuer: issuer, audience: audience, rolesClaim: rolesClaim}, nil
+	case jwksURL != "":
+		if !strings.HasPrefix(jwksURL, "https://") && !strings.HasPrefix(jwksURL, "http://") {
+			return nil, fmt.Errorf("JWKS URL %q is not an HTTP URL", jwksURL)
+		}
+		return &jwtVerifier{jwks: &jwksCache{url: jwksURL}, issuer: issuer, audience: audience, rolesClaim: rolesClaim}, nil
+	}
+	return nil, nil
+}
+
+// verify parses a token and returns its claims when it is validly signed, not
+// expired and issued by the expected issuer for the expected audience.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithLeeway(30 * time.Second)}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	keyFunc := func(t *jwt.Token) (any, error) { return v.secret, nil }
+	if v.jwks != nil {
+		opts = append(opts, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}))
+		keyFunc = func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return v.jwks.key(ctx, kid)
+		}
+	} else {
+		opts = append(opts, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.NewParser(opts...).ParseWithClaims(token, claims, keyFunc); err != nil {
+		return nil, err
+	}
+	if v.audience != "" {
+		// Cognito access tokens name the client in client_id instead of aud.
+		audience, _ := claims.GetAudience()
+		if clientID, _ := claims["client_id"].(string); !slices.Contains(audience, v.audience) && clientID != v.audience {
+			return nil, errors.New("token is not issued for this audience")
+		}
+	}
+	return claims, nil
+}
+
+// roles returns the roles listed in the token's roles claim. A claim of
+// nested objects is named by a dotted path, such as Keycloak's
+// realm_access.roles.
+func (v *jwtVerifier) roles(claims jwt.MapClaims) []string {
+	var claim any = map[string]any(claims)
+	for _, name := range strings.Split(v.rolesClaim, ".") {
+		object, _ := claim.(map[string]any)
+		claim = object[name]
+	}
+	var roles []string
+	switch value := claim.(type) {
+	case string:
+		roles = strings.Fields(value)
+	case []any:
+		for _, role := range value {
+			if role, ok := role.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// bearerAuth is a middleware that validates an Authorization: Bearer token
+// and stores its subject, roles and claims in the context, and in OpenID
+// Connect mode the ID of its user. Requests without a bearer token pass
+// through; those with an invalid one are rejected.
+func bearerAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if jwtAuth == nil || !strings.HasPrefix(header, "Bearer ") {
+			c.Next()
+			return
+		}
+		claims, err := jwtAuth.verify(c.Request.Context(), strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid bearer token"})
+			return
+		}
+		subject, _ := claims.GetSubject()
+		c.Set(subjectKey, subject)
+		c.Set(rolesKey, jwtAuth.roles(claims))
+		c.Set(claimsKey, claims)
+		c.Set(bearerKey, true)
+		if jwtAuth.mapUsers {
+			user, err := jwtAuth.oidcUser(c.Request.Context(), claims)
+			if err != nil {
+				c.Error(err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "failed to authenticate user"})
+				return
+			}
+			c.Set(userIDKey, user.UserID)
+			c.Set(userRoleKey, user.Role)
+		}
+		c.Next()
+	}
+}
+
+// jwksCache holds the public keys of a JWKS by key ID. It is fetched on first
+// use, again after jwksRefreshInterval, and when a token names an unknown key
+// so that rotated keys are picked up. Fetches are attempted at most every
+// jwksMinRefetch and are shared by the concurrent requests needing one.
+type jwksCache struct {
+	url     string
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetched   time.Time // Time of the last successful fetch
+	attempted time.Time // Time of the last fetch, successful or not
+	err       error     // Error of the last fetch
+}
+
+// key returns the public key with the given ID. A stale key is returned at
+// once while the JWKS is fetched again in the background, so it is kept in use
+// while the JWKS cannot be fetched; an unknown key waits for the fetch.
+func (j *jwksCache) key(ctx context.Context, kid string) (any, error) {
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	stale := time.Since(j.fetched) > jwksRefreshInterval
+	due := time.Since(j.attempted) > jwksMinRefetch
+	lastErr := j.err
+	j.mu.Unlock()
+
+	switch {
+	case ok:
+		if stale && due {
+			j.refresh()
+		}
+		return key, nil
+	case !due && lastErr != nil:
+		return nil, lastErr
+	case !due:
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-j.refresh():
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if key, ok := result.Val.(map[string]any)[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+}
+
+// refresh fetches the JWKS, unless a fetch is already running, and records
+// the attempt. The fetch is not tied to the request that started it, which
+// may not wait for it.
+func (j *jwksCache) refresh() <-chan singleflight.Result {
+	return j.fetches.DoChan("jwks", func() (any, error) {
+		keys, err := fetchJWKS(context.Background(), j.url)
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.attempted, j.err = time.Now(), err
+		if err != nil {
+			logger.Warn().Err(err).Str("url", j.url).Msg("Error fetching JWKS")
+			return nil, err
+		}
+		j.keys, j.fetched = keys, j.attempted
+		return keys, nil
+	})
+}
+
+// fetchJWKS downloads a JWKS and returns its signing keys by key ID.
+func fetchJWKS(ctx context.Context, url string) (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", resp.Status)
+	}
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := map[string]any{}
+	for _, key := range set.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			keys[key.KeyID] = key.Key
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestJWTVerifierHMAC(t *testing.T) {
+	verifier, err := newJWTVerifier("secret", "", "issuer", "", "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(secret string, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	claims, err := verifier.verify(context.Background(), sign("secret", jwt.MapClaims{
+		"sub": "alice", "iss": "issuer", "exp": exp, "roles": []string{"admin", "writer"},
+	}))
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if subject, _ := claims.GetSubject(); subject != "alice" {
+		t.Errorf("subject = %q, want alice", subject)
+	}
+	if roles := verifier.roles(claims); !slices.Equal(roles, []string{"admin", "writer"}) {
+		t.Errorf("roles = %v, want [admin writer]", roles)
+	}
+
+	for name, token := range map[string]string{
+		"wrong secret": sign("other", jwt.MapClaims{"sub": "alice", "iss": "issuer", "exp": exp}),
+		"wrong issuer": sign("secret", jwt.MapClaims{"sub": "alice", "iss": "other", "exp": exp}),
+		"expired":      sign("secret", jwt.MapClaims{"sub": "alice", "iss": "issuer", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":    sign("secret", jwt.MapClaims{"sub": "alice", "iss": "issuer"}),
+	} {
+		if _, err := verifier.verify(context.Background(), token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
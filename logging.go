@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logger writes the logs of the server, one JSON object per line by default.
+// Messages of the standard log package, as written by libraries, go through
+// it too.
+var logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+// configureLogging sets the level of logger from LOG_LEVEL (debug, info, warn
+// or error) and its format from LOG_FORMAT: json, or console for readable
+// lines during development.
+func configureLogging() error {
+	level, err := zerolog.ParseLevel(strings.ToLower(getEnv("LOG_LEVEL", "info")))
+	if err != nil || level == zerolog.NoLevel {
+		return fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error, got %q", os.Getenv("LOG_LEVEL"))
+	}
+	var out io.Writer = os.Stderr
+	switch format := getEnv("LOG_FORMAT", "json"); format {
+	case "json":
+	case "console":
+		out = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	default:
+		return fmt.Errorf("LOG_FORMAT must be json or console, got %q", format)
+	}
+	zerolog.DurationFieldUnit = time.Millisecond
+	zerolog.DurationFieldInteger = false
+	logger = zerolog.New(out).Level(level).With().Timestamp().Logger()
+	log.SetFlags(0)
+	log.SetOutput(logger)
+	return configureAccessLog()
+}
+
+// accessLogFields are the fields the lines of logRequests may hold, and
+// defaultAccessLogFields those they hold unless ACCESS_LOG_FIELDS is set.
+var (
+	accessLogFields        = []string{"method", "path", "route", "status", "latency", "bytes", "clientIP", "requestID", "albumID", "errors", "userAgent", "referer", "query"}
+	defaultAccessLogFields = []string{"method", "path", "route", "status", "latency", "bytes", "clientIP", "requestID", "albumID", "errors"}
+)
+
+// accessLog selects the fields of the request lines, set by ACCESS_LOG_FIELDS,
+// and the fraction of the requests that are logged: successRate of those
+// answered with a 1xx, 2xx or 3xx status (ACCESS_LOG_SAMPLE_RATE) and
+// errorRate of the others (ACCESS_LOG_ERROR_SAMPLE_RATE). Sampling the
+// successful requests keeps the errors in the logs of busy servers.
+var accessLog = struct {
+	fields      map[string]bool
+	successRate float64
+	errorRate   float64
+}{successRate: 1, errorRate: 1}
+
+// configureAccessLog reads the access log settings from the environment.
+func configureAccessLog() error {
+	accessLog.fields = map[string]bool{}
+	for _, field := range getEnvList("ACCESS_LOG_FIELDS", defaultAccessLogFields) {
+		if !slices.Contains(accessLogFields, field) {
+			return fmt.Errorf("ACCESS_LOG_FIELDS has unknown field %q, expected some of %v", field, accessLogFields)
+		}
+		accessLog.fields[field] = true
+	}
+	accessLog.successRate = getEnvFloat("ACCESS_LOG_SAMPLE_RATE", 1)
+	accessLog.errorRate = getEnvFloat("ACCESS_LOG_ERROR_SAMPLE_RATE", 1)
+	if accessLog.successRate < 0 || accessLog.successRate > 1 || accessLog.errorRate < 0 || accessLog.errorRate > 1 {
+		return errors.New("ACCESS_LOG_SAMPLE_RATE and ACCESS_LOG_ERROR_SAMPLE_RATE must be between 0 and 1")
+	}
+	return nil
+}
+
+// logFor returns the logger of a request context, which adds the request ID
+// to the lines, or logger outside of requests.
+func logFor(ctx context.Context) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return &logger
+}
+
+// logRequests is a middleware that logs the requests once they are handled,
+// with the fields of accessLog and a sample of them. Server errors are
+// logged as errors and client errors as warnings; health probes are only
+// logged at the debug level. Sampled lines hold their sampleRate, so counts
+// can be scaled back.
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		rate := accessLog.successRate
+		if status >= 400 {
+			rate = accessLog.errorRate
+		}
+		if rate < 1 && rand.Float64() >= rate {
+			return
+		}
+		event := logger.Info()
+		switch {
+		case status >= 500:
+			event = logger.Error()
+		case status >= 400:
+			event = logger.Warn()
+		case publicRoutes[c.FullPath()]:
+			event = logger.Debug()
+		}
+		if !event.Enabled() {
+			return
+		}
+		str := func(field, value string) {
+			if accessLog.fields[field] && value != "" {
+				event = event.Str(field, value)
+			}
+		}
+		str("method", c.Request.Method)
+		str("path", c.Request.URL.Path)
+		str("route", c.FullPath())
+		if accessLog.fields["status"] {
+			event = event.Int("status", status)
+		}
+		if accessLog.fields["latency"] {
+			event = event.Dur("latency", time.Since(start))
+		}
+		if accessLog.fields["bytes"] {
+			event = event.Int("bytes", c.Writer.Size())
+		}
+		str("clientIP", c.ClientIP())
+		str("requestID", c.GetString(requestIDKey))
+		if albumID := c.Param("albumID"); albumID != "" {
+			str("albumID", albumID)
+		} else if strings.HasPrefix(c.FullPath(), "/albums") {
+			// The album created by POST /albums or /albums/complete.
+			str("albumID", c.GetString(auditTargetKey))
+		}
+		str("errors", c.Errors.String())
+		str("userAgent", c.Request.UserAgent())
+		str("referer", c.Request.Referer())
+		str("query", c.Request.URL.RawQuery)
+		if rate < 1 {
+			event = event.Float64("sampleRate", rate)
+		}
+		event.Msg("request")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxLookupIDs = 100 // Maximum number of albumIDs in one bulk fetch
+
+// lookupAlbums fetches the albums with the given IDs in one query. Albums are
+// returned in request order and the IDs that do not exist are listed in missing.
+func lookupAlbums(ctx context.Context, albumIDs []string) (albums []Album, missing []string, err error) {
+	found, err := albumRepo.Lookup(ctx, albumIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byID := make(map[string]Album, len(found))
+	for _, album := range found {
+		byID[album.AlbumID] = album
+	}
+	albums, missing = []Album{}, []string{}
+	for _, albumID := range albumIDs {
+		if album, ok := byID[albumID]; ok {
+			albums = append(albums, album)
+		} else {
+			missing = append(missing, albumID)
+		}
+	}
+	return albums, missing, nil
+}
+
+// respondLookup validates the requested albumIDs and writes the bulk fetch response.
+func respondLookup(c *gin.Context, albumIDs []string) {
+	if len(albumIDs) == 0 || len(albumIDs) > maxLookupIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: between 1 and " + strconv.Itoa(maxLookupIDs) + " albumIDs are required"})
+		return
+	}
+	albums, missing, err := lookupAlbums(c.Request.Context(), albumIDs)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albums": albums, "missing": missing})
+}
+
+// getAlbumsByIDs handles GET /albums?ids=a,b,c.
+func getAlbumsByIDs(c *gin.Context) {
+	var albumIDs []string
+	for _, albumID := range strings.Split(c.Query("ids"), ",") {
+		if albumID = strings.TrimSpace(albumID); albumID != "" {
+			albumIDs = append(albumIDs, albumID)
+		}
+	}
+	respondLookup(c, albumIDs)
+}
+
+// postLookup handles POST /albums/lookup with a JSON array of albumIDs.
+func postLookup(c *gin.Context) {
+	var albumIDs []string
+	if err := c.ShouldBindJSON(&albumIDs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body must be a JSON array of albumIDs"})
+		return
+	}
+	respondLookup(c, albumIDs)
+}
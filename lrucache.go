@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a bounded in-process cache evicting the least recently used
+// entries once the summed cost of its entries exceeds maxCost. The cost of an
+// entry is given by costOf, such as 1 to bound the number of entries or the
+// length of a byte slice to bound the memory used.
+type lruCache[V any] struct {
+	mu      sync.Mutex
+	maxCost int64
+	cost    int64
+	costOf  func(V) int64
+	order   *list.List // Of *lruEntry[V], most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+	cost  int64
+}
+
+// newLRUCache returns an empty cache holding entries up to maxCost.
+func newLRUCache[V any](maxCost int64, costOf func(V) int64) *lruCache[V] {
+	return &lruCache[V]{maxCost: maxCost, costOf: costOf, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the value cached under key and marks it as recently used.
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[V]).value, true
+}
+
+// add caches value under key, evicting the least recently used entries to
+// make room. Values costing more than the whole cache are not cached.
+func (c *lruCache[V]) add(key string, value V) {
+	cost := c.costOf(value)
+	if cost > c.maxCost {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key, value, cost})
+	c.cost += cost
+	for c.cost > c.maxCost {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove drops the entries cached under the keys.
+func (c *lruCache[V]) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *lruCache[V]) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry[V])
+	delete(c.entries, entry.key)
+	c.cost -= entry.cost
+}
@@ -1,25 +1,27 @@
 package main
 
 import (
-	"database/sql"                     // database
-	"encoding/json"                    // JSON
-	"github.com/gin-gonic/gin"         // Gin web framework
-	_ "github.com/go-sql-driver/mysql" // MySQL driver
-	"github.com/google/uuid"           // UUID generator
-	"io"
+	"context"
+	"database/sql" // database
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin" // Gin web framework
+	"github.com/google/uuid"   // UUID generator
 	"log"
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
-	"time"
+	"strings"
 )
 
-// Profile represents the album profile containing artist, title, and year.
+// Profile represents the album profile containing artist, title, year, and genre.
 type Profile struct {
-	Artist string `json:"artist"`
-	Title  string `json:"title"`
-	Year   string `json:"year"`
+	Artist string    `json:"artist" binding:"max=255"`
+	Title  string    `json:"title" binding:"max=255"`
+	Year   albumYear `json:"year" binding:"albumyear"`
+	Genre  string    `json:"genre" binding:"genre"`
 }
 
 var db *sql.DB // Global database connection
@@ -28,78 +30,471 @@ func main() {
 	// Use all available CPU cores.
 	runtime.GOMAXPROCS(runtime.NumCPU() * 30)
 
+	// Log as JSON, or as readable lines with LOG_FORMAT=console, from LOG_LEVEL up
+	if err := configureLogging(); err != nil {
+		log.Fatalf("Error configuring logging: %v", err)
+	}
+
 	// Set Gin to release mode for better performance
 	gin.SetMode(gin.ReleaseMode)
 
+	// Load the list of genres accepted in album profiles
+	loadAllowedGenres()
+
+	// Enable the /admin endpoints when ADMIN_TOKEN is set
+	adminToken = os.Getenv("ADMIN_TOKEN")
+
+	// Only accept admin requests from the networks of ADMIN_ALLOWED_CIDRS. Client
+	// addresses can only be trusted behind the TRUSTED_PROXIES, or "none"
+	trustedProxies := getEnvList("TRUSTED_PROXIES", nil)
+	var err error
+	if adminAllowedNets, err = parseCIDRs(getEnvList("ADMIN_ALLOWED_CIDRS", nil)); err != nil {
+		log.Fatalf("Error parsing ADMIN_ALLOWED_CIDRS: %v", err)
+	}
+	if len(adminAllowedNets) > 0 && trustedProxies == nil {
+		log.Fatalf("ADMIN_ALLOWED_CIDRS needs TRUSTED_PROXIES, the networks of the load balancers, or \"none\"")
+	}
+	if len(trustedProxies) == 1 && trustedProxies[0] == "none" {
+		trustedProxies = []string{}
+	}
+
+	// Reject uploads of already stored images when REJECT_DUPLICATE_IMAGES is set
+	rejectDuplicateImages = getEnvBool("REJECT_DUPLICATE_IMAGES", false)
+
+	// Report albums with a visually similar cover on upload when WARN_SIMILAR_IMAGES is set
+	warnSimilarImages = getEnvBool("WARN_SIMILAR_IMAGES", false)
+	similarMaxDistance = getEnvInt("SIMILAR_MAX_DISTANCE", similarMaxDistance)
+
 	// Get the database DSN from environment variable DB_DSN
 	dsn := os.Getenv("DB_DSN")
 	if dsn == "" {
 		log.Fatal("DB_DSN environment variable is not set")
 	}
 
-	// Open a connection to the MySQL database
-	var err error
-	db, err = sql.Open("mysql", dsn)
+	// Export traces of the requests and database calls to the OTLP collector
+	// at OTEL_EXPORTER_OTLP_ENDPOINT, if set
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Error configuring tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Report panics and server errors to Sentry when SENTRY_DSN is set
+	flushErrorReports, err := setupErrorReporting()
+	if err != nil {
+		log.Fatalf("Error configuring error reporting: %v", err)
+	}
+	defer flushErrorReports()
+
+	// Take the database user and password from the AWS Secrets Manager secret
+	// DB_SECRET_ID or the Vault secret at DB_VAULT_PATH, if set, and keep them
+	// up to date as they rotate
+	if dbSecrets, err = newDBSecrets(context.Background()); err != nil {
+		log.Fatalf("Error loading database credentials: %v", err)
+	}
+
+	// Log the database calls taking longer than DB_SLOW_QUERY_THRESHOLD
+	if slowQueryThreshold = getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 0); slowQueryThreshold < 0 {
+		log.Fatalf("DB_SLOW_QUERY_THRESHOLD must not be negative, got %v", slowQueryThreshold)
+	}
+	logSlowQueryArgs = getEnvBool("DB_SLOW_QUERY_LOG_ARGS", logSlowQueryArgs)
+
+	// Open a connection to the MySQL database, or to PostgreSQL when
+	// DB_DRIVER is "postgres" and to an SQLite file or ":memory:" when it is
+	// "sqlite"
+	db, err = openDB(os.Getenv("DB_DRIVER"), dsn)
 	if err != nil {
 		log.Fatalf("Error opening DB: %v", err)
 	}
 	defer db.Close()
 
-	db.SetMaxOpenConns(300)
-	db.SetMaxIdleConns(100)
-	db.SetConnMaxLifetime(30 * time.Minute)
+	configurePool(db)
+	if queryTimeout = getEnvDuration("DB_QUERY_TIMEOUT", queryTimeout); queryTimeout <= 0 {
+		log.Fatalf("DB_QUERY_TIMEOUT must be positive, got %v", queryTimeout)
+	}
+	if retryAttempts = getEnvInt("DB_RETRY_ATTEMPTS", retryAttempts); retryAttempts < 1 {
+		log.Fatalf("DB_RETRY_ATTEMPTS must be at least 1, got %d", retryAttempts)
+	}
+	if retryBaseDelay = getEnvDuration("DB_RETRY_BASE_DELAY", retryBaseDelay); retryBaseDelay <= 0 {
+		log.Fatalf("DB_RETRY_BASE_DELAY must be positive, got %v", retryBaseDelay)
+	}
 
 	// Verify the database connection
 	if err = db.Ping(); err != nil {
 		log.Fatalf("Error pinging DB: %v", err)
 	}
 
-	// Create the albums table if it does not exist
-	createTableQuery := `CREATE TABLE IF NOT EXISTS albums (
-		album_id VARCHAR(255) PRIMARY KEY,
-		image_data LONGBLOB,
-		image_size INT NOT NULL,
-		artist VARCHAR(255) NOT NULL,
-		title VARCHAR(255) NOT NULL,
-		year VARCHAR(4) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	_, err = db.Exec(createTableQuery)
+	// Serve GET requests from the read replicas in DB_READ_DSNS, if any
+	if err = openReplicas(os.Getenv("DB_DRIVER"), getEnvList("DB_READ_DSNS", nil)); err != nil {
+		log.Fatalf("Error opening read replicas: %v", err)
+	}
+	registerDBMetrics()
+
+	// Select where image bytes are stored
+	imageStore, err = newImageStore(context.Background())
+	if err != nil {
+		log.Fatalf("Error configuring image store: %v", err)
+	}
+	presignExpiry = getEnvDuration("PRESIGN_EXPIRY", presignExpiry)
+	imageURLSecret = []byte(os.Getenv("IMAGE_URL_SECRET"))
+	imageURLExpiry = getEnvDuration("IMAGE_URL_EXPIRY", imageURLExpiry)
+	maxImageURLExpiry = getEnvDuration("IMAGE_URL_MAX_EXPIRY", maxImageURLExpiry)
+	if imageURLExpiry <= 0 || imageURLExpiry > maxImageURLExpiry {
+		log.Fatalf("IMAGE_URL_EXPIRY must be positive and at most IMAGE_URL_MAX_EXPIRY, got %v", imageURLExpiry)
+	}
+	imageBaseURL = os.Getenv("IMAGE_BASE_URL")
+	maxImageSize = getEnvInt("MAX_IMAGE_BYTES", getEnvInt("MAX_IMAGE_SIZE", maxImageSize))
+	maxImagePixels = getEnvInt("MAX_IMAGE_PIXELS", maxImagePixels)
+	stripMetadata = getEnvBool("STRIP_IMAGE_METADATA", stripMetadata)
+	if types := getEnvList("ALLOWED_IMAGE_TYPES", nil); types != nil {
+		if allowedImageTypes, err = parseImageTypes(types); err != nil {
+			log.Fatalf("Error parsing ALLOWED_IMAGE_TYPES: %v", err)
+		}
+	}
+
+	// Apply the schema migrations and exit when run as "migrate", or apply
+	// them on startup when MIGRATE_ON_START is set (the default for SQLite).
+	// Refuse to start with a schema older than the embedded migrations. Legacy
+	// databases are upgraded through the image store, so it is selected first.
+	migrator, err := newMigrate(os.Getenv("DB_DRIVER"), dsn)
+	if err != nil {
+		log.Fatalf("Error opening schema migrations: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(migrator, os.Args[2:])
+	}
+	if getEnvBool("MIGRATE_ON_START", dialect == sqliteDialect) {
+		if err = migrateSchema(migrator); err != nil {
+			log.Fatalf("Error migrating schema: %v", err)
+		}
+	}
+	if err = checkSchemaVersion(migrator); err != nil {
+		log.Fatalf("Error checking schema version: %v", err)
+	}
+	migrator.Close()
+	logger.Info().Msg("Database schema is up to date")
+	schemaReady.Store(true)
+
+	// Fill the database and the image store with generated albums and exit
+	// when run as "seed"
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeedCommand(os.Args[2:])
+	}
+
+	// Configure the thumbnails generated for uploaded images
+	if specs := getEnvList("THUMBNAIL_SIZES", nil); specs != nil {
+		if thumbnailSizes, err = parseThumbnailSizes(specs); err != nil {
+			log.Fatalf("Error parsing THUMBNAIL_SIZES: %v", err)
+		}
+	}
+	if values := getEnvList("RESIZE_DIMENSIONS", nil); values != nil {
+		if resizeDimensions, err = parseResizeDimensions(values); err != nil {
+			log.Fatalf("Error parsing RESIZE_DIMENSIONS: %v", err)
+		}
+	}
+	acceptFormats = getEnvList("ACCEPT_IMAGE_FORMATS", acceptFormats)
+
+	// Process the images of new albums on IMAGE_WORKERS goroutines fed by a
+	// queue of IMAGE_QUEUE_SIZE albums
+	imageWorkers = getEnvInt("IMAGE_WORKERS", imageWorkers)
+	imageQueueSize = getEnvInt("IMAGE_QUEUE_SIZE", imageQueueSize)
+	if imageWorkers < 0 || imageQueueSize < 1 {
+		log.Fatalf("IMAGE_WORKERS must not be negative and IMAGE_QUEUE_SIZE must be positive")
+	}
+	preconvertFormats = getEnvList("IMAGE_PRECONVERT_FORMATS", nil)
+	for _, format := range preconvertFormats {
+		if !slices.Contains(outputFormats, format) {
+			log.Fatalf("Unknown format %q in IMAGE_PRECONVERT_FORMATS, must be one of %s", format, strings.Join(outputFormats, ", "))
+		}
+	}
+	if imageWorkers > 0 {
+		startImageWorkers()
+	}
+
+	// Limit the number of albums and the total image size, overall and per user, when quotas are set
+	quotaMaxAlbums = getEnvInt("QUOTA_MAX_ALBUMS", 0)
+	quotaMaxBytes = getEnvInt("QUOTA_MAX_BYTES", 0)
+	quotaUserMaxAlbums = getEnvInt("QUOTA_USER_MAX_ALBUMS", 0)
+	quotaUserMaxBytes = getEnvInt("QUOTA_USER_MAX_BYTES", 0)
+
+	// Only allow GET /reset to delete all data when explicitly enabled
+	allowDataReset = getEnvBool("ALLOW_DATA_RESET", false)
+
+	// Require an X-API-Key issued through /admin/api-keys to change data
+	requireAPIKeys = getEnvBool("REQUIRE_API_KEY", false)
+
+	// Accept Authorization: Bearer tokens signed with JWT_SECRET or a key of the JWKS at JWT_JWKS_URL
+	jwtAuth, err = newJWTVerifier(os.Getenv("JWT_SECRET"), os.Getenv("JWT_JWKS_URL"),
+		os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"), getEnv("JWT_ROLES_CLAIM", "roles"))
 	if err != nil {
-		log.Fatalf("Error creating table: %v", err)
+		log.Fatalf("Error configuring JWT authentication: %v", err)
+	}
+
+	// Or accept the tokens of the OpenID Connect issuer at OIDC_ISSUER and sign in their users
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		if jwtAuth != nil {
+			log.Fatalf("OIDC_ISSUER cannot be combined with JWT_SECRET or JWT_JWKS_URL")
+		}
+		jwtAuth, err = newOIDCVerifier(context.Background(), issuer, os.Getenv("OIDC_AUDIENCE"),
+			getEnv("OIDC_ROLES_CLAIM", "roles"), getEnv("OIDC_NAME_CLAIM", "preferred_username"))
+		if err != nil {
+			log.Fatalf("Error configuring OpenID Connect issuer %s: %v", issuer, err)
+		}
+	}
+
+	// Accept the Basic auth users of BASIC_AUTH_USERS, with the admin role for BASIC_AUTH_ADMINS
+	if basicAuthUsers, err = parseBasicAuthUsers(getEnvList("BASIC_AUTH_USERS", nil)); err != nil {
+		log.Fatalf("Error parsing BASIC_AUTH_USERS: %v", err)
+	}
+	basicAuthAdmins = map[string]bool{}
+	for _, name := range getEnvList("BASIC_AUTH_ADMINS", nil) {
+		if _, ok := basicAuthUsers[name]; !ok {
+			log.Fatalf("BASIC_AUTH_ADMINS names %q, who is not in BASIC_AUTH_USERS", name)
+		}
+		basicAuthAdmins[name] = true
+	}
+
+	// Verify the requests signed with the REQUEST_SIGNING_KEYS of server-to-server callers
+	if signingKeys, err = parseSigningKeys(getEnvList("REQUEST_SIGNING_KEYS", nil)); err != nil {
+		log.Fatalf("Error parsing REQUEST_SIGNING_KEYS: %v", err)
+	}
+	requireSignatures = getEnvBool("REQUIRE_REQUEST_SIGNATURE", false)
+	if requireSignatures && len(signingKeys) == 0 {
+		log.Fatalf("REQUIRE_REQUEST_SIGNATURE needs REQUEST_SIGNING_KEYS")
+	}
+	if maxSignatureAge = getEnvDuration("REQUEST_SIGNATURE_MAX_AGE", maxSignatureAge); maxSignatureAge <= 0 {
+		log.Fatalf("REQUEST_SIGNATURE_MAX_AGE must be positive, got %v", maxSignatureAge)
+	}
+
+	// Limit the requests per client IP and per API key, in memory or in the Redis at RATE_LIMIT_REDIS_URL
+	if ipRateLimit, err = parseRateLimit("RATE_LIMIT_IP"); err != nil {
+		log.Fatal(err)
+	}
+	if apiKeyRateLimit, err = parseRateLimit("RATE_LIMIT_API_KEY"); err != nil {
+		log.Fatal(err)
+	}
+	if url := os.Getenv("RATE_LIMIT_REDIS_URL"); url != "" {
+		if rateLimiter, err = newRedisBuckets(url); err != nil {
+			log.Fatalf("Error connecting to the rate limit Redis: %v", err)
+		}
+	}
+
+	// Insert the albums created within DB_BATCH_WINDOW of each other together,
+	// up to DB_BATCH_MAX_SIZE
+	if albumBatchWindow = getEnvDuration("DB_BATCH_WINDOW", 0); albumBatchWindow < 0 {
+		log.Fatalf("DB_BATCH_WINDOW must not be negative, got %v", albumBatchWindow)
+	}
+	if albumBatchSize = getEnvInt("DB_BATCH_MAX_SIZE", albumBatchSize); albumBatchSize < 1 {
+		log.Fatalf("DB_BATCH_MAX_SIZE must be positive, got %d", albumBatchSize)
+	}
+	if albumBatchWindow > 0 {
+		albumRepo = newBatchingAlbumRepository(albumRepo)
+	}
+
+	// Cache the albums read by GET /albums/:albumID in the Redis at
+	// ALBUM_CACHE_REDIS_URL, or in memory with ALBUM_CACHE=memory
+	cacheBackend := getEnv("ALBUM_CACHE", "")
+	if cacheBackend == "" && os.Getenv("ALBUM_CACHE_REDIS_URL") != "" {
+		cacheBackend = "redis"
+	}
+	if albumCacheTTL = getEnvDuration("ALBUM_CACHE_TTL", albumCacheTTL); albumCacheTTL <= 0 {
+		log.Fatalf("ALBUM_CACHE_TTL must be positive, got %v", albumCacheTTL)
+	}
+	switch cacheBackend {
+	case "":
+	case "redis":
+		client, err := connectRedis(os.Getenv("ALBUM_CACHE_REDIS_URL"))
+		if err != nil {
+			log.Fatalf("Error connecting to the album cache Redis: %v", err)
+		}
+		albumCache = redisAlbumCache{client}
+	case "memory":
+		size := getEnvInt("ALBUM_CACHE_SIZE", 10000)
+		if size <= 0 {
+			log.Fatalf("ALBUM_CACHE_SIZE must be positive, got %d", size)
+		}
+		albumCache = newMemoryAlbumCache(size)
+	default:
+		log.Fatalf("ALBUM_CACHE must be redis or memory, got %q", cacheBackend)
 	}
-	log.Println("Albums table created or already exists.")
+	if albumCache != nil {
+		albumRepo = cachingAlbumRepository{albumRepo}
+	}
+
+	// Keep up to THUMBNAIL_CACHE_BYTES of small thumbnails in memory
+	if size := getEnvInt("THUMBNAIL_CACHE_BYTES", 0); size > 0 {
+		thumbnailCache = newLRUCache(int64(size), func(data []byte) int64 { return int64(len(data)) })
+	}
+
+	// Cap request bodies by type; uploads are capped by MAX_IMAGE_BYTES instead
+	maxJSONBodySize = int64(getEnvInt("MAX_JSON_BODY_BYTES", int(maxJSONBodySize)))
+	maxMultipartBodySize = int64(getEnvInt("MAX_MULTIPART_BODY_BYTES", int(maxMultipartBodySize)))
+	maxBodySize = int64(getEnvInt("MAX_BODY_BYTES", int(maxBodySize)))
+	maxImportBodySize = int64(getEnvInt("MAX_IMPORT_BODY_BYTES", int(maxImportBodySize)))
+	if min(maxJSONBodySize, maxMultipartBodySize, maxBodySize, maxImportBodySize) <= 0 {
+		log.Fatalf("MAX_JSON_BODY_BYTES, MAX_MULTIPART_BODY_BYTES, MAX_BODY_BYTES and MAX_IMPORT_BODY_BYTES must be positive")
+	}
+
+	// Compress the JSON and export responses with COMPRESSION_ENCODINGS, from
+	// COMPRESSION_MIN_BYTES
+	if compression.encodings, err = parseCompressionEncodings(getEnvList("COMPRESSION_ENCODINGS", compression.encodings)); err != nil {
+		log.Fatalf("Error configuring compression: %v", err)
+	}
+	compression.minSize = getEnvInt("COMPRESSION_MIN_BYTES", compression.minSize)
+
+	// Allow the browser front-ends of CORS_ALLOWED_ORIGINS to call the API
+	cors.AllowedOrigins = getEnvList("CORS_ALLOWED_ORIGINS", nil)
+	cors.AllowedMethods = getEnvList("CORS_ALLOWED_METHODS", cors.AllowedMethods)
+	for i, method := range cors.AllowedMethods {
+		cors.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	cors.AllowedHeaders = getEnvList("CORS_ALLOWED_HEADERS", cors.AllowedHeaders)
+	cors.ExposedHeaders = getEnvList("CORS_EXPOSED_HEADERS", cors.ExposedHeaders)
+	cors.AllowCredentials = getEnvBool("CORS_ALLOW_CREDENTIALS", false)
+	if cors.AllowCredentials && slices.Contains(cors.AllowedOrigins, "*") {
+		log.Fatalf("CORS_ALLOW_CREDENTIALS must not be set when CORS_ALLOWED_ORIGINS allows any origin with *")
+	}
+	cors.MaxAge = getEnvDuration("CORS_MAX_AGE", cors.MaxAge)
+
+	// Send the security headers configured by CONTENT_SECURITY_POLICY, REFERRER_POLICY,
+	// X_FRAME_OPTIONS and HSTS_*; "off" disables a header
+	security.ContentSecurityPolicy = getEnvHeader("CONTENT_SECURITY_POLICY", security.ContentSecurityPolicy)
+	security.ReferrerPolicy = getEnvHeader("REFERRER_POLICY", security.ReferrerPolicy)
+	security.FrameOptions = getEnvHeader("X_FRAME_OPTIONS", security.FrameOptions)
+	security.HSTSMaxAge = getEnvDuration("HSTS_MAX_AGE", security.HSTSMaxAge)
+	security.HSTSIncludeSubdomains = getEnvBool("HSTS_INCLUDE_SUBDOMAINS", false)
 
-	// Create a Gin router with default middleware (logger and recovery)
-	router := gin.Default()
+	// Give requests without credentials the ANONYMOUS_ROLE
+	role := getEnv("ANONYMOUS_ROLE", anonymousRole)
+	if anonymousRole, err = parseRole(role); err != nil || anonymousRole == roleAdmin {
+		log.Fatalf("ANONYMOUS_ROLE must be writer, reader or none, got %q", role)
+	}
+
+	// Periodically delete expired albums and albums older than RETENTION_MAX_AGE
+	retentionMaxAge = getEnvDuration("RETENTION_MAX_AGE", 0)
+	retentionInterval = getEnvDuration("RETENTION_INTERVAL", retentionInterval)
+	if retentionInterval <= 0 {
+		log.Fatalf("RETENTION_INTERVAL must be positive, got %v", retentionInterval)
+	}
+	startRetentionJob()
+
+	// Queue the albums uploaded by POST /albums in WRITE_QUEUE, inserted by
+	// WRITE_QUEUE_CONSUMERS goroutines
+	if writeQueue, err = newAlbumWriteQueue(context.Background()); err != nil {
+		log.Fatalf("Error configuring write queue: %v", err)
+	}
+	writeConsumers = getEnvInt("WRITE_QUEUE_CONSUMERS", writeConsumers)
+	writeAttempts = getEnvInt("WRITE_QUEUE_MAX_ATTEMPTS", writeAttempts)
+	if writeConsumers < 0 || writeAttempts < 1 {
+		log.Fatalf("WRITE_QUEUE_CONSUMERS must not be negative and WRITE_QUEUE_MAX_ATTEMPTS must be positive")
+	}
+	if writeQueue != nil {
+		startWriteConsumers()
+	}
+	readyTimeout = getEnvDuration("READY_TIMEOUT", readyTimeout)
+
+	// Send the request metrics to CloudWatch when CLOUDWATCH_NAMESPACE is set
+	if cloudWatch, err = newCloudWatchEmitter(); err != nil {
+		log.Fatalf("Error configuring CloudWatch metrics: %v", err)
+	} else if cloudWatch != nil {
+		go cloudWatch.run()
+	}
+
+	// Serve the pprof profiles and runtime statistics on DEBUG_ADDR, when it is set
+	if addr := os.Getenv("DEBUG_ADDR"); addr != "" {
+		if err = serveDebug(addr); err != nil {
+			log.Fatalf("Error listening on DEBUG_ADDR: %v", err)
+		}
+	}
+
+	// Serve over TLS with TLS_CERT_FILE and TLS_KEY_FILE, or with certificates from Let's Encrypt for ACME_HOSTS
+	serverCfg, err := loadServerConfig()
+	if err != nil {
+		log.Fatalf("Error configuring the server: %v", err)
+	}
+
+	// Create a Gin router, logging every request, recovering from panics and
+	// reporting them and the server errors
+	router := gin.New()
+	router.Use(logRequests(), gin.RecoveryWithWriter(logger), reportErrors())
+	// Without TRUSTED_PROXIES no proxy is trusted, so the client address used
+	// by the rate limits and logs is the address of the connection, which
+	// clients cannot change through X-Forwarded-For
+	if err = router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Error parsing TRUSTED_PROXIES: %v", err)
+	}
+
+	// Trace every request, compress its response and give it an ID, record
+	// its metrics, add the security headers to every response, answer CORS
+	// preflight requests before any other middleware, then cap the size of
+	// request bodies
+	router.Use(traceRequests(), compressResponses(), requestIDs(), recordMetrics(), setSecurityHeaders(), handleCORS(), limitBodies())
+	router.Use(routeReads())
+
+	// Record the changes made by requests in the audit log
+	router.Use(auditMutations())
+
+	// Limit the requests of every client IP, verify request signatures, bearer
+	// tokens and Basic credentials, then require other credentials or an API
+	// key for requests that change data when REQUIRE_API_KEY is set, limit the
+	// requests of every API key and check the role of every request
+	router.Use(rateLimitRequests(false), verifySignatures(), bearerAuth(), basicAuth(), requireAPIKey(), rateLimitRequests(true), authorize())
+
+	// Keep at most one image of multipart forms in memory; the rest of a
+	// form is spooled to temporary files.
+	router.MaxMultipartMemory = int64(maxImageSize)
+
+	// GET /metrics endpoint for Prometheus, only reachable from ADMIN_ALLOWED_CIDRS
+	router.GET("/metrics", allowAdminIPs(), serveMetrics())
 
 	// Health check endpoint for ALB
-	router.GET("/count", func(c *gin.Context) {
+	router.GET("/health", func(c *gin.Context) {
 		// Return 200 OK for load balancer health check
 		c.String(http.StatusOK, "OK")
 	})
 
-	// GET /reset endpoint to truncate the albums table
-	router.GET("/reset", func(c *gin.Context) {
-		// Truncate the albums table to remove all data
-		_, err := db.Exec("TRUNCATE TABLE albums;")
+	// Liveness and readiness probes for Kubernetes and the ALB
+	router.GET("/healthz", livenessProbe)
+	router.GET("/readyz", readinessProbe)
+
+	// GET /version endpoint to return the commit, build time and uptime of the server
+	router.GET("/version", getVersion)
+
+	// GET /count endpoint to return the number of albums and stored image bytes
+	router.GET("/count", func(c *gin.Context) {
+		albums, imageBytes, err := albumRepo.Count(c.Request.Context())
 		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to count albums"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"albums": albums, "imageBytes": imageBytes})
+	})
+
+	// GET /reset endpoint to truncate the albums table, only when ALLOW_DATA_RESET is set and from ADMIN_ALLOWED_CIDRS
+	router.GET("/reset", allowAdminIPs(), func(c *gin.Context) {
+		// Truncate the albums table and its related tables to remove all data
+		err := truncateTables()
+		if errors.Is(err, errDataResetDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"msg": "data reset is disabled; set ALLOW_DATA_RESET to enable it"})
+			return
+		} else if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to truncate table"})
 			return
 		}
+		auditDetail(c, "reset", true)
 		c.JSON(http.StatusOK, gin.H{"msg": "albums table truncated successfully"})
 	})
 
 	// GET /albums/valid endpoint to return any valid albumID from the database.
 	router.GET("/albums/valid", func(c *gin.Context) {
-		var albumID string
-		// Query any one album_id from the table.
-		query := `SELECT album_id FROM albums LIMIT 1`
-		err := db.QueryRow(query).Scan(&albumID)
-		if err == sql.ErrNoRows {
+		albumID, err := albumRepo.AnyID(c.Request.Context())
+		if errors.Is(err, errAlbumNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"msg": "no album found"})
 			return
 		} else if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album id"})
 			return
 		}
@@ -107,62 +502,137 @@ func main() {
 	})
 
 	// POST /albums endpoint to upload image and profile data, and insert them into the database.
-	router.POST("/albums", func(c *gin.Context) {
-		// Retrieve the 'image' file from the multipart/form-data request.
-		fileHeader, err := c.FormFile("image")
+	router.POST("/albums", recordUpload(), optionalUser(), limitUploadSize(1), func(c *gin.Context) {
+		ttl, err := parseTTL(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: image is required"})
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
 			return
 		}
 
-		// Retrieve the 'profile' field as a text string.
-		profileStr := c.PostForm("profile")
-		if profileStr == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: profile is required"})
+		// Read the 'profile' field and stream the optional 'image' file to the image store.
+		profileStr, image, err := readAlbumUpload(c)
+		if err != nil {
+			var uploadErr *uploadError
+			if errors.As(err, &uploadErr) {
+				c.JSON(uploadErr.Status, gin.H{"msg": uploadErr.Msg})
+				return
+			}
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
 			return
 		}
 
 		// Unmarshal the profile JSON string into a Profile struct.
-		var profile Profile
-		if err := json.Unmarshal([]byte(profileStr), &profile); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: profile is not valid JSON"})
-			return
+		discardImage := func() {
+			if image.Key != "" {
+				deleteImages(c.Request.Context(), []string{image.Key})
+			}
 		}
-
-		// Open the image file.
-		file, err := fileHeader.Open()
+		profile, err := parseProfile(profileStr)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to open image file"})
+			discardImage()
+			c.JSON(http.StatusBadRequest, invalidRequest(err))
 			return
 		}
-		defer file.Close()
 
-		// Read the image file content.
-		imageData, err := io.ReadAll(file)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to read image file"})
-			return
+		// Generate a placeholder cover for an album uploaded without an image.
+		if image.Key == "" {
+			if image, err = storePlaceholder(c.Request.Context(), profile); err != nil {
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album image"})
+				return
+			}
 		}
-		imageSize := int64(len(imageData))
 
-		// Generate a unique albumID.
+		// Generate a unique albumID. Queued albums get a version 7 UUID, whose
+		// timestamp bounds how long GET /albums/:albumID/status reports them
+		// pending.
 		albumID := uuid.New().String()
+		if writeQueue != nil {
+			albumID = newQueuedAlbumID()
+		}
 
-		// Insert the new album record into the database without duplicate check.
-		query := `INSERT INTO albums (album_id, image_data, image_size, artist, title, year) VALUES (?, ?, ?, ?, ?, ?)`
-		_, err = db.Exec(query, albumID, imageData, imageSize, profile.Artist, profile.Title, profile.Year)
-		if err != nil {
+		// Queue the new album record when writes are queued, answering before
+		// it is inserted.
+		album := newAlbum{AlbumID: albumID, OwnerID: c.GetString(userIDKey), Image: image, Profile: profile, TTL: ttl}
+		if writeQueue != nil {
+			if err := enqueueAlbum(c.Request.Context(), album, c.GetString(requestIDKey)); err != nil {
+				discardImage()
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to queue album data"})
+				return
+			}
+			auditTarget(c, albumID)
+			c.JSON(http.StatusAccepted, gin.H{
+				"albumID":   albumID,
+				"imageSize": strconv.FormatInt(image.Size, 10),
+				"status":    "pending",
+				"statusURL": "/albums/" + albumID + "/status",
+			})
+			return
+		}
+
+		// Insert the new album record into the database.
+		if err := insertStoredAlbum(c.Request.Context(), album); err != nil {
+			var dup *duplicateImageError
+			if errors.As(err, &dup) {
+				c.JSON(http.StatusConflict, gin.H{"msg": "image already uploaded", "albumID": dup.AlbumID})
+				return
+			}
+			var quotaErr *quotaError
+			if errors.As(err, &quotaErr) {
+				c.JSON(quotaErr.Status, gin.H{"msg": quotaErr.Msg})
+				return
+			}
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album data"})
 			return
 		}
 
-		// Return JSON response with albumID and imageSize.
-		c.JSON(http.StatusOK, gin.H{
+		auditTarget(c, albumID)
+
+		// Return JSON response with albumID and imageSize, and a warning
+		// when albums with a similar cover exist.
+		response := gin.H{
 			"albumID":   albumID,
-			"imageSize": strconv.FormatInt(imageSize, 10),
-		})
+			"imageSize": strconv.FormatInt(image.Size, 10),
+		}
+		if warnSimilarImages {
+			similar, err := similarAlbumIDs(c.Request.Context(), albumID)
+			if err != nil {
+				logFor(c.Request.Context()).Error().Err(err).Str("albumID", albumID).Msg("Error finding similar albums")
+			}
+			if len(similar) > 0 {
+				response["warning"] = "similar images already uploaded"
+				response["similarAlbumIDs"] = similar
+			}
+		}
+		c.JSON(http.StatusOK, response)
 	})
 
+	// POST /albums/batch endpoint to upload many albums in a single request.
+	router.POST("/albums/batch", recordUpload(), optionalUser(), limitUploadSize(maxBatchSize), batchUploadAlbums)
+
+	// Endpoints to upload an image directly to S3 and then register its album.
+	router.POST("/albums/upload-url", createUploadURL)
+	router.POST("/albums/complete", recordUpload(), optionalUser(), completeUpload)
+
+	// GET /albums and GET /albums/search endpoints to list albums with optional filters.
+	router.GET("/albums", listAlbums)
+	router.GET("/albums/search", listAlbums)
+
+	// POST /albums/lookup endpoint to fetch many albums by ID in one round trip.
+	router.POST("/albums/lookup", postLookup)
+
+	// GET /albums/recent endpoint to return the newest albums.
+	router.GET("/albums/recent", recentAlbums)
+
+	// GET /albums/random endpoint to return a random album for discovery.
+	router.GET("/albums/random", randomAlbum)
+
+	// GET /albums/:albumID/status endpoint to check whether an uploaded album is created, failed or still queued.
+	router.GET("/albums/:albumID/status", getAlbumStatus)
+
 	// GET /albums/:albumID endpoint to retrieve album information from the database.
 	router.GET("/albums/:albumID", func(c *gin.Context) {
 		albumID := c.Param("albumID")
@@ -171,27 +641,174 @@ func main() {
 			return
 		}
 
-		// Query the album information from the database.
-		var artist, title, year string
-		query := `SELECT artist, title, year FROM albums WHERE album_id = ?`
-		err := db.QueryRow(query, albumID).Scan(&artist, &title, &year)
-		if err == sql.ErrNoRows {
+		// Get the album information, its rating counters and the storage key
+		// of its primary image.
+		album, err := albumRepo.GetByID(c.Request.Context(), albumID)
+		if errors.Is(err, errAlbumNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"msg": "album not found"})
 			return
 		} else if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album data"})
 			return
 		}
 
-		// Return the album information.
-		c.JSON(http.StatusOK, gin.H{
-			"artist": artist,
-			"title":  title,
-			"year":   year,
-		})
+		// Return the album information, with the track list when requested.
+		// The ETag is sent back in If-Match to change the album, or in
+		// If-None-Match to get a 304 while the album is unchanged.
+		response := gin.H{
+			"artist":  album.Artist,
+			"title":   album.Title,
+			"year":    album.Year,
+			"genre":   album.Genre,
+			"rating":  ratingSummary(album.RatingCount, album.RatingSum),
+			"version": album.Version,
+		}
+		if album.ImageStatus != "" {
+			response["imageStatus"] = album.ImageStatus
+		}
+		if album.ImageKey != "" {
+			response["imageUrl"] = imageURL(album.ImageKey, "/albums/"+albumID+"/image")
+		}
+		if album.ImageWidth > 0 && album.ImageHeight > 0 {
+			response["imageWidth"] = album.ImageWidth
+			response["imageHeight"] = album.ImageHeight
+		}
+		if album.Colors.Dominant != "" {
+			response["colors"] = album.Colors
+		}
+		if c.Query("include") == "tracks" {
+			tracks, err := albumRepo.Tracks(c.Request.Context(), albumID)
+			if err != nil {
+				c.Error(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve tracks"})
+				return
+			}
+			response["tracks"] = tracks
+		}
+		body, err := json.Marshal(response)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album data"})
+			return
+		}
+		etag := albumResponseETag(album.Version, body)
+		if notModified(c, etag) {
+			return
+		}
+		c.Header("ETag", etag)
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
 	})
 
-	// Start the server on port 8080
+	// GET /albums/:albumID/image endpoint to download the stored cover image,
+	// one of its thumbnails with ?size=, a resized copy with ?w=&h=&fit=, or a
+	// converted copy with ?format= or the Accept header. Requests with the
+	// ?expires=&signature= of a signed URL need no credentials.
+	router.GET("/albums/:albumID/image", func(c *gin.Context) {
+		albumID := c.Param("albumID")
+		if albumID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: albumID is required"})
+			return
+		}
+		if !verifyImageURL(c, albumID) {
+			return
+		}
+
+		// Look up the storage key of the primary image.
+		key, err := albumRepo.PrimaryImageKey(c.Request.Context(), albumID)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album image"})
+			return
+		}
+		if key == "" {
+			if requireAlbum(c, albumID) {
+				c.JSON(http.StatusNotFound, gin.H{"msg": "album has no image"})
+			}
+			return
+		}
+		serveImageVariant(c, key)
+	})
+
+	// GET /albums/:albumID/image/url endpoint to create an expiring signed URL of the cover image.
+	router.GET("/albums/:albumID/image/url", createImageURL)
+
+	// PUT /albums/:albumID/image endpoint to replace the stored cover image.
+	router.PUT("/albums/:albumID/image", recordUpload(), requireAlbumOwner(), limitUploadSize(1), replaceAlbumImage)
+
+	// Endpoints to add, list and download the gallery images of an album.
+	router.POST("/albums/:albumID/images", recordUpload(), requireAlbumOwner(), limitUploadSize(1), addAlbumImage)
+	router.GET("/albums/:albumID/images", listAlbumImages)
+	router.GET("/albums/:albumID/images/:imageID", getAlbumImage)
+
+	// GET /albums/:albumID/similar endpoint to find albums with a visually similar cover.
+	router.GET("/albums/:albumID/similar", getSimilarAlbums)
+
+	// POST /review/:likeornot/:albumID endpoint to like or dislike an album.
+	router.POST("/review/:likeornot/:albumID", postReview)
+
+	// GET /albums/:albumID/reviews endpoint to return the like and dislike counts.
+	router.GET("/albums/:albumID/reviews", getReviews)
+
+	// Endpoints to list, attach and detach the tags of an album.
+	router.GET("/albums/:albumID/tags", getTags)
+	router.POST("/albums/:albumID/tags", requireAlbumOwner(), addTags)
+	router.DELETE("/albums/:albumID/tags", requireAlbumOwner(), removeTags)
+
+	// Endpoints to read and replace the track list of an album.
+	router.GET("/albums/:albumID/tracks", getTracks)
+	router.POST("/albums/:albumID/tracks", requireAlbumOwner(), setTracks)
+
+	// Endpoints to create, list and retrieve artists and their albums.
+	router.POST("/artists", createArtist)
+	router.GET("/artists", listArtists)
+	router.GET("/artists/:artistID", getArtist)
+	router.GET("/artists/:artistID/albums", getArtistAlbums)
+
+	// Endpoints to post and list the comments on an album.
+	router.POST("/albums/:albumID/comments", postComment)
+	router.GET("/albums/:albumID/comments", getComments)
+
+	// POST /albums/:albumID/ratings endpoint to submit a 1-5 star rating.
+	router.POST("/albums/:albumID/ratings", postRating)
+
+	// Endpoints to manage collections and the ordered albums they contain.
+	router.POST("/collections", createCollection)
+	router.GET("/collections", listCollections)
+	router.GET("/collections/:collectionID", getCollection)
+	router.POST("/collections/:collectionID/albums", addCollectionAlbum)
+	router.DELETE("/collections/:collectionID/albums/:albumID", removeCollectionAlbum)
+
+	// POST /users endpoint to register a user and issue its token.
+	router.POST("/users", createUser)
+
+	// Endpoints to manage the authenticated user's favorite albums.
+	router.POST("/albums/:albumID/favorite", requireUser(), addFavorite)
+	router.DELETE("/albums/:albumID/favorite", requireUser(), removeFavorite)
+	router.GET("/me/favorites", requireUser(), listFavorites)
+
+	// POST /albums/:albumID/restore endpoint to undo the deletion of an album, protected like the admin endpoints.
+	router.POST("/albums/:albumID/restore", allowAdminIPs(), requireAdmin(), restoreAlbum)
+
+	// Admin endpoints, protected by the X-Admin-Token header and only reachable from ADMIN_ALLOWED_CIDRS.
+	admin := router.Group("/admin", allowAdminIPs(), requireAdmin())
+	admin.GET("/stats", adminStats)
+	admin.GET("/perf", adminPerf)
+	admin.GET("/quota", adminQuota)
+	admin.POST("/expire", adminExpire)
+	admin.GET("/export", adminExport)
+	admin.POST("/import", limitBodySize(maxImportBodySize), adminImport)
+	admin.DELETE("/albums", bulkDeleteAlbums)
+	admin.POST("/purge", adminPurge)
+	admin.GET("/audit", adminAudit)
+	admin.POST("/api-keys", createAPIKey)
+	admin.GET("/api-keys", listAPIKeys)
+	admin.DELETE("/api-keys/:keyID", revokeAPIKey)
+	admin.PUT("/users/:userID/role", setUserRole)
+
+	// Start the server on port 8080, or with TLS when TLS_CERT_FILE or ACME_HOSTS is set
 	// Note: Port 8080 is used to match the ALB target group health check configuration.
-	router.Run(":8080")
+	if err = runServer(serverCfg, router); err != nil {
+		log.Fatalf("Error running server: %v", err)
+	}
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// stripMetadata removes EXIF, XMP, IPTC and text metadata from uploaded JPEG,
+// PNG and WebP images before they are stored. It is set by
+// STRIP_IMAGE_METADATA. The EXIF orientation of a JPEG is kept so images are
+// still displayed upright.
+var stripMetadata = true
+
+// errMalformedImage is returned when an image cannot be parsed while its
+// metadata is stripped.
+var errMalformedImage = errors.New("malformed image")
+
+// stripImageMetadata returns a copy of imageData without metadata, so
+// imageData may be a pooled buffer. Images in other formats, and images that
+// cannot be parsed, are copied unchanged.
+func stripImageMetadata(imageData []byte) []byte {
+	if !stripMetadata {
+		return bytes.Clone(imageData)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(imageData)))
+	source := getBufferedReader(&stripReaders, bytes.NewReader(imageData))
+	defer putBufferedReader(&stripReaders, source)
+	if err := writeStripped(buf, source); err != nil {
+		return bytes.Clone(imageData)
+	}
+	return buf.Bytes()
+}
+
+// stripImageMetadataStream returns a reader of r without metadata. JPEG and
+// PNG images are filtered as they are read; WebP images are buffered because
+// their header holds the total size. A malformed image fails the reader. The
+// caller must close the reader.
+func stripImageMetadataStream(r io.Reader) io.ReadCloser {
+	if !stripMetadata {
+		return io.NopCloser(r)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		source := getBufferedReader(&stripReaders, r)
+		defer putBufferedReader(&stripReaders, source)
+		pw.CloseWithError(writeStripped(pw, source))
+	}()
+	return pr
+}
+
+// writeStripped copies the image from r to w, removing its metadata.
+func writeStripped(w io.Writer, r *bufio.Reader) error {
+	head, _ := r.Peek(12)
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		return stripJPEG(w, r)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNG(w, r)
+	case len(head) == 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return stripWebP(w, r)
+	default:
+		_, err := io.Copy(w, r)
+		return err
+	}
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments
+// before the image data. A minimal EXIF segment holding only the orientation
+// replaces the original one.
+func stripJPEG(w io.Writer, r *bufio.Reader) error {
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return err
+	}
+	if _, err := w.Write(soi); err != nil {
+		return err
+	}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return errMalformedImage
+		}
+		if b != 0xFF {
+			return errMalformedImage
+		}
+		marker, err := r.ReadByte()
+		for err == nil && marker == 0xFF {
+			marker, err = r.ReadByte()
+		}
+		if err != nil {
+			return errMalformedImage
+		}
+
+		// The entropy-coded data and the rest of the file follow SOS.
+		if marker == 0xDA || marker == 0xD9 {
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, r)
+			return err
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return errMalformedImage
+		}
+		payload := int64(length) - 2
+		switch marker {
+		case 0xE1:
+			segment := make([]byte, payload)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return errMalformedImage
+			}
+			if orientation := exifOrientation(segment); orientation > 1 {
+				if _, err := w.Write(orientationSegment(orientation)); err != nil {
+					return err
+				}
+			}
+		case 0xED, 0xFE:
+			if _, err := r.Discard(int(payload)); err != nil {
+				return errMalformedImage
+			}
+		default:
+			if _, err := w.Write([]byte{0xFF, marker, byte(length >> 8), byte(length)}); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, r, payload); err != nil {
+				return errMalformedImage
+			}
+		}
+	}
+}
+
+// exifOrientation returns the orientation tag of an APP1 EXIF segment, or 0
+// when the segment has none.
+func exifOrientation(segment []byte) uint16 {
+	if len(segment) < 14 || string(segment[0:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := segment[6:]
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment returns an APP1 EXIF segment holding only the orientation.
+func orientationSegment(orientation uint16) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // Big-endian header, IFD0 at offset 8
+		0x00, 0x01, // One entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // Orientation, SHORT, count 1
+		byte(orientation >> 8), byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // No next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	return append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+}
+
+// pngMetadataChunks are the PNG chunk types removed by stripPNG.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNG drops the EXIF, text and time chunks of a PNG image.
+func stripPNG(w io.Writer, r *bufio.Reader) error {
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return err
+	}
+	if _, err := w.Write(signature); err != nil {
+		return err
+	}
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errMalformedImage
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		chunkType := string(header[4:8])
+		if pngMetadataChunks[chunkType] {
+			if _, err := r.Discard(int(length) + 4); err != nil {
+				return errMalformedImage
+			}
+			continue
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return errMalformedImage
+		}
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP image and clears their
+// flags in the VP8X header.
+func stripWebP(w io.Writer, r *bufio.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < 12 {
+		return errMalformedImage
+	}
+	out := append([]byte{}, data[0:12]...)
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return errMalformedImage
+		}
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2
+		if end > len(data) {
+			return errMalformedImage
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[pos:end]...)
+			if size > 0 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	_, err = w.Write(out)
+	return err
+}
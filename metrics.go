@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics of the server, served in the Prometheus format by GET /metrics
+// with those of the Go runtime and the process.
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "http_requests_total",
+		Help:      "Requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "albumserver",
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle requests, by method, route and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"method", "route", "status"})
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "albumserver",
+		Name:      "http_requests_in_flight",
+		Help:      "Requests being handled.",
+	})
+	uploadedImages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "uploaded_images_total",
+		Help:      "Images uploaded through the API and stored.",
+	})
+	uploadedImageBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "uploaded_image_bytes_total",
+		Help:      "Bytes of the images uploaded through the API and stored.",
+	})
+	uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "albumserver",
+		Name:      "upload_duration_seconds",
+		Help:      "Time taken by requests uploading images, from their start to the response, by route and outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"route", "outcome"})
+	uploadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "albumserver",
+		Name:      "upload_size_bytes",
+		Help:      "Bytes of the images of requests uploading images, by route and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(16<<10, 4, 9), // 16 KiB to 1 GiB
+	}, []string{"route", "outcome"})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "cache_requests_total",
+		Help:      "Lookups in the album and thumbnail caches, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+	albumWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "queued_album_writes_total",
+		Help:      "Albums sent to WRITE_QUEUE and handled by its consumers, by outcome: queued, created, retried or failed.",
+	}, []string{"outcome"})
+	albumBatches = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "albumserver",
+		Name:      "album_batch_size",
+		Help:      "Albums inserted together by the batches of DB_BATCH_WINDOW.",
+		Buckets:   []float64{2, 4, 8, 16, 32, 64, 128, 256},
+	})
+	imageJobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "image_jobs_total",
+		Help:      "Albums whose images were processed by the image workers, by outcome: ready or failed.",
+	}, []string{"outcome"})
+	slowQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "db_slow_queries_total",
+		Help:      "Database calls that took longer than DB_SLOW_QUERY_THRESHOLD.",
+	})
+)
+
+// registerDBMetrics exports the connection pool statistics of the database,
+// and of each read replica, by db_name, along with the counters of
+// the retried database calls.
+func registerDBMetrics() {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "primary"))
+	for i, r := range replicas {
+		prometheus.MustRegister(collectors.NewDBStatsCollector(r.db, "replica"+strconv.Itoa(i)))
+	}
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "db_retries_total",
+		Help:      "Database calls retried after a transient error.",
+	}, func() float64 { return float64(dbRetries.retried.Load()) }))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: "albumserver",
+		Name:      "db_retries_exhausted_total",
+		Help:      "Database calls that still failed after every retry.",
+	}, func() float64 { return float64(dbRetries.exhausted.Load()) }))
+}
+
+// recordMetrics is a middleware that counts the requests in flight and
+// records the count and the duration of every request, for Prometheus,
+// GET /admin/perf and CloudWatch when it is enabled. Requests that match
+// no route are recorded with the route "unmatched", so unknown paths do not
+// create new series.
+func recordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		took := time.Since(start)
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(took.Seconds())
+		recordPerf(c.Request.Method+" "+route, c.Writer.Status(), took)
+		if cloudWatch != nil {
+			cloudWatch.observe(c.Request.Method+" "+route, c.Writer.Status(), took)
+		}
+	}
+}
+
+// uploadBytesKey is the request context key of the count of image bytes
+// received by an upload request.
+type uploadBytesKey struct{}
+
+// recordUpload is a middleware for the routes uploading images that records
+// their duration and the size of their images by outcome: stored, too_large,
+// rejected or failed. The size is that of the images stored or checked by
+// the request, or the declared length of bodies refused as too large.
+func recordUpload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		size := new(atomic.Int64)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), uploadBytesKey{}, size))
+		c.Next()
+
+		outcome := "failed"
+		switch status := c.Writer.Status(); {
+		case status < 400:
+			outcome = "stored"
+		case status == 413:
+			outcome = "too_large"
+		case status < 500:
+			outcome = "rejected"
+		}
+		route := c.FullPath()
+		uploadDuration.WithLabelValues(route, outcome).Observe(time.Since(start).Seconds())
+		n := size.Load()
+		if n == 0 && outcome == "too_large" {
+			n = c.Request.ContentLength
+		}
+		if n > 0 {
+			uploadSize.WithLabelValues(route, outcome).Observe(float64(n))
+		}
+	}
+}
+
+// addUploadSize adds n image bytes to the size recorded by recordUpload for
+// the request of ctx, if any.
+func addUploadSize(ctx context.Context, n int64) {
+	if size, ok := ctx.Value(uploadBytesKey{}).(*atomic.Int64); ok {
+		size.Add(n)
+	}
+}
+
+// serveMetrics handles GET /metrics.
+func serveMetrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	migratepgx "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// migrationFiles holds the versioned schema migrations, one directory per
+// dialect. Schema changes are added as new numbered files next to the others;
+// applied files are never edited.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// schemaVersionTable records the version of the last applied migration.
+const schemaVersionTable = "schema_version"
+
+// baselineVersion is the migration that creates the schema as it was before
+// versioned migrations were introduced.
+const baselineVersion = 1
+
+// migrationSource returns the migrations of the open database's dialect.
+func migrationSource() (source.Driver, error) {
+	return iofs.New(migrationFiles, "migrations/"+string(dialect))
+}
+
+// newMigrate opens a separate connection pool to the database at dsn for
+// applying migrations, since migrations are multi-statement files and closing
+// the migrator closes its pool.
+func newMigrate(driverName, dsn string) (*migrate.Migrate, error) {
+	if dialect == mysqlDialect {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MultiStatements = true
+		dsn = cfg.FormatDSN()
+	}
+	migrationDB, err := openDB(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	var driver database.Driver
+	switch dialect {
+	case postgresDialect:
+		driver, err = migratepgx.WithInstance(migrationDB, &migratepgx.Config{MigrationsTable: schemaVersionTable})
+	case sqliteDialect:
+		driver, err = migratesqlite.WithInstance(migrationDB, &migratesqlite.Config{MigrationsTable: schemaVersionTable})
+	default:
+		driver, err = migratemysql.WithInstance(migrationDB, &migratemysql.Config{MigrationsTable: schemaVersionTable})
+	}
+	if err != nil {
+		migrationDB.Close()
+		return nil, err
+	}
+	src, err := migrationSource()
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	return migrate.NewWithInstance("iofs", src, string(dialect), driver)
+}
+
+// latestSchemaVersion returns the version of the newest embedded migration.
+func latestSchemaVersion() (uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		} else if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// migrateSchema applies the pending migrations. A database created before
+// versioned migrations is first upgraded by upgradeLegacySchema and marked as
+// being at baselineVersion.
+func migrateSchema(m *migrate.Migrate) error {
+	_, _, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		legacy, err := columnExists("albums", "album_id")
+		if err != nil {
+			return err
+		}
+		if legacy {
+			if err := upgradeLegacySchema(); err != nil {
+				return err
+			}
+			if err := m.Force(baselineVersion); err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// checkSchemaVersion returns an error unless every embedded migration has been
+// applied cleanly. A database migrated by a newer server is accepted, so
+// instances can be replaced one by one after the migrations ran.
+func checkSchemaVersion(m *migrate.Migrate) error {
+	latest, err := latestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return errors.New("database has no schema version; run the server with the migrate command")
+	} else if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("migration %d failed and left the schema dirty; repair it and run migrate force %d", version, version)
+	}
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d but the server needs %d; run the server with the migrate command", version, latest)
+	}
+	if version > latest {
+		logger.Warn().Uint("version", version).Uint("latest", latest).Msg("Database schema version is newer than this server's")
+	}
+	return nil
+}
+
+// runMigrateCommand handles "migrate [up|down|version|force N]" and exits.
+// down rolls back the last migration only.
+func runMigrateCommand(m *migrate.Migrate, args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	var err error
+	switch command {
+	case "up":
+		err = migrateSchema(m)
+	case "down":
+		err = m.Steps(-1)
+	case "force":
+		var version int
+		if len(args) != 2 {
+			err = errors.New("usage: migrate force VERSION")
+		} else if _, err = fmt.Sscan(args[1], &version); err == nil {
+			err = m.Force(version)
+		}
+	case "version":
+	default:
+		err = fmt.Errorf("unknown migrate command %q", command)
+	}
+	if err != nil {
+		log.Fatalf("Error migrating schema: %v", err)
+	}
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		logger.Info().Msg("Database has no schema version")
+	} else if err != nil {
+		log.Fatalf("Error reading schema version: %v", err)
+	} else {
+		logger.Info().Uint("version", version).Bool("dirty", dirty).Msg("Database schema version")
+	}
+	m.Close()
+	os.Exit(0)
+}
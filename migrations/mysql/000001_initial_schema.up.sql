@@ -0,0 +1,139 @@
+CREATE TABLE artists (
+    artist_id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE albums (
+    album_id VARCHAR(255) PRIMARY KEY,
+    image_size INT NOT NULL,
+    image_hash CHAR(64) NOT NULL DEFAULT '',
+    image_width INT NOT NULL DEFAULT 0,
+    image_height INT NOT NULL DEFAULT 0,
+    image_phash BIGINT NULL,
+    dominant_color CHAR(7) NOT NULL DEFAULT '',
+    average_color CHAR(7) NOT NULL DEFAULT '',
+    artist VARCHAR(255) NOT NULL,
+    artist_id VARCHAR(255) NULL,
+    owner_id VARCHAR(255) NULL,
+    title VARCHAR(255) NOT NULL,
+    year VARCHAR(4) NOT NULL,
+    genre VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NULL,
+    CONSTRAINT fk_albums_artist FOREIGN KEY (artist_id) REFERENCES artists (artist_id)
+);
+
+CREATE TABLE album_images (
+    image_id VARCHAR(255) PRIMARY KEY,
+    album_id VARCHAR(255) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    image_size INT NOT NULL,
+    image_hash CHAR(64) NOT NULL,
+    content_type VARCHAR(64) NOT NULL DEFAULT '',
+    width INT NOT NULL DEFAULT 0,
+    height INT NOT NULL DEFAULT 0,
+    label VARCHAR(64) NOT NULL DEFAULT '',
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_album_images_album (album_id, is_primary)
+);
+
+CREATE TABLE image_blobs (
+    blob_key VARCHAR(255) PRIMARY KEY,
+    data LONGBLOB NOT NULL
+);
+
+CREATE TABLE image_objects (
+    storage_key VARCHAR(255) PRIMARY KEY,
+    image_hash CHAR(64) NOT NULL UNIQUE,
+    ref_count INT NOT NULL
+);
+
+CREATE TABLE image_variants (
+    source_key VARCHAR(255) NOT NULL,
+    variant VARCHAR(64) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    image_size INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (source_key, variant)
+);
+
+CREATE TABLE reviews (
+    album_id VARCHAR(255) PRIMARY KEY,
+    likes INT NOT NULL DEFAULT 0,
+    dislikes INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE tags (
+    tag_id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE
+);
+
+CREATE TABLE album_tags (
+    album_id VARCHAR(255) NOT NULL,
+    tag_id INT NOT NULL,
+    PRIMARY KEY (album_id, tag_id),
+    INDEX idx_album_tags_tag (tag_id)
+);
+
+CREATE TABLE tracks (
+    album_id VARCHAR(255) NOT NULL,
+    position INT NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    duration INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (album_id, position)
+);
+
+CREATE TABLE comments (
+    comment_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    album_id VARCHAR(255) NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_comments_album (album_id, created_at)
+);
+
+CREATE TABLE ratings (
+    album_id VARCHAR(255) PRIMARY KEY,
+    rating_count BIGINT NOT NULL DEFAULT 0,
+    rating_sum BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE collections (
+    collection_id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE collection_albums (
+    collection_id VARCHAR(255) NOT NULL,
+    album_id VARCHAR(255) NOT NULL,
+    position INT NOT NULL,
+    PRIMARY KEY (collection_id, album_id),
+    INDEX idx_collection_albums_position (collection_id, position)
+);
+
+CREATE TABLE users (
+    user_id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE favorites (
+    user_id VARCHAR(255) NOT NULL,
+    album_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, album_id)
+);
+
+CREATE INDEX idx_albums_genre ON albums (genre);
+CREATE INDEX idx_albums_created ON albums (created_at, album_id);
+CREATE INDEX idx_albums_image_hash ON albums (image_hash);
+CREATE INDEX idx_albums_artist ON albums (artist, album_id);
+CREATE INDEX idx_albums_title ON albums (title, album_id);
+CREATE INDEX idx_albums_year ON albums (year, album_id);
+CREATE INDEX idx_albums_artist_id ON albums (artist_id, created_at);
+CREATE INDEX idx_albums_expires ON albums (expires_at);
+CREATE INDEX idx_albums_owner ON albums (owner_id);
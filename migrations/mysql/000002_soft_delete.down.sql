@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_deleted ON albums;
+ALTER TABLE albums DROP COLUMN deleted_at;
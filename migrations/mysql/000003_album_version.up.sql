@@ -0,0 +1 @@
+ALTER TABLE albums ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+CREATE TABLE audit_log (
+    audit_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    request_id VARCHAR(255) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    client_ip VARCHAR(64) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    status INT NOT NULL,
+    details TEXT NOT NULL,
+    INDEX idx_audit_created (created_at, audit_id),
+    INDEX idx_audit_actor (actor, created_at),
+    INDEX idx_audit_target (target, created_at)
+);
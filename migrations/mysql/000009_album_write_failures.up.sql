@@ -0,0 +1,6 @@
+CREATE TABLE album_write_failures (
+    album_id VARCHAR(255) PRIMARY KEY,
+    status INT NOT NULL,
+    msg VARCHAR(1024) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
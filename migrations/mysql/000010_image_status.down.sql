@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_image_status ON albums;
+ALTER TABLE albums DROP COLUMN image_status;
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS favorites;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS collection_albums;
+DROP TABLE IF EXISTS collections;
+DROP TABLE IF EXISTS ratings;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS tracks;
+DROP TABLE IF EXISTS album_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS reviews;
+DROP TABLE IF EXISTS image_variants;
+DROP TABLE IF EXISTS image_objects;
+DROP TABLE IF EXISTS image_blobs;
+DROP TABLE IF EXISTS album_images;
+DROP TABLE IF EXISTS albums;
+DROP TABLE IF EXISTS artists;
@@ -0,0 +1,6 @@
+ALTER TABLE albums ADD COLUMN text_year VARCHAR(4) NOT NULL DEFAULT '';
+UPDATE albums SET text_year = CAST(year AS CHAR(4)) WHERE year <> 0;
+DROP INDEX idx_albums_year;
+ALTER TABLE albums DROP COLUMN year;
+ALTER TABLE albums RENAME COLUMN text_year TO year;
+CREATE INDEX idx_albums_year ON albums (year, album_id);
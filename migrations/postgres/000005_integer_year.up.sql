@@ -0,0 +1,6 @@
+ALTER TABLE albums ADD COLUMN release_year SMALLINT NOT NULL DEFAULT 0;
+UPDATE albums SET release_year = CAST(year AS INTEGER) WHERE year ~ '^[0-9]{4}$';
+DROP INDEX idx_albums_year;
+ALTER TABLE albums DROP COLUMN year;
+ALTER TABLE albums RENAME COLUMN release_year TO year;
+CREATE INDEX idx_albums_year ON albums (year, album_id);
@@ -0,0 +1,7 @@
+CREATE TABLE api_keys (
+    key_id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP NULL
+);
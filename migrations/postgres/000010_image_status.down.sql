@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_image_status;
+ALTER TABLE albums DROP COLUMN image_status;
@@ -0,0 +1,2 @@
+ALTER TABLE albums ADD COLUMN image_status VARCHAR(16) NOT NULL DEFAULT 'ready';
+CREATE INDEX idx_albums_image_status ON albums (image_status, created_at);
@@ -0,0 +1,6 @@
+CREATE TABLE image_chunks (
+    blob_key VARCHAR(255) NOT NULL,
+    seq INT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (blob_key, seq)
+);
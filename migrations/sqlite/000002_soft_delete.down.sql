@@ -0,0 +1,2 @@
+DROP INDEX idx_albums_deleted;
+ALTER TABLE albums DROP COLUMN deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE albums ADD COLUMN deleted_at TIMESTAMP NULL;
+CREATE INDEX idx_albums_deleted ON albums (deleted_at);
@@ -0,0 +1,15 @@
+CREATE TABLE audit_log (
+    audit_id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    request_id VARCHAR(255) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    client_ip VARCHAR(64) NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    target VARCHAR(255) NOT NULL,
+    status INT NOT NULL,
+    details TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_created ON audit_log (created_at, audit_id);
+CREATE INDEX idx_audit_actor ON audit_log (actor, created_at);
+CREATE INDEX idx_audit_target ON audit_log (target, created_at);
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const oidcDiscoveryTimeout = 10 * time.Second // Timeout of the OpenID Connect discovery request
+
+// oidcConfiguration holds the fields used from an issuer's
+// /.well-known/openid-configuration document.
+type oidcConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// newOIDCVerifier discovers the JWKS of an OpenID Connect issuer, such as a
+// Cognito user pool, a Keycloak realm or an Auth0 tenant, and returns a
+// verifier for its ID and access tokens. Tokens must be issued by the issuer
+// for audience, which is matched against the aud claim or, for Cognito access
+// tokens, the client_id claim. The subjects of valid tokens are mapped to users.
+func newOIDCVerifier(ctx context.Context, issuer, audience, rolesClaim, nameClaim string) (*jwtVerifier, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("an audience (client ID) is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	var config oidcConfiguration
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", url, err)
+	}
+	// The discovery document must name the issuer it was fetched from, so
+	// tokens of another issuer cannot be accepted.
+	if strings.TrimSuffix(config.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", config.Issuer, issuer)
+	}
+	if config.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %q has no jwks_uri", issuer)
+	}
+	return &jwtVerifier{
+		jwks:       &jwksCache{url: config.JWKSURI},
+		issuer:     config.Issuer,
+		audience:   audience,
+		rolesClaim: rolesClaim,
+		nameClaim:  nameClaim,
+		mapUsers:   true,
+	}, nil
+}
+
+// oidcUser returns the user of a token's issuer and subject, creating the
+// user on their first request. The user is named after the
+// nameClaim, falling back to the email and the subject, and a suffix derived
+// from the subject makes the name unique when it is taken.
+func (v *jwtVerifier) oidcUser(ctx context.Context, claims jwt.MapClaims) (userAccount, error) {
+	issuer, _ := claims.GetIssuer()
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return userAccount{}, fmt.Errorf("token has no subject")
+	}
+	lookup := func() (userAccount, error) {
+		ctx, cancel := withQueryTimeout(ctx)
+		defer cancel()
+		var user userAccount
+		query := `SELECT u.user_id, u.role FROM user_identities i JOIN users u ON u.user_id = i.user_id
+			WHERE i.issuer = ? AND i.subject = ?`
+		err := db.QueryRowContext(ctx, query, issuer, subject).Scan(&user.UserID, &user.Role)
+		return user, err
+	}
+	user, err := withRetry(ctx, lookup)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	name := subject
+	for _, claim := range []string{v.nameClaim, "email"} {
+		if value, _ := claims[claim].(string); strings.TrimSpace(value) != "" {
+			name = strings.TrimSpace(value)
+			break
+		}
+	}
+	sum := sha256.Sum256([]byte(issuer + "\x00" + subject))
+	suffix := "#" + hex.EncodeToString(sum[:4])
+	if n := 255 - len(suffix); len(name) > n {
+		// Cut at the start of a character, not within one.
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	userID, err := withRetry(ctx, func() (string, error) { return createOIDCUser(ctx, issuer, subject, name, suffix) })
+	if err != nil {
+		// The first requests of a user may race to create it; the losers
+		// fail on the primary key of user_identities and use the winner's.
+		if existing, lookupErr := lookup(); lookupErr == nil {
+			return existing, nil
+		}
+		return userAccount{}, err
+	}
+	return userAccount{UserID: userID, Role: roleWriter}, nil
+}
+
+// createOIDCUser creates a user named name, or name with suffix when the name
+// is taken, and links it to the issuer and subject of its tokens.
+func createOIDCUser(ctx context.Context, issuer, subject, name, suffix string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	// The token hash is random: users signing in through OpenID Connect
+	// have no X-User-Token.
+	_, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	userID := uuid.New().String()
+	query := dialect.insertIgnore(`INSERT INTO users (user_id, name, token_hash) VALUES (?, ?, ?)`)
+	result, err := tx.ExecContext(ctx, query, userID, name, hash)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		query := `INSERT INTO users (user_id, name, token_hash) VALUES (?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, userID, name+suffix, hash); err != nil {
+			return "", err
+		}
+	}
+	query = `INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, issuer, subject, userID); err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
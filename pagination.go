@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sortColumns maps the accepted sort parameter values to album columns.
+var sortColumns = map[string]string{
+	"artist":     "artist",
+	"title":      "title",
+	"year":       "year",
+	"created_at": "created_at",
+}
+
+// albumCursor is the position after which the next page of albums starts. It
+// is handed to clients as an opaque base64 token.
+type albumCursor struct {
+	Sort    string `json:"s"`
+	Order   string `json:"o"`
+	Value   string `json:"v"`
+	AlbumID string `json:"id"`
+}
+
+// encode returns the opaque token for the cursor.
+func (cur albumCursor) encode() string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a token produced by albumCursor.encode.
+func decodeCursor(token string) (albumCursor, error) {
+	var cur albumCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(data, &cur) != nil || cur.AlbumID == "" {
+		return cur, errors.New("cursor is not valid")
+	}
+	return cur, nil
+}
+
+// albumPage describes which page of an album listing is requested. Pages are
+// selected by offset, or by keyset when a cursor from a previous page is given.
+type albumPage struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Order  string
+	After  *albumCursor
+}
+
+// parseAlbumPage reads the limit, offset, cursor, sort and order query
+// parameters. Albums are sorted newest first by default and album_id breaks ties.
+func parseAlbumPage(c *gin.Context) (albumPage, error) {
+	var page albumPage
+	var err error
+	if page.Limit, page.Offset, err = parsePagination(c); err != nil {
+		return page, err
+	}
+	page.Sort = c.DefaultQuery("sort", "created_at")
+	if _, ok := sortColumns[page.Sort]; !ok {
+		return page, errors.New("sort must be one of artist, title, year, created_at")
+	}
+	page.Order = strings.ToUpper(c.DefaultQuery("order", "desc"))
+	if page.Order != "ASC" && page.Order != "DESC" {
+		return page, errors.New("order must be 'asc' or 'desc'")
+	}
+	if token := c.Query("cursor"); token != "" {
+		if page.Offset != 0 {
+			return page, errors.New("cursor and offset cannot be combined")
+		}
+		cur, err := decodeCursor(token)
+		if err != nil {
+			return page, err
+		}
+		if cur.Sort != page.Sort || cur.Order != page.Order {
+			return page, errors.New("cursor was issued for a different sort order")
+		}
+		page.After = &cur
+	}
+	return page, nil
+}
+
+// clause appends the keyset condition to conds and returns the WHERE, ORDER
+// BY and LIMIT clauses with their arguments.
+func (p albumPage) clause(conds []string, args []any) (string, []any) {
+	column := sortColumns[p.Sort]
+	cmp := "<"
+	if p.Order == "ASC" {
+		cmp = ">"
+	}
+	if p.After != nil {
+		var value any = p.After.Value
+		if p.Sort == "year" {
+			// Compare with a number, as years are stored in an integer column.
+			value, _ = strconv.Atoi(p.After.Value)
+		}
+		conds = append(conds, "("+column+" "+cmp+" ? OR ("+column+" = ? AND album_id "+cmp+" ?))")
+		args = append(args, value, value, p.After.AlbumID)
+	}
+
+	var clause string
+	if len(conds) > 0 {
+		clause = " WHERE " + strings.Join(conds, " AND ")
+	}
+	clause += " ORDER BY " + column + " " + p.Order + ", album_id " + p.Order
+	if p.After != nil {
+		return clause + " LIMIT ?", append(args, p.Limit)
+	}
+	return clause + " LIMIT ? OFFSET ?", append(args, p.Limit, p.Offset)
+}
+
+// nextCursor returns the cursor of the page after albums, or "" when albums
+// is the last page.
+func (p albumPage) nextCursor(albums []Album) string {
+	if len(albums) < p.Limit {
+		return ""
+	}
+	last := albums[len(albums)-1]
+	cur := albumCursor{Sort: p.Sort, Order: p.Order, AlbumID: last.AlbumID}
+	switch p.Sort {
+	case "artist":
+		cur.Value = last.Artist
+	case "title":
+		cur.Value = last.Title
+	case "year":
+		cur.Value = strconv.Itoa(int(last.Year))
+	case "created_at":
+		cur.Value = last.CreatedAt.Format(time.DateTime)
+	}
+	return cur.encode()
+}
+
+// response returns the JSON body for a page of albums.
+func (p albumPage) response(albums []Album) gin.H {
+	response := gin.H{
+		"albums":     albums,
+		"limit":      p.Limit,
+		"nextCursor": p.nextCursor(albums),
+	}
+	if p.After == nil {
+		response["offset"] = p.Offset
+	}
+	return response
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The latencies of every route are counted in histograms over perfSlots
+// slots of perfSlotSeconds each, so GET /admin/perf summarizes the last
+// minute. Histogram bin i holds the latencies up to perfMinMs*perfGrowth^i,
+// which bounds the error of the percentiles to 5%.
+const (
+	perfSlots       = 6
+	perfSlotSeconds = 10
+	perfBins        = 400
+	perfMinMs       = 0.01
+	perfGrowth      = 1.05
+)
+
+// perfWindow is the period summarized by GET /admin/perf.
+const perfWindow = perfSlots * perfSlotSeconds * time.Second
+
+// routePerf holds the recent requests of a route.
+type routePerf struct {
+	mu    sync.Mutex
+	slots [perfSlots]perfSlot
+}
+
+// perfSlot holds the requests of a route during perfSlotSeconds.
+type perfSlot struct {
+	second int64 // Unix time the slot starts at
+	count  int64
+	errors int64
+	maxMs  float64
+	bins   [perfBins]uint32
+}
+
+// routePerfs maps "METHOD route" to the *routePerf of the route.
+var routePerfs sync.Map
+
+// recordPerf adds a request to route answered with status after took.
+func recordPerf(route string, status int, took time.Duration) {
+	p, ok := routePerfs.Load(route)
+	if !ok {
+		p, _ = routePerfs.LoadOrStore(route, &routePerf{})
+	}
+	perf := p.(*routePerf)
+	ms := float64(took.Microseconds()) / 1000
+	bin := 0
+	if ms > perfMinMs {
+		bin = min(int(math.Log(ms/perfMinMs)/math.Log(perfGrowth))+1, perfBins-1)
+	}
+	second := time.Now().Unix() / perfSlotSeconds * perfSlotSeconds
+
+	perf.mu.Lock()
+	defer perf.mu.Unlock()
+	slot := &perf.slots[second/perfSlotSeconds%perfSlots]
+	if slot.second != second {
+		*slot = perfSlot{second: second}
+	}
+	slot.count++
+	if status >= 500 {
+		slot.errors++
+	}
+	slot.maxMs = max(slot.maxMs, ms)
+	slot.bins[bin]++
+}
+
+// routeSummary is the summary of a route in GET /admin/perf.
+type routeSummary struct {
+	Route      string  `json:"route"`
+	Requests   int64   `json:"requests"`
+	Throughput float64 `json:"throughput"` // Requests per second
+	Errors     int64   `json:"errors"`
+	P50Ms      float64 `json:"p50Ms"`
+	P95Ms      float64 `json:"p95Ms"`
+	P99Ms      float64 `json:"p99Ms"`
+	MaxMs      float64 `json:"maxMs"`
+}
+
+// summarize merges the slots of the last perfWindow.
+func (p *routePerf) summarize(now time.Time) (routeSummary, bool) {
+	oldest := now.Unix() - int64(perfWindow/time.Second)
+	var summary routeSummary
+	var bins [perfBins]uint32
+	earliest := now.Unix()
+	p.mu.Lock()
+	for i := range p.slots {
+		slot := &p.slots[i]
+		if slot.count == 0 || slot.second <= oldest {
+			continue
+		}
+		earliest = min(earliest, slot.second)
+		summary.Requests += slot.count
+		summary.Errors += slot.errors
+		summary.MaxMs = max(summary.MaxMs, slot.maxMs)
+		for j, n := range slot.bins {
+			bins[j] += n
+		}
+	}
+	p.mu.Unlock()
+	if summary.Requests == 0 {
+		return summary, false
+	}
+	// The oldest slot may predate the start of the process.
+	since := time.Unix(earliest, 0)
+	if startTime.After(since) {
+		since = startTime
+	}
+	summary.Throughput = math.Round(float64(summary.Requests)/now.Sub(since).Seconds()*100) / 100
+	summary.P50Ms = percentile(&bins, summary.Requests, 0.50, summary.MaxMs)
+	summary.P95Ms = percentile(&bins, summary.Requests, 0.95, summary.MaxMs)
+	summary.P99Ms = percentile(&bins, summary.Requests, 0.99, summary.MaxMs)
+	return summary, true
+}
+
+// percentile returns the upper bound of the bin holding the q quantile of
+// count latencies, or max when it is lower.
+func percentile(bins *[perfBins]uint32, count int64, q, max float64) float64 {
+	rank := int64(math.Ceil(q * float64(count)))
+	var seen int64
+	for i, n := range bins {
+		if seen += int64(n); seen >= rank {
+			return math.Min(math.Round(perfMinMs*math.Pow(perfGrowth, float64(i))*1000)/1000, max)
+		}
+	}
+	return max
+}
+
+// adminPerf handles GET /admin/perf and returns the throughput, the server
+// errors and the p50, p95 and p99 latencies of every route over the last
+// minute, measured by this process.
+func adminPerf(c *gin.Context) {
+	now := time.Now()
+	routes := []routeSummary{}
+	routePerfs.Range(func(route, p any) bool {
+		if summary, ok := p.(*routePerf).summarize(now); ok {
+			summary.Route = route.(string)
+			routes = append(routes, summary)
+		}
+		return true
+	})
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+	c.JSON(http.StatusOK, gin.H{"window": perfWindow.String(), "routes": routes})
+}
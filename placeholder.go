@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// placeholderImageLabel is the label of the cover generated for an album
+// uploaded without an image.
+const placeholderImageLabel = "placeholder"
+
+// placeholderSize is the width and height of generated covers.
+const placeholderSize = 600
+
+// placeholderColors are the background colors of generated covers.
+var placeholderColors = []color.RGBA{
+	{0x1f, 0x77, 0xb4, 0xff}, {0xff, 0x7f, 0x0e, 0xff}, {0x2c, 0xa0, 0x2c, 0xff},
+	{0xd6, 0x27, 0x28, 0xff}, {0x94, 0x67, 0xbd, 0xff}, {0x8c, 0x56, 0x4b, 0xff},
+	{0xe3, 0x77, 0xc2, 0xff}, {0x7f, 0x7f, 0x7f, 0xff}, {0xbc, 0xbd, 0x22, 0xff},
+	{0x17, 0xbe, 0xcf, 0xff},
+}
+
+// placeholderFont is the typeface of the initials on generated covers.
+var placeholderFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(gobold.TTF)
+})
+
+// initial returns the first letter or digit of s in upper case, or "?".
+func initial(s string) string {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return string(unicode.ToUpper(r))
+		}
+	}
+	return "?"
+}
+
+// makePlaceholder renders a PNG cover with the initials of the artist and
+// title in white on a background color picked from both, so the same profile
+// always gets the same cover.
+func makePlaceholder(profile Profile) ([]byte, error) {
+	sum := fnv.New32a()
+	sum.Write([]byte(strings.ToLower(profile.Artist) + "\x00" + strings.ToLower(profile.Title)))
+	background := placeholderColors[sum.Sum32()%uint32(len(placeholderColors))]
+
+	dst := image.NewRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	typeface, err := placeholderFont()
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(typeface, &opentype.FaceOptions{Size: placeholderSize / 3, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	// Center the initials horizontally and their cap height vertically.
+	text := initial(profile.Artist) + initial(profile.Title)
+	drawer := &font.Drawer{Dst: dst, Src: image.White, Face: face}
+	metrics := face.Metrics()
+	width := drawer.MeasureString(text)
+	drawer.Dot = fixed.Point26_6{
+		X: (fixed.I(placeholderSize) - width) / 2,
+		Y: (fixed.I(placeholderSize) + metrics.CapHeight) / 2,
+	}
+	drawer.DrawString(text)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// storePlaceholder generates the cover of an album uploaded without an image
+// and writes it to the image store.
+func storePlaceholder(ctx context.Context, profile Profile) (storedImage, error) {
+	imageData, err := makePlaceholder(profile)
+	if err != nil {
+		return storedImage{}, err
+	}
+	image, err := storeImage(ctx, imageData)
+	image.Placeholder = true
+	return image, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// Storage quotas set by QUOTA_MAX_ALBUMS and QUOTA_MAX_BYTES for all albums,
+// and by QUOTA_USER_MAX_ALBUMS and QUOTA_USER_MAX_BYTES for the albums owned
+// by each user. Zero disables a limit. Usage is checked before each write
+// without locking, so concurrent uploads can overshoot a limit by the size of
+// the uploads in flight.
+var (
+	quotaMaxAlbums     int
+	quotaMaxBytes      int
+	quotaUserMaxAlbums int
+	quotaUserMaxBytes  int
+)
+
+// quotaError is returned by checkQuota when a write would exceed a quota,
+// with the HTTP status to respond with.
+type quotaError struct {
+	Status int
+	Msg    string
+}
+
+func (e *quotaError) Error() string {
+	return e.Msg
+}
+
+// storageUsage returns the number of albums and the total size of their images.
+func storageUsage(ctx context.Context) (albums, imageBytes int64, err error) {
+	return albumRepo.Count(ctx)
+}
+
+// userStorageUsage returns the number of albums owned by a user and the total
+// size of their images.
+func userStorageUsage(ctx context.Context, userID string) (albums, imageBytes int64, err error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT (SELECT COUNT(*) FROM albums WHERE owner_id = ? AND deleted_at IS NULL),
+		(SELECT COALESCE(SUM(i.image_size), 0) FROM album_images i JOIN albums a ON a.album_id = i.album_id WHERE a.owner_id = ?)`
+	err = db.QueryRowContext(ctx, query, userID, userID).Scan(&albums, &imageBytes)
+	return albums, imageBytes, err
+}
+
+// checkQuota returns a quotaError when adding newAlbums albums and newBytes
+// image bytes would exceed the global quotas or, for albums owned by ownerID,
+// the per-user quotas. An empty ownerID only checks the global quotas.
+func checkQuota(ctx context.Context, ownerID string, newAlbums int, newBytes int64) error {
+	if quotaMaxAlbums > 0 || quotaMaxBytes > 0 {
+		albums, imageBytes, err := storageUsage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := exceedsQuota("", albums+int64(newAlbums), imageBytes+newBytes, newAlbums, newBytes, quotaMaxAlbums, quotaMaxBytes); err != nil {
+			return err
+		}
+	}
+	if ownerID != "" && (quotaUserMaxAlbums > 0 || quotaUserMaxBytes > 0) {
+		albums, imageBytes, err := userStorageUsage(ctx, ownerID)
+		if err != nil {
+			return err
+		}
+		return exceedsQuota("user ", albums+int64(newAlbums), imageBytes+newBytes, newAlbums, newBytes, quotaUserMaxAlbums, quotaUserMaxBytes)
+	}
+	return nil
+}
+
+// exceedsQuota returns a quotaError when a write adding newAlbums albums and
+// newBytes bytes brings the usage to albums and imageBytes over a limit.
+func exceedsQuota(scope string, albums, imageBytes int64, newAlbums int, newBytes int64, maxAlbums, maxBytes int) error {
+	if maxAlbums > 0 && newAlbums > 0 && albums > int64(maxAlbums) {
+		return &quotaError{http.StatusTooManyRequests, scope + "album quota of " + strconv.Itoa(maxAlbums) + " albums exceeded"}
+	}
+	if maxBytes > 0 && newBytes > 0 && imageBytes > int64(maxBytes) {
+		return &quotaError{http.StatusRequestEntityTooLarge, scope + "storage quota of " + strconv.Itoa(maxBytes) + " bytes exceeded"}
+	}
+	return nil
+}
+
+// respondQuota writes the response for an error from checkQuota and reports
+// whether the request may continue.
+func respondQuota(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		c.JSON(quotaErr.Status, gin.H{"msg": quotaErr.Msg})
+	} else {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute usage"})
+	}
+	return false
+}
+
+// userUsage is the storage used by the albums a user owns.
+type userUsage struct {
+	UserID     string `json:"userID"`
+	Name       string `json:"name"`
+	Albums     int64  `json:"albums"`
+	ImageBytes int64  `json:"imageBytes"`
+}
+
+// adminQuota handles GET /admin/quota and reports the storage quotas, the
+// current usage and, paginated with limit and offset, the usage of each user
+// ordered by name. A limit of 0 means unlimited.
+func adminQuota(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	albums, imageBytes, err := storageUsage(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute usage"})
+		return
+	}
+
+	query := `SELECT u.user_id, u.name,
+		(SELECT COUNT(*) FROM albums a WHERE a.owner_id = u.user_id AND a.deleted_at IS NULL),
+		(SELECT COALESCE(SUM(i.image_size), 0) FROM album_images i JOIN albums a ON a.album_id = i.album_id WHERE a.owner_id = u.user_id)
+		FROM users u ORDER BY u.name LIMIT ? OFFSET ?`
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	rows, err := readDB(ctx).QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute usage"})
+		return
+	}
+	defer rows.Close()
+	users := []userUsage{}
+	for rows.Next() {
+		var usage userUsage
+		if err := rows.Scan(&usage.UserID, &usage.Name, &usage.Albums, &usage.ImageBytes); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute usage"})
+			return
+		}
+		users = append(users, usage)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"albums":     gin.H{"used": albums, "limit": quotaMaxAlbums},
+		"imageBytes": gin.H{"used": imageBytes, "limit": quotaMaxBytes},
+		"perUser":    gin.H{"albums": quotaUserMaxAlbums, "imageBytes": quotaUserMaxBytes},
+		"users":      users,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
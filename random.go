@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// randomAlbum handles GET /albums/random and returns a random album, with
+// the URL of its cover when include=image_url is given.
+func randomAlbum(c *gin.Context) {
+	album, err := albumRepo.Random(c.Request.Context())
+	if errors.Is(err, errAlbumNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no album found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve random album"})
+		return
+	}
+
+	response := gin.H{"album": album}
+	if c.Query("include") == "image_url" {
+		key, err := albumRepo.PrimaryImageKey(c.Request.Context(), album.AlbumID)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve random album"})
+			return
+		}
+		if key != "" {
+			response["imageUrl"] = imageURL(key, "/albums/"+album.AlbumID+"/image")
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimit is the rate of requests, in requests per second, and the burst of a
+// token bucket. A zero rate disables the limit.
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Requests are limited per client IP, set by RATE_LIMIT_IP and
+// RATE_LIMIT_IP_BURST, and per API key, set by RATE_LIMIT_API_KEY and
+// RATE_LIMIT_API_KEY_BURST.
+var (
+	ipRateLimit     rateLimit
+	apiKeyRateLimit rateLimit
+)
+
+// parseRateLimit reads a rate limit from the environment variable name, in
+// requests per second, and its burst from name_BURST, which defaults to one
+// second of requests.
+func parseRateLimit(name string) (rateLimit, error) {
+	limit := rateLimit{Rate: getEnvFloat(name, 0)}
+	if limit.Rate < 0 || math.IsNaN(limit.Rate) || math.IsInf(limit.Rate, 0) {
+		return limit, fmt.Errorf("%s must be a non-negative number of requests per second", name)
+	}
+	limit.Burst = getEnvInt(name+"_BURST", max(1, int(math.Ceil(limit.Rate))))
+	if limit.Burst < 1 {
+		return limit, fmt.Errorf("%s_BURST must be at least 1, got %d", name, limit.Burst)
+	}
+	return limit, nil
+}
+
+// rateLimiter is the store of the token buckets: in memory for a single
+// instance, or in Redis (RATE_LIMIT_REDIS_URL) for buckets shared by every
+// instance behind the load balancer.
+var rateLimiter tokenBuckets = newMemoryBuckets()
+
+// tokenBuckets takes a token from the bucket named key, which refills at
+// limit.Rate up to limit.Burst tokens. When the bucket is empty, it returns
+// false with the time until the next token.
+type tokenBuckets interface {
+	take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error)
+}
+
+// rateLimitRequests is a middleware that limits the requests of every client
+// IP, or of every API key when byAPIKey is set, responding 429 with a
+// Retry-After header to requests over the limit. Health probes are never
+// limited, and requests are let through when the bucket store fails.
+func rateLimitRequests(byAPIKey bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, key := ipRateLimit, "ip:"+c.ClientIP()
+		if byAPIKey {
+			limit, key = apiKeyRateLimit, "apikey:"+c.GetString(apiKeyIDKey)
+		}
+		if limit.Rate <= 0 || publicRoutes[c.FullPath()] || byAPIKey && c.GetString(apiKeyIDKey) == "" {
+			c.Next()
+			return
+		}
+		ok, wait, err := rateLimiter.take(c.Request.Context(), key, limit)
+		if err != nil {
+			logFor(c.Request.Context()).Error().Err(err).Str("bucket", key).Msg("Error checking the rate limit")
+		} else if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"msg": "too many requests"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// bucketIdleTime is how long a bucket is kept after its last request. A bucket
+// idle for long enough to refill is the same as a new one.
+const bucketIdleTime = 10 * time.Minute
+
+// memoryBuckets keeps buckets in memory and forgets those idle for
+// bucketIdleTime.
+type memoryBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newMemoryBuckets() *memoryBuckets {
+	return &memoryBuckets{buckets: map[string]*memoryBucket{}, swept: time.Now()}
+}
+
+func (m *memoryBuckets) take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.swept) > bucketIdleTime {
+		for k, b := range m.buckets {
+			if now.Sub(b.last) > bucketIdleTime {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// redisBuckets keeps buckets in Redis hashes that expire when idle. A script
+// refills and takes from a bucket atomically using the time of the Redis
+// server, so the clocks of the instances do not matter.
+type redisBuckets struct {
+	client *redis.Client
+}
+
+// takeScript refills the bucket KEYS[1] at ARGV[1] tokens per second up to
+// ARGV[2] tokens and takes one. It returns 1 or, when the bucket is empty, 0
+// and the milliseconds until the next token.
+var takeScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1e6
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(bucket[1]) or burst, tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + (now - last) * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens, allowed = tokens - 1, 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], tonumber(ARGV[3]))
+return {allowed, wait}
+`)
+
+// newRedisBuckets connects to the Redis server at url, such as
+// redis://host:6379/0.
+func newRedisBuckets(url string) (*redisBuckets, error) {
+	client, err := connectRedis(url)
+	if err != nil {
+		return nil, err
+	}
+	return &redisBuckets{client: client}, nil
+}
+
+// connectRedis connects to the Redis server at url and checks that it
+// answers.
+func connectRedis(url string) (*redis.Client, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *redisBuckets) take(ctx context.Context, key string, limit rateLimit) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	result, err := takeScript.Run(ctx, r.client, []string{"ratelimit:" + key},
+		limit.Rate, limit.Burst, bucketIdleTime.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryBuckets(t *testing.T) {
+	buckets := newMemoryBuckets()
+	limit := rateLimit{Rate: 1, Burst: 2}
+	for i, want := range []bool{true, true, false} {
+		ok, wait, err := buckets.take(context.Background(), "ip:1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Fatalf("request %d: allowed = %v, want %v", i+1, ok, want)
+		}
+		if !ok && (wait <= 0 || wait > 1e9) {
+			t.Errorf("request %d: wait = %v, want up to a second", i+1, wait)
+		}
+	}
+	if ok, _, _ := buckets.take(context.Background(), "ip:2", limit); !ok {
+		t.Error("another client was limited")
+	}
+}
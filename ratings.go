@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ratingRequest is the JSON body accepted by POST /albums/:albumID/ratings.
+type ratingRequest struct {
+	Stars int `json:"stars" binding:"min=1,max=5"`
+}
+
+// ratingSummary returns the JSON representation of an album's rating counters.
+func ratingSummary(count, sum int64) gin.H {
+	average := 0.0
+	if count > 0 {
+		average = float64(sum) / float64(count)
+	}
+	return gin.H{"average": average, "count": count}
+}
+
+// postRating handles POST /albums/:albumID/ratings and records a 1-5 star
+// rating. The count and sum are maintained as counters so reads stay cheap.
+func postRating(c *gin.Context) {
+	albumID := c.Param("albumID")
+	var req ratingRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	// Increment the counters atomically in the database.
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `INSERT INTO ratings (album_id, rating_count, rating_sum) VALUES (?, 1, ?) ` +
+		dialect.onConflictUpdate("album_id") + ` rating_count = ratings.rating_count + 1,
+		rating_sum = ratings.rating_sum + ` + dialect.inserted("rating_sum")
+	if _, err := db.ExecContext(ctx, query, albumID, req.Stars); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist rating"})
+		return
+	}
+	invalidateAlbum(ctx, albumID)
+
+	var count, sum int64
+	query = `SELECT rating_count, rating_sum FROM ratings WHERE album_id = ?`
+	if err := db.QueryRowContext(ctx, query, albumID).Scan(&count, &sum); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve rating"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"albumID": albumID, "rating": ratingSummary(count, sum)})
+}
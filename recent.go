@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// recentAlbums handles GET /albums/recent and returns the most recently
+// created albums. The optional window parameter (e.g. 24h) restricts the feed
+// to albums created within that duration.
+func recentAlbums(c *gin.Context) {
+	limit, _, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	var window time.Duration
+	if v := c.Query("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: window must be a positive duration such as 24h"})
+			return
+		}
+	}
+
+	albums, err := albumRepo.Recent(c.Request.Context(), window, limit)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve recent albums"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albums": albums, "limit": limit})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// replica is a read-only copy of the database and whether its last health
+// check succeeded.
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// replicas are the databases opened from DB_READ_DSNS. nextReplica picks them
+// in turn.
+var (
+	replicas    []*replica
+	nextReplica atomic.Uint64
+)
+
+// replicaCheckInterval is how often each replica is pinged, set by
+// REPLICA_CHECK_INTERVAL. A replica that fails a check gets no reads until a
+// later check succeeds.
+var replicaCheckInterval = 5 * time.Second
+
+// readOnlyKey marks the context of a request whose reads may go to a replica.
+type readOnlyKey struct{}
+
+// openReplicas opens a pool for each replica DSN, checks their health and
+// keeps checking it in the background.
+func openReplicas(driverName string, dsns []string) error {
+	for _, dsn := range dsns {
+		pool, err := openDB(driverName, dsn)
+		if err != nil {
+			return err
+		}
+		configurePool(pool)
+		// Replicas start out healthy so the first check logs those that are down.
+		r := &replica{db: pool}
+		r.healthy.Store(true)
+		replicas = append(replicas, r)
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	replicaCheckInterval = getEnvDuration("REPLICA_CHECK_INTERVAL", replicaCheckInterval)
+	if replicaCheckInterval <= 0 {
+		return fmt.Errorf("REPLICA_CHECK_INTERVAL must be positive, got %v", replicaCheckInterval)
+	}
+	checkReplicas()
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkReplicas()
+		}
+	}()
+	return nil
+}
+
+// checkReplicas pings every replica and logs the ones whose health changed.
+func checkReplicas() {
+	for i, r := range replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+		err := r.db.PingContext(ctx)
+		cancel()
+		if healthy := err == nil; r.healthy.Swap(healthy) != healthy {
+			if healthy {
+				logger.Info().Int("replica", i).Msg("Read replica is up")
+			} else {
+				logger.Error().Err(err).Int("replica", i).Msg("Read replica is down")
+			}
+		}
+	}
+}
+
+// routeReads is a middleware that lets the reads of GET and HEAD requests go
+// to the replicas. Other requests read from the primary so they see their own
+// writes.
+func routeReads() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), readOnlyKey{}, true))
+		}
+		c.Next()
+	}
+}
+
+// readDB returns the database to read from in ctx: the next healthy replica in
+// round-robin order for GET and HEAD requests, or the primary database when
+// the request writes or no replica is healthy.
+func readDB(ctx context.Context) *sql.DB {
+	if ctx.Value(readOnlyKey{}) == nil {
+		return db
+	}
+	for range replicas {
+		r := replicas[nextReplica.Add(1)%uint64(len(replicas))]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+	return db
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errAlbumNotFound is returned by an AlbumRepository when no album has the ID.
+var errAlbumNotFound = errors.New("album not found")
+
+// AlbumRepository persists album records. Handlers read and write albums
+// through it instead of querying the database, so they can run against a fake
+// and the records can be kept in another backend.
+type AlbumRepository interface {
+	// Create inserts an album whose primary image is already in the image
+	// store, together with its artist record and expiry. Either all of the
+	// rows are written or none of them.
+	Create(ctx context.Context, album newAlbum) error
+	// GetByID returns the album with its detail, or errAlbumNotFound.
+	GetByID(ctx context.Context, albumID string) (albumDetail, error)
+	// List returns one page of the albums matching the filter.
+	List(ctx context.Context, filter albumFilter, page albumPage) ([]Album, error)
+	// Lookup returns the albums with the given IDs that exist, in any order.
+	Lookup(ctx context.Context, albumIDs []string) ([]Album, error)
+	// Random returns a random album, or errAlbumNotFound when there is none.
+	Random(ctx context.Context) (Album, error)
+	// AnyID returns the ID of some album, or errAlbumNotFound when there is none.
+	AnyID(ctx context.Context) (string, error)
+	// Recent returns up to limit albums, newest first. A positive window
+	// restricts them to the albums created within it.
+	Recent(ctx context.Context, window time.Duration, limit int) ([]Album, error)
+	// Exists reports whether an album with the ID exists.
+	Exists(ctx context.Context, albumID string) (bool, error)
+	// Owner returns the ID of the user owning an album, empty for albums
+	// uploaded anonymously, or errAlbumNotFound.
+	Owner(ctx context.Context, albumID string) (string, error)
+	// PrimaryImageKey returns the storage key of the primary image of an
+	// album, or an empty key when the album has none or does not exist.
+	PrimaryImageKey(ctx context.Context, albumID string) (string, error)
+	// FindByImageHash returns the ID of an album other than exceptAlbumID
+	// whose primary image has the hash, or an empty ID when there is none.
+	FindByImageHash(ctx context.Context, hash, exceptAlbumID string) (string, error)
+	// Tags returns the tag names of an album in alphabetical order.
+	Tags(ctx context.Context, albumID string) ([]string, error)
+	// AddTags attaches tags to an album, creating the tags that do not exist.
+	AddTags(ctx context.Context, albumID string, tags []string) error
+	// RemoveTags detaches tags from an album.
+	RemoveTags(ctx context.Context, albumID string, tags []string) error
+	// Tracks returns the track list of an album ordered by position.
+	Tracks(ctx context.Context, albumID string) ([]Track, error)
+	// SetTracks replaces the track list of an album.
+	SetTracks(ctx context.Context, albumID string, tracks []Track) error
+	// CollectionAlbums returns the albums of a collection in the order they
+	// were added.
+	CollectionAlbums(ctx context.Context, collectionID string) ([]Album, error)
+	// Favorites returns one page of the favorite albums of a user, most
+	// recently added first.
+	Favorites(ctx context.Context, userID string, limit, offset int) ([]Album, error)
+	// Delete marks the albums as deleted together and reports for each
+	// albumID whether it existed and was not deleted yet. Deleted albums are
+	// left out of all reads until they are restored or purged.
+	Delete(ctx context.Context, albumIDs []string) ([]bool, error)
+	// Restore undoes the deletion of an album and reports whether it was
+	// deleted.
+	Restore(ctx context.Context, albumID string) (bool, error)
+	// Deleted returns the IDs of up to limit albums deleted at least olderThan
+	// ago.
+	Deleted(ctx context.Context, olderThan time.Duration, limit int) ([]string, error)
+	// Expired returns the IDs of up to limit albums past their expiry and,
+	// when maxAge is positive, of those created more than maxAge ago.
+	Expired(ctx context.Context, maxAge time.Duration, limit int) ([]string, error)
+	// Purge permanently removes the albums together, deleted or not, and
+	// reports for each albumID whether it existed. Images no other album
+	// refers to are removed from the image store afterwards.
+	Purge(ctx context.Context, albumIDs []string) ([]bool, error)
+	// Count returns the number of albums that are not deleted and the total
+	// size of all stored images, including those of deleted albums.
+	Count(ctx context.Context) (albums, imageBytes int64, err error)
+}
+
+// albumRepo is the AlbumRepository used by the handlers.
+var albumRepo AlbumRepository = retryingAlbumRepository{sqlAlbumRepository{}}
+
+// newAlbum is an album to be created by AlbumRepository.Create.
+type newAlbum struct {
+	AlbumID string
+	OwnerID string      // Empty for an album without an owning user
+	Image   storedImage // Primary image, already written to the image store
+	Profile Profile
+	TTL     time.Duration // Time until the album expires, zero when permanent
+}
+
+// albumDetail is an album with its rating counters, cover colors and the
+// storage key of its primary image, as shown by GET /albums/:albumID.
+type albumDetail struct {
+	Album
+	Version     int64 // Incremented by every change, served as the ETag
+	RatingCount int64
+	RatingSum   int64
+	Colors      coverColors
+	ImageStatus string // Progress of the image workers: pending, processing, ready or failed
+	ImageKey    string // Empty when the album has no primary image
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+// sqlAlbumRepository keeps album records in the albums table and its child
+// tables of the database opened by openDB. Reads go to the database picked by
+// readDB, so those of GET requests are served by the read replicas.
+type sqlAlbumRepository struct{}
+
+// Create inserts the artist, the album, its primary image metadata and its
+// expiry in one transaction, which is rolled back when any of them fails.
+func (sqlAlbumRepository) Create(ctx context.Context, album newAlbum) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	profile, image := album.Profile, album.Image
+	artistID, err := ensureArtistTx(ctx, tx, profile.Artist)
+	if err != nil {
+		return err
+	}
+	insert, err := prepared(ctx, db, `INSERT INTO albums (album_id, image_size, image_hash, image_width, image_height, artist, artist_id, owner_id, title, year, genre, image_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending')`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.StmtContext(ctx, insert).ExecContext(ctx, album.AlbumID, image.Size, image.Hash, image.Width, image.Height, profile.Artist,
+		artistID, sql.NullString{String: album.OwnerID, Valid: album.OwnerID != ""},
+		profile.Title, profile.Year, profile.Genre)
+	if err != nil {
+		return err
+	}
+	_, unused, err := insertImageTx(ctx, tx, album.AlbumID, image, image.label(), true)
+	if err != nil {
+		return err
+	}
+	if err := setAlbumExpiryTx(ctx, tx, album.AlbumID, album.TTL); err != nil {
+		return err
+	}
+	if err := commitTx(tx); err != nil {
+		return err
+	}
+	deleteImages(ctx, unused)
+	return nil
+}
+
+// CreateBatch inserts albums like Create, all of them in one transaction with
+// a multi-row insert per table, so a batch takes a few round trips to the
+// database whatever its size. Either every album is created or none is.
+func (sqlAlbumRepository) CreateBatch(ctx context.Context, albums []newAlbum) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	artistIDs, err := ensureArtistsTx(ctx, tx, albums)
+	if err != nil {
+		return err
+	}
+	objectKeys, err := acquireImagesTx(ctx, tx, albums)
+	if err != nil {
+		return err
+	}
+
+	var albumRows, imageRows []string
+	var albumArgs, imageArgs []any
+	var unused []string
+	for _, album := range albums {
+		profile, image := album.Profile, album.Image
+		albumRows = append(albumRows, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending')")
+		albumArgs = append(albumArgs, album.AlbumID, image.Size, image.Hash, image.Width, image.Height, profile.Artist,
+			artistIDs[profile.Artist], sql.NullString{String: album.OwnerID, Valid: album.OwnerID != ""},
+			profile.Title, profile.Year, profile.Genre)
+		objectKey := image.Key
+		if image.Hash != "" {
+			objectKey = objectKeys[image.Hash]
+		}
+		if objectKey != image.Key {
+			unused = append(unused, image.Key)
+		}
+		imageRows = append(imageRows, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		imageArgs = append(imageArgs, uuid.New().String(), album.AlbumID, objectKey, image.Size, image.Hash, image.ContentType,
+			image.Width, image.Height, image.label(), true)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO albums (album_id, image_size, image_hash, image_width, image_height, artist, artist_id, owner_id, title, year, genre, image_status)
+		VALUES `+strings.Join(albumRows, ", "), albumArgs...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO album_images (image_id, album_id, storage_key, image_size, image_hash, content_type, width, height, label, is_primary)
+		VALUES `+strings.Join(imageRows, ", "), imageArgs...)
+	if err != nil {
+		return err
+	}
+	for _, album := range albums {
+		if err := setAlbumExpiryTx(ctx, tx, album.AlbumID, album.TTL); err != nil {
+			return err
+		}
+	}
+	if err := commitTx(tx); err != nil {
+		return err
+	}
+	deleteImages(ctx, unused)
+	return nil
+}
+
+// GetByID reads the album, its rating counters and the storage key of its
+// primary image in one prepared query.
+func (sqlAlbumRepository) GetByID(ctx context.Context, albumID string) (albumDetail, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var album albumDetail
+	var imageKey sql.NullString
+	stmt, err := prepared(ctx, readDB(ctx), `SELECT a.album_id, a.artist, a.title, a.year, a.genre, a.created_at, a.image_width, a.image_height,
+		a.version, COALESCE(r.rating_count, 0), COALESCE(r.rating_sum, 0), a.dominant_color, a.average_color, a.image_status, i.storage_key
+		FROM albums a LEFT JOIN ratings r ON r.album_id = a.album_id
+		LEFT JOIN album_images i ON i.album_id = a.album_id AND i.is_primary WHERE a.album_id = ? AND a.deleted_at IS NULL`)
+	if err != nil {
+		return album, err
+	}
+	err = stmt.QueryRowContext(ctx, albumID).Scan(&album.AlbumID, &album.Artist, &album.Title, &album.Year, &album.Genre,
+		&album.CreatedAt, &album.ImageWidth, &album.ImageHeight, &album.Version, &album.RatingCount, &album.RatingSum,
+		&album.Colors.Dominant, &album.Colors.Average, &album.ImageStatus, &imageKey)
+	if err == sql.ErrNoRows {
+		return album, errAlbumNotFound
+	}
+	album.ImageKey = imageKey.String
+	return album, err
+}
+
+func (sqlAlbumRepository) List(ctx context.Context, filter albumFilter, page albumPage) ([]Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	conds, args := filter.conditions()
+	clause, args := page.clause(append(conds, "deleted_at IS NULL"), args)
+	rows, err := readDB(ctx).QueryContext(ctx, `SELECT `+albumColumns+` FROM albums`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlbums(rows)
+}
+
+func (sqlAlbumRepository) Lookup(ctx context.Context, albumIDs []string) ([]Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(albumIDs)), ",")
+	args := make([]any, len(albumIDs))
+	for i, albumID := range albumIDs {
+		args[i] = albumID
+	}
+	rows, err := readDB(ctx).QueryContext(ctx, `SELECT `+albumColumns+` FROM albums WHERE album_id IN (`+placeholders+`) AND deleted_at IS NULL`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlbums(rows)
+}
+
+// Random picks the first album at or after a freshly generated UUID. AlbumIDs
+// are random UUIDs, so this is a uniformly distributed pick that only needs a
+// primary key range scan instead of ORDER BY RAND() over the whole table.
+func (sqlAlbumRepository) Random(ctx context.Context) (Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + albumColumns + ` FROM albums WHERE album_id >= ? AND deleted_at IS NULL ORDER BY album_id LIMIT 1`
+	rows, err := readDB(ctx).QueryContext(ctx, query, uuid.New().String())
+	if err != nil {
+		return Album{}, err
+	}
+	albums, err := scanAlbums(rows)
+	if err == nil && len(albums) == 0 {
+		// Wrap around to the first album when the pivot is past the last one.
+		rows, err = readDB(ctx).QueryContext(ctx, `SELECT `+albumColumns+` FROM albums WHERE deleted_at IS NULL ORDER BY album_id LIMIT 1`)
+		if err == nil {
+			albums, err = scanAlbums(rows)
+		}
+	}
+	if err != nil {
+		return Album{}, err
+	}
+	if len(albums) == 0 {
+		return Album{}, errAlbumNotFound
+	}
+	return albums[0], nil
+}
+
+func (sqlAlbumRepository) AnyID(ctx context.Context) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var albumID string
+	err := readDB(ctx).QueryRowContext(ctx, `SELECT album_id FROM albums WHERE deleted_at IS NULL LIMIT 1`).Scan(&albumID)
+	if err == sql.ErrNoRows {
+		return "", errAlbumNotFound
+	}
+	return albumID, err
+}
+
+func (sqlAlbumRepository) Recent(ctx context.Context, window time.Duration, limit int) ([]Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + albumColumns + ` FROM albums WHERE deleted_at IS NULL`
+	var args []any
+	if window > 0 {
+		query += ` AND created_at >= ` + dialect.secondsFromNow()
+		args = append(args, -int64(window.Seconds()))
+	}
+	query += ` ORDER BY created_at DESC, album_id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := readDB(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlbums(rows)
+}
+
+func (sqlAlbumRepository) Exists(ctx context.Context, albumID string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	stmt, err := prepared(ctx, readDB(ctx), `SELECT 1 FROM albums WHERE album_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return false, err
+	}
+	var one int
+	err = stmt.QueryRowContext(ctx, albumID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (sqlAlbumRepository) Owner(ctx context.Context, albumID string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	stmt, err := prepared(ctx, readDB(ctx), `SELECT owner_id FROM albums WHERE album_id = ? AND deleted_at IS NULL`)
+	if err != nil {
+		return "", err
+	}
+	var ownerID sql.NullString
+	err = stmt.QueryRowContext(ctx, albumID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return "", errAlbumNotFound
+	}
+	return ownerID.String, err
+}
+
+func (sqlAlbumRepository) PrimaryImageKey(ctx context.Context, albumID string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	stmt, err := prepared(ctx, readDB(ctx), `SELECT i.storage_key FROM album_images i JOIN albums a ON a.album_id = i.album_id
+		WHERE i.album_id = ? AND i.is_primary AND a.deleted_at IS NULL`)
+	if err != nil {
+		return "", err
+	}
+	var key string
+	err = stmt.QueryRowContext(ctx, albumID).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return key, err
+}
+
+func (sqlAlbumRepository) FindByImageHash(ctx context.Context, hash, exceptAlbumID string) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var albumID string
+	query := `SELECT album_id FROM albums WHERE image_hash = ? AND album_id <> ? AND deleted_at IS NULL LIMIT 1`
+	err := readDB(ctx).QueryRowContext(ctx, query, hash, exceptAlbumID).Scan(&albumID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return albumID, err
+}
+
+func (sqlAlbumRepository) Tags(ctx context.Context, albumID string) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT t.name FROM album_tags at JOIN tags t ON t.tag_id = at.tag_id
+		WHERE at.album_id = ? ORDER BY t.name`
+	rows, err := readDB(ctx).QueryContext(ctx, query, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (sqlAlbumRepository) AddTags(ctx context.Context, albumID string, tags []string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	for _, tag := range tags {
+		// Create the tag if needed, then link it to the album.
+		if _, err := db.ExecContext(ctx, dialect.insertIgnore(`INSERT INTO tags (name) VALUES (?)`), tag); err != nil {
+			return err
+		}
+		query := `INSERT INTO album_tags (album_id, tag_id) SELECT ?, tag_id FROM tags WHERE name = ?`
+		if _, err := db.ExecContext(ctx, dialect.insertIgnore(query), albumID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sqlAlbumRepository) RemoveTags(ctx context.Context, albumID string, tags []string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(tags)), ",")
+	query := `DELETE FROM album_tags
+		WHERE album_id = ? AND tag_id IN (SELECT tag_id FROM tags WHERE name IN (` + placeholders + `))`
+	args := []any{albumID}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (sqlAlbumRepository) Tracks(ctx context.Context, albumID string) ([]Track, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT position, title, duration FROM tracks WHERE album_id = ? ORDER BY position`
+	rows, err := readDB(ctx).QueryContext(ctx, query, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []Track{}
+	for rows.Next() {
+		var track Track
+		if err := rows.Scan(&track.Position, &track.Title, &track.Duration); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// SetTracks replaces the whole track list in one transaction.
+func (sqlAlbumRepository) SetTracks(ctx context.Context, albumID string, tracks []Track) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tracks WHERE album_id = ?`, albumID); err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		query := `INSERT INTO tracks (album_id, position, title, duration) VALUES (?, ?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, albumID, track.Position, track.Title, track.Duration); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sqlAlbumRepository) CollectionAlbums(ctx context.Context, collectionID string) ([]Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + qualifiedAlbumColumns("a") + ` FROM collection_albums ca
+		JOIN albums a ON a.album_id = ca.album_id WHERE ca.collection_id = ? AND a.deleted_at IS NULL ORDER BY ca.position`
+	rows, err := readDB(ctx).QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlbums(rows)
+}
+
+func (sqlAlbumRepository) Favorites(ctx context.Context, userID string, limit, offset int) ([]Album, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT ` + qualifiedAlbumColumns("a") + ` FROM favorites f
+		JOIN albums a ON a.album_id = f.album_id WHERE f.user_id = ? AND a.deleted_at IS NULL
+		ORDER BY f.created_at DESC, f.album_id LIMIT ? OFFSET ?`
+	rows, err := readDB(ctx).QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlbums(rows)
+}
+
+// Delete sets deleted_at of the albums in a single transaction. Their rows
+// and images are kept until they are purged.
+func (sqlAlbumRepository) Delete(ctx context.Context, albumIDs []string) ([]bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `UPDATE albums SET deleted_at = ` + dialect.now() + ` WHERE album_id = ? AND deleted_at IS NULL`
+	found := make([]bool, len(albumIDs))
+	for i, albumID := range albumIDs {
+		result, err := tx.ExecContext(ctx, query, albumID)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := result.RowsAffected()
+		found[i] = n > 0
+	}
+	return found, tx.Commit()
+}
+
+func (sqlAlbumRepository) Restore(ctx context.Context, albumID string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE albums SET deleted_at = NULL WHERE album_id = ? AND deleted_at IS NOT NULL`, albumID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (sqlAlbumRepository) Deleted(ctx context.Context, olderThan time.Duration, limit int) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT album_id FROM albums WHERE deleted_at <= ` + dialect.secondsFromNow() + ` ORDER BY deleted_at LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, -int64(olderThan/time.Second), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var albumIDs []string
+	for rows.Next() {
+		var albumID string
+		if err := rows.Scan(&albumID); err != nil {
+			return nil, err
+		}
+		albumIDs = append(albumIDs, albumID)
+	}
+	return albumIDs, rows.Err()
+}
+
+func (sqlAlbumRepository) Expired(ctx context.Context, maxAge time.Duration, limit int) ([]string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT album_id FROM albums WHERE expires_at <= ` + dialect.now()
+	var args []any
+	if maxAge > 0 {
+		query += ` OR created_at < ` + dialect.secondsFromNow()
+		args = append(args, -int64(maxAge/time.Second))
+	}
+	rows, err := db.QueryContext(ctx, query+` LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var albumIDs []string
+	for rows.Next() {
+		var albumID string
+		if err := rows.Scan(&albumID); err != nil {
+			return nil, err
+		}
+		albumIDs = append(albumIDs, albumID)
+	}
+	return albumIDs, rows.Err()
+}
+
+// Purge deletes the albums in a single transaction with deleteAlbumTx.
+func (sqlAlbumRepository) Purge(ctx context.Context, albumIDs []string) ([]bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	found := make([]bool, len(albumIDs))
+	var keys []string
+	for i, albumID := range albumIDs {
+		existed, albumKeys, err := deleteAlbumTx(ctx, tx, albumID)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = existed
+		keys = append(keys, albumKeys...)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	deleteImages(ctx, keys)
+	return found, nil
+}
+
+// Count sums the sizes of all album images, primary and gallery images alike,
+// since the images of deleted albums stay stored until they are purged.
+func (sqlAlbumRepository) Count(ctx context.Context) (albums, imageBytes int64, err error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `SELECT (SELECT COUNT(*) FROM albums WHERE deleted_at IS NULL), (SELECT COALESCE(SUM(image_size), 0) FROM album_images)`
+	err = readDB(ctx).QueryRowContext(ctx, query).Scan(&albums, &imageBytes)
+	return albums, imageBytes, err
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memAlbum is an album kept by memAlbumRepository.
+type memAlbum struct {
+	Album
+	OwnerID   string
+	Image     storedImage
+	Tags      []string
+	Tracks    []Track
+	ExpiresAt time.Time // Zero when the album is permanent
+}
+
+// memAlbumRepository is an in-memory AlbumRepository for handler tests. List
+// applies the filters but always sorts newest first; the other sort orders
+// and cursors are left to the SQL repository.
+type memAlbumRepository struct {
+	mu          sync.Mutex
+	albums      map[string]*memAlbum
+	deleted     map[string]*memAlbum // Soft deleted albums, left out of the reads
+	deletedAt   map[string]time.Time
+	collections map[string][]string // Album IDs of a collection in order
+	favorites   map[string][]string // Album IDs of a user, newest first
+}
+
+func newMemAlbumRepository() *memAlbumRepository {
+	return &memAlbumRepository{
+		albums:      map[string]*memAlbum{},
+		deleted:     map[string]*memAlbum{},
+		deletedAt:   map[string]time.Time{},
+		collections: map[string][]string{},
+		favorites:   map[string][]string{},
+	}
+}
+
+// useMemAlbumRepository makes the handlers use a new memAlbumRepository for
+// the rest of the test.
+func useMemAlbumRepository(t *testing.T) *memAlbumRepository {
+	repo := newMemAlbumRepository()
+	previous := albumRepo
+	albumRepo = repo
+	t.Cleanup(func() { albumRepo = previous })
+	return repo
+}
+
+func (r *memAlbumRepository) Create(ctx context.Context, album newAlbum) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.albums[album.AlbumID] = &memAlbum{
+		Album: Album{AlbumID: album.AlbumID, Profile: album.Profile, CreatedAt: time.Now().UTC(),
+			ImageWidth: album.Image.Width, ImageHeight: album.Image.Height},
+		OwnerID: album.OwnerID,
+		Image:   album.Image,
+	}
+	if album.TTL > 0 {
+		r.albums[album.AlbumID].ExpiresAt = time.Now().Add(album.TTL)
+	}
+	return nil
+}
+
+func (r *memAlbumRepository) GetByID(ctx context.Context, albumID string) (albumDetail, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	album, ok := r.albums[albumID]
+	if !ok {
+		return albumDetail{}, errAlbumNotFound
+	}
+	return albumDetail{Album: album.Album, ImageKey: album.Image.Key}, nil
+}
+
+// sorted returns the albums for which keep returns true, newest first.
+func (r *memAlbumRepository) sorted(keep func(*memAlbum) bool) []Album {
+	albums := []Album{}
+	for _, album := range r.albums {
+		if keep(album) {
+			albums = append(albums, album.Album)
+		}
+	}
+	slices.SortFunc(albums, func(a, b Album) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.AlbumID, a.AlbumID)
+	})
+	return albums
+}
+
+// paginate returns the page of albums at offset.
+func paginate(albums []Album, limit, offset int) []Album {
+	albums = albums[min(offset, len(albums)):]
+	return albums[:min(limit, len(albums))]
+}
+
+func (r *memAlbumRepository) List(ctx context.Context, filter albumFilter, page albumPage) ([]Album, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	contains := func(s, substr string) bool { return strings.Contains(strings.ToLower(s), strings.ToLower(substr)) }
+	albums := r.sorted(func(album *memAlbum) bool {
+		return contains(album.Artist, filter.Artist) && contains(album.Title, filter.Title) &&
+			(filter.Year == 0 || album.Year == filter.Year) &&
+			(filter.Genre == "" || album.Genre == filter.Genre) &&
+			(filter.Tag == "" || slices.Contains(album.Tags, filter.Tag))
+	})
+	return paginate(albums, page.Limit, page.Offset), nil
+}
+
+func (r *memAlbumRepository) Lookup(ctx context.Context, albumIDs []string) ([]Album, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	albums := []Album{}
+	for _, albumID := range albumIDs {
+		if album, ok := r.albums[albumID]; ok {
+			albums = append(albums, album.Album)
+		}
+	}
+	return albums, nil
+}
+
+func (r *memAlbumRepository) Random(ctx context.Context) (Album, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, album := range r.albums {
+		return album.Album, nil
+	}
+	return Album{}, errAlbumNotFound
+}
+
+func (r *memAlbumRepository) AnyID(ctx context.Context) (string, error) {
+	album, err := r.Random(ctx)
+	return album.AlbumID, err
+}
+
+func (r *memAlbumRepository) Recent(ctx context.Context, window time.Duration, limit int) ([]Album, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	since := time.Now().Add(-window)
+	albums := r.sorted(func(album *memAlbum) bool { return window <= 0 || !album.CreatedAt.Before(since) })
+	return paginate(albums, limit, 0), nil
+}
+
+func (r *memAlbumRepository) Exists(ctx context.Context, albumID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.albums[albumID]
+	return ok, nil
+}
+
+func (r *memAlbumRepository) Owner(ctx context.Context, albumID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if album, ok := r.albums[albumID]; ok {
+		return album.OwnerID, nil
+	}
+	return "", errAlbumNotFound
+}
+
+func (r *memAlbumRepository) PrimaryImageKey(ctx context.Context, albumID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if album, ok := r.albums[albumID]; ok {
+		return album.Image.Key, nil
+	}
+	return "", nil
+}
+
+func (r *memAlbumRepository) FindByImageHash(ctx context.Context, hash, exceptAlbumID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for albumID, album := range r.albums {
+		if albumID != exceptAlbumID && album.Image.Hash == hash {
+			return albumID, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *memAlbumRepository) Tags(ctx context.Context, albumID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := []string{}
+	if album, ok := r.albums[albumID]; ok {
+		tags = append(tags, album.Tags...)
+	}
+	return tags, nil
+}
+
+func (r *memAlbumRepository) AddTags(ctx context.Context, albumID string, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	album := r.albums[albumID]
+	for _, tag := range tags {
+		if !slices.Contains(album.Tags, tag) {
+			album.Tags = append(album.Tags, tag)
+		}
+	}
+	slices.Sort(album.Tags)
+	return nil
+}
+
+func (r *memAlbumRepository) RemoveTags(ctx context.Context, albumID string, tags []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	album := r.albums[albumID]
+	album.Tags = slices.DeleteFunc(album.Tags, func(tag string) bool { return slices.Contains(tags, tag) })
+	return nil
+}
+
+func (r *memAlbumRepository) Tracks(ctx context.Context, albumID string) ([]Track, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tracks := []Track{}
+	if album, ok := r.albums[albumID]; ok {
+		tracks = append(tracks, album.Tracks...)
+	}
+	return tracks, nil
+}
+
+func (r *memAlbumRepository) SetTracks(ctx context.Context, albumID string, tracks []Track) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.albums[albumID].Tracks = slices.Clone(tracks)
+	return nil
+}
+
+func (r *memAlbumRepository) CollectionAlbums(ctx context.Context, collectionID string) ([]Album, error) {
+	return r.Lookup(ctx, r.collections[collectionID])
+}
+
+func (r *memAlbumRepository) Favorites(ctx context.Context, userID string, limit, offset int) ([]Album, error) {
+	albums, err := r.Lookup(ctx, r.favorites[userID])
+	return paginate(albums, limit, offset), err
+}
+
+func (r *memAlbumRepository) Delete(ctx context.Context, albumIDs []string) ([]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := make([]bool, len(albumIDs))
+	for i, albumID := range albumIDs {
+		if album, ok := r.albums[albumID]; ok {
+			found[i] = true
+			r.deleted[albumID], r.deletedAt[albumID] = album, time.Now()
+			delete(r.albums, albumID)
+		}
+	}
+	return found, nil
+}
+
+func (r *memAlbumRepository) Restore(ctx context.Context, albumID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	album, ok := r.deleted[albumID]
+	if ok {
+		r.albums[albumID] = album
+		delete(r.deleted, albumID)
+		delete(r.deletedAt, albumID)
+	}
+	return ok, nil
+}
+
+func (r *memAlbumRepository) Deleted(ctx context.Context, olderThan time.Duration, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var albumIDs []string
+	for albumID, deletedAt := range r.deletedAt {
+		if time.Since(deletedAt) >= olderThan && len(albumIDs) < limit {
+			albumIDs = append(albumIDs, albumID)
+		}
+	}
+	return albumIDs, nil
+}
+
+func (r *memAlbumRepository) Expired(ctx context.Context, maxAge time.Duration, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var albumIDs []string
+	for _, albums := range []map[string]*memAlbum{r.albums, r.deleted} {
+		for albumID, album := range albums {
+			expired := !album.ExpiresAt.IsZero() && !album.ExpiresAt.After(time.Now())
+			if (expired || maxAge > 0 && time.Since(album.CreatedAt) > maxAge) && len(albumIDs) < limit {
+				albumIDs = append(albumIDs, albumID)
+			}
+		}
+	}
+	return albumIDs, nil
+}
+
+func (r *memAlbumRepository) Purge(ctx context.Context, albumIDs []string) ([]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := make([]bool, len(albumIDs))
+	for i, albumID := range albumIDs {
+		_, live := r.albums[albumID]
+		_, deleted := r.deleted[albumID]
+		found[i] = live || deleted
+		delete(r.albums, albumID)
+		delete(r.deleted, albumID)
+		delete(r.deletedAt, albumID)
+	}
+	return found, nil
+}
+
+func (r *memAlbumRepository) Count(ctx context.Context) (albums, imageBytes int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, album := range r.albums {
+		albums++
+		imageBytes += album.Image.Size
+	}
+	for _, album := range r.deleted {
+		imageBytes += album.Image.Size
+	}
+	return albums, imageBytes, nil
+}
+
+// serve sends a request for path with an optional JSON body to a router that
+// has only the handler at route, and decodes the JSON response into out when
+// it is not nil.
+func serve(t *testing.T, method, path, route string, handler gin.HandlerFunc, body string, out any) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, handler)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestRandomAlbum(t *testing.T) {
+	repo := useMemAlbumRepository(t)
+
+	if code := serve(t, http.MethodGet, "/albums/random", "/albums/random", randomAlbum, "", nil); code != http.StatusNotFound {
+		t.Fatalf("empty repository: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	profile := Profile{Artist: "Miles Davis", Title: "Kind of Blue", Year: 1959}
+	if err := repo.Create(context.Background(), newAlbum{AlbumID: "a1", Image: storedImage{Key: "k1", Size: 10}, Profile: profile}); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Album    Album  `json:"album"`
+		ImageURL string `json:"imageUrl"`
+	}
+	code := serve(t, http.MethodGet, "/albums/random?include=image_url", "/albums/random", randomAlbum, "", &resp)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	if resp.Album.AlbumID != "a1" || resp.Album.Profile != profile {
+		t.Errorf("got album %+v, want a1 with %+v", resp.Album, profile)
+	}
+	if resp.ImageURL != "/albums/a1/image" {
+		t.Errorf("got imageUrl %q, want /albums/a1/image", resp.ImageURL)
+	}
+}
+
+func TestSetTracks(t *testing.T) {
+	repo := useMemAlbumRepository(t)
+	if err := repo.Create(context.Background(), newAlbum{AlbumID: "a1", Profile: Profile{Title: "Abbey Road"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"tracks": [{"title": " Come Together ", "duration": 259}, {"title": "Something", "duration": 182}]}`
+	code := serve(t, http.MethodPost, "/albums/a1/tracks", "/albums/:albumID/tracks", setTracks, body, nil)
+	if code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", code, http.StatusOK)
+	}
+	var resp struct {
+		Tracks []Track `json:"tracks"`
+	}
+	code = serve(t, http.MethodGet, "/albums/a1/tracks", "/albums/:albumID/tracks", getTracks, "", &resp)
+	want := []Track{{1, "Come Together", 259}, {2, "Something", 182}}
+	if code != http.StatusOK || !slices.Equal(resp.Tracks, want) {
+		t.Errorf("got status %d and tracks %+v, want %d and %+v", code, resp.Tracks, http.StatusOK, want)
+	}
+
+	code = serve(t, http.MethodPost, "/albums/missing/tracks", "/albums/:albumID/tracks", setTracks, body, nil)
+	if code != http.StatusNotFound {
+		t.Errorf("missing album: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAddTags(t *testing.T) {
+	repo := useMemAlbumRepository(t)
+	if err := repo.Create(context.Background(), newAlbum{AlbumID: "a1", Profile: Profile{Title: "Blue Train"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		Tags []string `json:"tags"`
+	}
+	code := serve(t, http.MethodPost, "/albums/a1/tags", "/albums/:albumID/tags", addTags, `{"tags": [" Jazz", "hard bop", "jazz"]}`, &resp)
+	if want := []string{"hard bop", "jazz"}; code != http.StatusOK || !slices.Equal(resp.Tags, want) {
+		t.Errorf("got status %d and tags %q, want %d and %q", code, resp.Tags, http.StatusOK, want)
+	}
+}
+
+func TestDeleteAndRestoreAlbum(t *testing.T) {
+	repo := useMemAlbumRepository(t)
+	if err := repo.Create(context.Background(), newAlbum{AlbumID: "a1", Profile: Profile{Title: "Giant Steps"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	code := serve(t, http.MethodDelete, "/admin/albums", "/admin/albums", bulkDeleteAlbums, `["a1"]`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("delete: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := serve(t, http.MethodGet, "/albums/random", "/albums/random", randomAlbum, "", nil); code != http.StatusNotFound {
+		t.Fatalf("deleted album: got status %d, want %d", code, http.StatusNotFound)
+	}
+
+	code = serve(t, http.MethodPost, "/albums/a1/restore", "/albums/:albumID/restore", restoreAlbum, "", nil)
+	if code != http.StatusOK {
+		t.Fatalf("restore: got status %d, want %d", code, http.StatusOK)
+	}
+	if code := serve(t, http.MethodGet, "/albums/random", "/albums/random", randomAlbum, "", nil); code != http.StatusOK {
+		t.Fatalf("restored album: got status %d, want %d", code, http.StatusOK)
+	}
+	code = serve(t, http.MethodPost, "/albums/a1/restore", "/albums/:albumID/restore", restoreAlbum, "", nil)
+	if code != http.StatusNotFound {
+		t.Errorf("restoring an album that is not deleted: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestRequireAlbumOwner(t *testing.T) {
+	repo := useMemAlbumRepository(t)
+	if err := repo.Create(context.Background(), newAlbum{AlbumID: "a1", OwnerID: "u1", Profile: Profile{Title: "Blue Train"}}); err != nil {
+		t.Fatal(err)
+	}
+	// as runs requireAlbumOwner for a request of the user with the role.
+	as := func(userID, role string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set(userIDKey, userID)
+			c.Set(roleKey, role)
+			if requireAlbumOwner()(c); !c.IsAborted() {
+				c.Status(http.StatusNoContent)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		userID, role string
+		want         int
+	}{
+		{"u1", roleWriter, http.StatusNoContent},
+		{"u2", roleWriter, http.StatusForbidden},
+		{"", roleWriter, http.StatusForbidden},
+		{"u2", roleAdmin, http.StatusNoContent},
+	} {
+		code := serve(t, http.MethodPost, "/albums/a1/tags", "/albums/:albumID/tags", as(tc.userID, tc.role), "", nil)
+		if code != tc.want {
+			t.Errorf("user %q with role %s: got status %d, want %d", tc.userID, tc.role, code, tc.want)
+		}
+	}
+}
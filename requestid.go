@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// requestIDKey is the Gin context key holding the ID of the request.
+const requestIDKey = "requestID"
+
+const maxRequestIDLength = 128 // Longest X-Request-ID accepted from a client or load balancer
+
+// requestIDs is a middleware that gives every request an ID, taken from the
+// X-Request-ID header when the client or load balancer sent a usable one, and
+// returns it in the X-Request-ID response header and in the body of JSON
+// error responses. The logger of the request context, returned by logFor,
+// adds the ID to every line logged for the request.
+func requestIDs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header("X-Request-ID", requestID)
+		annotateSpan(c, requestID)
+		requestLogger := logger.With().Str("requestID", requestID).Logger()
+		c.Request = c.Request.WithContext(requestLogger.WithContext(c.Request.Context()))
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, requestID: requestID}
+		c.Next()
+	}
+}
+
+// validRequestID reports whether id is non-empty, not too long and only made
+// of printable ASCII characters, so it can be logged and stored as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDWriter adds the requestID field to the JSON objects of error
+// responses, so the ID reported by a client with its failure finds the
+// lines logged for the request. Gin writes a JSON body in a single Write.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	requestID string
+}
+
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.Written() || w.Status() < 400 || len(data) < 2 || data[0] != '{' ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(data)
+	}
+	id, _ := json.Marshal(w.requestID)
+	body := append([]byte(`{"requestID":`), id...)
+	if !bytes.Equal(bytes.TrimSpace(data[1:]), []byte("}")) {
+		body = append(body, ',')
+	}
+	if _, err := w.ResponseWriter.Write(append(body, data[1:]...)); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"slices"
+	"strconv"
+)
+
+// resizeDimensions are the widths and heights accepted by the ?w= and ?h=
+// image parameters, set by RESIZE_DIMENSIONS. The whitelist bounds the number
+// of variants stored per image.
+var resizeDimensions = []int{64, 128, 256, 512, 1024}
+
+// imageResize is a resize requested with the ?w=, ?h= and ?fit= parameters.
+// A zero width or height is derived from the aspect ratio of the image.
+type imageResize struct {
+	Width  int
+	Height int
+	Fit    string // "contain" (default) or "cover"
+}
+
+// parseResizeDimensions parses the RESIZE_DIMENSIONS list of pixel sizes.
+func parseResizeDimensions(values []string) ([]int, error) {
+	var dimensions []int
+	for _, v := range values {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, errors.New("invalid dimension " + v)
+		}
+		dimensions = append(dimensions, n)
+	}
+	return dimensions, nil
+}
+
+// parseResize reads and validates the resize parameters of the request.
+func parseResize(c *gin.Context) (imageResize, error) {
+	resize := imageResize{Fit: c.DefaultQuery("fit", "contain")}
+	if resize.Fit != "contain" && resize.Fit != "cover" {
+		return resize, errors.New("fit must be 'contain' or 'cover'")
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"w", &resize.Width}, {"h", &resize.Height}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(resizeDimensions, n) {
+			return resize, errors.New(p.name + " must be one of the allowed dimensions")
+		}
+		*p.value = n
+	}
+	if resize.Fit == "cover" && (resize.Width == 0 || resize.Height == 0) {
+		return resize, errors.New("fit=cover requires both w and h")
+	}
+	return resize, nil
+}
+
+// variant returns the name the resized image is stored under in image_variants.
+func (r imageResize) variant() string {
+	return "w" + strconv.Itoa(r.Width) + "-h" + strconv.Itoa(r.Height) + "-" + r.Fit
+}
+
+// ensureResized returns the storage key of the image stored under sourceKey
+// resized as requested in the given output format, rendering it on first use.
+func ensureResized(ctx context.Context, sourceKey string, resize imageResize, format string) (string, error) {
+	return ensureVariant(ctx, sourceKey, variantName(resize.variant(), format), func(imageData []byte) ([]byte, error) {
+		return makeResized(imageData, resize, format)
+	})
+}
+
+// makeResized decodes an image and encodes it resized as requested in the
+// given output format.
+// With fit=contain the image is scaled to fit within the requested size. With
+// fit=cover it is scaled to fill the requested size and the center is cropped.
+func makeResized(imageData []byte, resize imageResize, format string) ([]byte, error) {
+	src, err := decodeImage(imageData)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if resize.Fit == "contain" {
+		maxWidth, maxHeight := resize.Width, resize.Height
+		if maxWidth == 0 {
+			maxWidth = bounds.Dx()
+		}
+		if maxHeight == 0 {
+			maxHeight = bounds.Dy()
+		}
+		width, height := fitWithin(bounds, maxWidth, maxHeight)
+		dst := newCanvas(width, height)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+		return encodeImage(dst, format)
+	}
+
+	// Crop the largest centered region with the requested aspect ratio.
+	crop := bounds
+	if bounds.Dx()*resize.Height > bounds.Dy()*resize.Width {
+		width := bounds.Dy() * resize.Width / resize.Height
+		crop.Min.X += (bounds.Dx() - width) / 2
+		crop.Max.X = crop.Min.X + width
+	} else {
+		height := bounds.Dx() * resize.Height / resize.Width
+		crop.Min.Y += (bounds.Dy() - height) / 2
+		crop.Max.Y = crop.Min.Y + height
+	}
+	dst := newCanvas(resize.Width, resize.Height)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	return encodeImage(dst, format)
+}
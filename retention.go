@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// Retention settings. Albums older than retentionMaxAge (RETENTION_MAX_AGE,
+// disabled when zero) and albums uploaded with a ttl that has passed are
+// deleted every retentionInterval (RETENTION_INTERVAL).
+var (
+	retentionMaxAge   time.Duration
+	retentionInterval = time.Hour
+)
+
+const retentionBatchSize = 500 // Number of albums deleted per transaction by the retention job
+
+// parseTTL reads the optional ttl query parameter (e.g. "24h") that marks an
+// uploaded album as temporary. It returns zero when the album is permanent.
+func parseTTL(c *gin.Context) (time.Duration, error) {
+	v := c.Query("ttl")
+	if v == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < time.Second {
+		return 0, errors.New("ttl must be a duration of at least 1s")
+	}
+	return ttl, nil
+}
+
+// setAlbumExpiryTx marks an album created in tx to be deleted by the
+// retention job once ttl has passed. A zero ttl leaves the album permanent.
+func setAlbumExpiryTx(ctx context.Context, tx *sql.Tx, albumID string, ttl time.Duration) error {
+	if ttl == 0 {
+		return nil
+	}
+	query := `UPDATE albums SET expires_at = ` + dialect.secondsFromNow() + ` WHERE album_id = ?`
+	_, err := tx.ExecContext(ctx, query, int64(ttl/time.Second), albumID)
+	return err
+}
+
+// startRetentionJob runs expireAlbums every retentionInterval in the background.
+func startRetentionJob() {
+	go func() {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			n, err := expireAlbums(context.Background())
+			if err != nil {
+				logger.Error().Err(err).Msg("Error deleting expired albums")
+			}
+			if n > 0 {
+				logger.Info().Int("albums", n).Msg("Deleted expired albums")
+				entry := auditEntry{Actor: "retention", Action: "expire albums"}
+				if err := recordAudit(context.Background(), entry, map[string]any{"deleted": n}); err != nil {
+					logger.Error().Err(err).Msg("Error recording audit entry of expired albums")
+				}
+			}
+		}
+	}()
+}
+
+// expireAlbums deletes the albums that are past their expiry or older than
+// retentionMaxAge, in batches of retentionBatchSize, and returns their number.
+func expireAlbums(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		albumIDs, err := albumRepo.Expired(ctx, retentionMaxAge, retentionBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := deleteAlbums(ctx, albumIDs)
+		deleted += n
+		if err != nil || len(albumIDs) < retentionBatchSize {
+			return deleted, err
+		}
+	}
+}
+
+// deleteAlbums permanently deletes albums together and returns the number
+// deleted.
+func deleteAlbums(ctx context.Context, albumIDs []string) (int, error) {
+	if len(albumIDs) == 0 {
+		return 0, nil
+	}
+	found, err := albumRepo.Purge(ctx, albumIDs)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, existed := range found {
+		if existed {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// adminExpire handles POST /admin/expire and runs the retention job now.
+func adminExpire(c *gin.Context) {
+	n, err := expireAlbums(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to delete expired albums"})
+		return
+	}
+	auditDetail(c, "deleted", n)
+	c.JSON(http.StatusOK, gin.H{"deleted": n})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"io"
+	"math/rand/v2"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Database calls failing with a transient error are attempted up to
+// retryAttempts times in total, set by DB_RETRY_ATTEMPTS, waiting a random
+// delay of up to retryBaseDelay (DB_RETRY_BASE_DELAY) doubled for each retry.
+var (
+	retryAttempts  = 3
+	retryBaseDelay = 50 * time.Millisecond
+)
+
+// dbRetries counts the retried database calls and those that still failed
+// with a transient error after the last attempt, as shown by /admin/stats.
+var dbRetries struct {
+	retried   atomic.Int64
+	exhausted atomic.Int64
+}
+
+// isTransientError reports whether err is a database error that may succeed
+// when the call is repeated: deadlocks and lock timeouts, broken connections
+// and servers that are restarting or failing over.
+func isTransientError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1205, // Lock wait timeout exceeded
+			1213, // Deadlock found when trying to get lock
+			1290: // Running with --read-only, as a writer being demoted in a failover
+			return true
+		}
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08") // connection_exception
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff // The primary result code of an extended one
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	return false
+}
+
+// commitError is returned by commitTx when a COMMIT fails. The transaction
+// may have been committed even so, when only the answer of the server was
+// lost, so it is not retried.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string { return "committing transaction: " + e.err.Error() }
+func (e *commitError) Unwrap() error { return e.err }
+
+// commitTx commits tx, wrapping a failure in a commitError. Transactions that
+// cannot be repeated once committed, such as inserts, use it instead of
+// tx.Commit.
+func commitTx(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return &commitError{err}
+	}
+	return nil
+}
+
+// withRetry calls op until it succeeds, fails with an error that is not
+// transient or a commitError, ctx is done or retryAttempts calls were made.
+// Each call must be a complete unit of work, such as a whole transaction, so
+// it can be repeated.
+func withRetry[T any](ctx context.Context, op func() (T, error)) (T, error) {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		result, err := op()
+		var commitErr *commitError
+		if err == nil || !isTransientError(err) || errors.As(err, &commitErr) || ctx.Err() != nil {
+			return result, err
+		}
+		if attempt >= retryAttempts {
+			dbRetries.exhausted.Add(1)
+			return result, err
+		}
+		dbRetries.retried.Add(1)
+
+		// Full jitter spreads out the retries of requests that failed together.
+		timer := time.NewTimer(rand.N(delay) + 1)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryingAlbumRepository retries the calls to another AlbumRepository that
+// fail with a transient database error, so a brief failover does not surface
+// as a server error.
+type retryingAlbumRepository struct {
+	next AlbumRepository
+}
+
+// retryErr runs op with withRetry for the calls that only return an error.
+func retryErr(ctx context.Context, op func() error) error {
+	_, err := withRetry(ctx, func() (struct{}, error) { return struct{}{}, op() })
+	return err
+}
+
+func (r retryingAlbumRepository) Create(ctx context.Context, album newAlbum) error {
+	return retryErr(ctx, func() error { return r.next.Create(ctx, album) })
+}
+
+func (r retryingAlbumRepository) GetByID(ctx context.Context, albumID string) (albumDetail, error) {
+	return withRetry(ctx, func() (albumDetail, error) { return r.next.GetByID(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) List(ctx context.Context, filter albumFilter, page albumPage) ([]Album, error) {
+	return withRetry(ctx, func() ([]Album, error) { return r.next.List(ctx, filter, page) })
+}
+
+func (r retryingAlbumRepository) Lookup(ctx context.Context, albumIDs []string) ([]Album, error) {
+	return withRetry(ctx, func() ([]Album, error) { return r.next.Lookup(ctx, albumIDs) })
+}
+
+func (r retryingAlbumRepository) Random(ctx context.Context) (Album, error) {
+	return withRetry(ctx, func() (Album, error) { return r.next.Random(ctx) })
+}
+
+func (r retryingAlbumRepository) AnyID(ctx context.Context) (string, error) {
+	return withRetry(ctx, func() (string, error) { return r.next.AnyID(ctx) })
+}
+
+func (r retryingAlbumRepository) Recent(ctx context.Context, window time.Duration, limit int) ([]Album, error) {
+	return withRetry(ctx, func() ([]Album, error) { return r.next.Recent(ctx, window, limit) })
+}
+
+func (r retryingAlbumRepository) Exists(ctx context.Context, albumID string) (bool, error) {
+	return withRetry(ctx, func() (bool, error) { return r.next.Exists(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) Owner(ctx context.Context, albumID string) (string, error) {
+	return withRetry(ctx, func() (string, error) { return r.next.Owner(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) PrimaryImageKey(ctx context.Context, albumID string) (string, error) {
+	return withRetry(ctx, func() (string, error) { return r.next.PrimaryImageKey(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) FindByImageHash(ctx context.Context, hash, exceptAlbumID string) (string, error) {
+	return withRetry(ctx, func() (string, error) { return r.next.FindByImageHash(ctx, hash, exceptAlbumID) })
+}
+
+func (r retryingAlbumRepository) Tags(ctx context.Context, albumID string) ([]string, error) {
+	return withRetry(ctx, func() ([]string, error) { return r.next.Tags(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) AddTags(ctx context.Context, albumID string, tags []string) error {
+	return retryErr(ctx, func() error { return r.next.AddTags(ctx, albumID, tags) })
+}
+
+func (r retryingAlbumRepository) RemoveTags(ctx context.Context, albumID string, tags []string) error {
+	return retryErr(ctx, func() error { return r.next.RemoveTags(ctx, albumID, tags) })
+}
+
+func (r retryingAlbumRepository) Tracks(ctx context.Context, albumID string) ([]Track, error) {
+	return withRetry(ctx, func() ([]Track, error) { return r.next.Tracks(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) SetTracks(ctx context.Context, albumID string, tracks []Track) error {
+	return retryErr(ctx, func() error { return r.next.SetTracks(ctx, albumID, tracks) })
+}
+
+func (r retryingAlbumRepository) CollectionAlbums(ctx context.Context, collectionID string) ([]Album, error) {
+	return withRetry(ctx, func() ([]Album, error) { return r.next.CollectionAlbums(ctx, collectionID) })
+}
+
+func (r retryingAlbumRepository) Favorites(ctx context.Context, userID string, limit, offset int) ([]Album, error) {
+	return withRetry(ctx, func() ([]Album, error) { return r.next.Favorites(ctx, userID, limit, offset) })
+}
+
+func (r retryingAlbumRepository) Delete(ctx context.Context, albumIDs []string) ([]bool, error) {
+	return withRetry(ctx, func() ([]bool, error) { return r.next.Delete(ctx, albumIDs) })
+}
+
+func (r retryingAlbumRepository) Restore(ctx context.Context, albumID string) (bool, error) {
+	return withRetry(ctx, func() (bool, error) { return r.next.Restore(ctx, albumID) })
+}
+
+func (r retryingAlbumRepository) Deleted(ctx context.Context, olderThan time.Duration, limit int) ([]string, error) {
+	return withRetry(ctx, func() ([]string, error) { return r.next.Deleted(ctx, olderThan, limit) })
+}
+
+func (r retryingAlbumRepository) Expired(ctx context.Context, maxAge time.Duration, limit int) ([]string, error) {
+	return withRetry(ctx, func() ([]string, error) { return r.next.Expired(ctx, maxAge, limit) })
+}
+
+func (r retryingAlbumRepository) Purge(ctx context.Context, albumIDs []string) ([]bool, error) {
+	return withRetry(ctx, func() ([]bool, error) { return r.next.Purge(ctx, albumIDs) })
+}
+
+func (r retryingAlbumRepository) Count(ctx context.Context) (albums, imageBytes int64, err error) {
+	counts, err := withRetry(ctx, func() ([2]int64, error) {
+		albums, imageBytes, err := r.next.Count(ctx)
+		return [2]int64{albums, imageBytes}, err
+	})
+	return counts[0], counts[1], err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// postReview handles POST /review/:likeornot/:albumID and records a like or dislike.
+func postReview(c *gin.Context) {
+	albumID := c.Param("albumID")
+	var likes, dislikes int
+	switch c.Param("likeornot") {
+	case "like":
+		likes = 1
+	case "dislike":
+		dislikes = 1
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: review must be 'like' or 'dislike'"})
+		return
+	}
+
+	// Make sure the album exists before recording the review.
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	// Increment the counters atomically in the database.
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	query := `INSERT INTO reviews (album_id, likes, dislikes) VALUES (?, ?, ?) ` +
+		dialect.onConflictUpdate("album_id") + ` likes = reviews.likes + ` + dialect.inserted("likes") + `,
+		dislikes = reviews.dislikes + ` + dialect.inserted("dislikes")
+	if _, err := db.ExecContext(ctx, query, albumID, likes, dislikes); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist review"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"msg": "review recorded"})
+}
+
+// getReviews handles GET /albums/:albumID/reviews and returns the review counts.
+func getReviews(c *gin.Context) {
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	// An album without a reviews row has no likes or dislikes yet.
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	var likes, dislikes int
+	query := `SELECT likes, dislikes FROM reviews WHERE album_id = ?`
+	err := readDB(ctx).QueryRowContext(ctx, query, albumID).Scan(&likes, &dislikes)
+	if err != nil && err != sql.ErrNoRows {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve reviews"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"albumID":  albumID,
+		"likes":    likes,
+		"dislikes": dislikes,
+	})
+}
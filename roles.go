@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// Roles of the callers of the API. Readers may only read, writers may also
+// create and change albums and admins may use every route.
+const (
+	roleNone   = ""
+	roleReader = "reader"
+	roleWriter = "writer"
+	roleAdmin  = "admin"
+)
+
+// roleRanks orders the roles; a role may do everything a lower one may.
+var roleRanks = map[string]int{roleNone: 0, roleReader: 1, roleWriter: 2, roleAdmin: 3}
+
+// roleKey is the Gin context key holding the role of the request. userRoleKey
+// holds the role stored for its user, if any.
+const (
+	roleKey     = "role"
+	userRoleKey = "userRole"
+)
+
+// anonymousRole is the role of requests without any credentials, set by
+// ANONYMOUS_ROLE. It is writer by default, as the API was open before roles;
+// reader or none closes the API to anonymous writes or to anonymous use.
+var anonymousRole = roleWriter
+
+// publicRoutes are open to every request, whatever anonymousRole is, and are
+// never rate limited. /metrics is restricted to ADMIN_ALLOWED_CIDRS instead.
+var publicRoutes = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+	"/version": true,
+}
+
+// parseRole checks that s names a role, or "none" for roleNone.
+func parseRole(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "none" {
+		return roleNone, nil
+	}
+	if _, ok := roleRanks[s]; !ok || s == roleNone {
+		return "", errors.New("role must be one of admin, writer, reader")
+	}
+	return s, nil
+}
+
+// requestRole returns the role of a request: admin with the admin token, the
+// role of a Basic auth user, the highest known role of the roles claim of a
+// bearer token, the role stored for the user of an X-User-Token or OpenID
+// Connect token, writer with an API key or a request signature and
+// anonymousRole otherwise, which is at most reader when there are Basic auth
+// users.
+func requestRole(c *gin.Context) string {
+	if validAdminToken(c) {
+		return roleAdmin
+	}
+	if role := basicRole(c); role != roleNone {
+		return role
+	}
+	if c.GetBool(bearerKey) {
+		role := roleNone
+		for _, r := range c.GetStringSlice(rolesKey) {
+			if rank, ok := roleRanks[r]; ok && rank > roleRanks[role] {
+				role = r
+			}
+		}
+		if role != roleNone {
+			return role
+		}
+	}
+	if c.GetString(userIDKey) != "" {
+		return c.GetString(userRoleKey)
+	}
+	if c.GetBool(bearerKey) || c.GetString(apiKeyIDKey) != "" || c.GetString(signerKey) != "" {
+		return roleWriter
+	}
+	if len(basicAuthUsers) > 0 && roleRanks[anonymousRole] > roleRanks[roleReader] {
+		return roleReader
+	}
+	return anonymousRole
+}
+
+// authenticated reports whether a request has credentials of any kind.
+func authenticated(c *gin.Context) bool {
+	return validAdminToken(c) || c.GetString(basicUserKey) != "" || c.GetBool(bearerKey) ||
+		c.GetString(userIDKey) != "" || c.GetString(apiKeyIDKey) != "" || c.GetString(signerKey) != ""
+}
+
+// authorize is a middleware that resolves the user of an X-User-Token header
+// and rejects requests whose role is too low for their route: reading needs
+// the reader role and changing data the writer role. Admin routes also check
+// for the admin role with requireAdmin. Signed image URLs need no role, as the
+// image handler verifies them.
+func authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(userIDKey) == "" && c.GetHeader("X-User-Token") != "" && !authenticateUser(c) {
+			return
+		}
+		role := requestRole(c)
+		c.Set(roleKey, role)
+		route := c.FullPath()
+		if route == "" || publicRoutes[route] || signedImageRequest(c) {
+			c.Next()
+			return
+		}
+		required := roleReader
+		if isMutation(c) {
+			required = roleWriter
+		}
+		if roleRanks[role] < roleRanks[required] {
+			if basicUsers := len(basicAuthUsers) > 0; role == roleNone || basicUsers && !authenticated(c) {
+				if basicUsers {
+					c.Header("WWW-Authenticate", basicChallenge)
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "authentication required"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "the " + role + " role cannot use this endpoint"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAlbumOwner is a middleware for routes that change the album of the
+// albumID parameter. Albums owned by a user may only be changed by the owner
+// and admins; albums uploaded anonymously may be changed by every writer.
+func requireAlbumOwner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(roleKey) == roleAdmin {
+			c.Next()
+			return
+		}
+		ownerID, err := albumRepo.Owner(c.Request.Context(), c.Param("albumID"))
+		if errors.Is(err, errAlbumNotFound) {
+			// Let the handler respond as it does for unknown albums.
+			c.Next()
+			return
+		} else if err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album owner"})
+			return
+		}
+		if ownerID != "" && ownerID != c.GetString(userIDKey) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"msg": "only the owner of the album or an admin can change it"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// userRoleRequest is the JSON body accepted by PUT /admin/users/:userID/role.
+type userRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// setUserRole handles PUT /admin/users/:userID/role and changes the role of a
+// user. Roles in the claims of bearer tokens take precedence.
+func setUserRole(c *gin.Context) {
+	var req userRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	role, err := parseRole(req.Role)
+	if err != nil || role == roleNone {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: role must be one of admin, writer, reader"})
+		return
+	}
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	result, err := db.ExecContext(ctx, `UPDATE users SET role = ? WHERE user_id = ?`, role, c.Param("userID"))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to update user role"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 && !userExists(ctx, c.Param("userID")) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "user not found"})
+		return
+	}
+	auditDetail(c, "role", role)
+	c.JSON(http.StatusOK, gin.H{"userID": c.Param("userID"), "role": role})
+}
+
+// userExists reports whether a user with the ID exists. MySQL reports no
+// affected rows for updates that do not change a row.
+func userExists(ctx context.Context, userID string) bool {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE user_id = ?`, userID).Scan(&n)
+	return err == nil && n > 0
+}
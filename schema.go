@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// schemaQueries creates the tables used by the server if they do not exist.
+// Together with schemaColumns and schemaIndexes it describes the schema at
+// baselineVersion and is only used to upgrade databases created before
+// versioned migrations; later changes go into the migrations directory.
+var schemaQueries = []string{
+	`CREATE TABLE IF NOT EXISTS artists (
+		artist_id VARCHAR(255) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS albums (
+		album_id VARCHAR(255) PRIMARY KEY,
+		image_size INT NOT NULL,
+		image_hash CHAR(64) NOT NULL DEFAULT '',
+		image_width INT NOT NULL DEFAULT 0,
+		image_height INT NOT NULL DEFAULT 0,
+		image_phash BIGINT NULL,
+		dominant_color CHAR(7) NOT NULL DEFAULT '',
+		average_color CHAR(7) NOT NULL DEFAULT '',
+		artist VARCHAR(255) NOT NULL,
+		artist_id VARCHAR(255) NULL,
+		owner_id VARCHAR(255) NULL,
+		title VARCHAR(255) NOT NULL,
+		year VARCHAR(4) NOT NULL,
+		genre VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NULL,
+		CONSTRAINT fk_albums_artist FOREIGN KEY (artist_id) REFERENCES artists (artist_id)
+	);`,
+	`CREATE TABLE IF NOT EXISTS album_images (
+		image_id VARCHAR(255) PRIMARY KEY,
+		album_id VARCHAR(255) NOT NULL,
+		storage_key VARCHAR(255) NOT NULL,
+		image_size INT NOT NULL,
+		image_hash CHAR(64) NOT NULL,
+		content_type VARCHAR(64) NOT NULL DEFAULT '',
+		width INT NOT NULL DEFAULT 0,
+		height INT NOT NULL DEFAULT 0,
+		label VARCHAR(64) NOT NULL DEFAULT '',
+		is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_album_images_album (album_id, is_primary)
+	);`,
+	`CREATE TABLE IF NOT EXISTS image_blobs (
+		blob_key VARCHAR(255) PRIMARY KEY,
+		data LONGBLOB NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS image_objects (
+		storage_key VARCHAR(255) PRIMARY KEY,
+		image_hash CHAR(64) NOT NULL UNIQUE,
+		ref_count INT NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS image_variants (
+		source_key VARCHAR(255) NOT NULL,
+		variant VARCHAR(64) NOT NULL,
+		storage_key VARCHAR(255) NOT NULL,
+		image_size INT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (source_key, variant)
+	);`,
+	`CREATE TABLE IF NOT EXISTS reviews (
+		album_id VARCHAR(255) PRIMARY KEY,
+		likes INT NOT NULL DEFAULT 0,
+		dislikes INT NOT NULL DEFAULT 0
+	);`,
+	`CREATE TABLE IF NOT EXISTS tags (
+		tag_id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL UNIQUE
+	);`,
+	`CREATE TABLE IF NOT EXISTS album_tags (
+		album_id VARCHAR(255) NOT NULL,
+		tag_id INT NOT NULL,
+		PRIMARY KEY (album_id, tag_id),
+		INDEX idx_album_tags_tag (tag_id)
+	);`,
+	`CREATE TABLE IF NOT EXISTS tracks (
+		album_id VARCHAR(255) NOT NULL,
+		position INT NOT NULL,
+		title VARCHAR(255) NOT NULL,
+		duration INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (album_id, position)
+	);`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		comment_id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		album_id VARCHAR(255) NOT NULL,
+		author VARCHAR(255) NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_comments_album (album_id, created_at)
+	);`,
+	`CREATE TABLE IF NOT EXISTS ratings (
+		album_id VARCHAR(255) PRIMARY KEY,
+		rating_count BIGINT NOT NULL DEFAULT 0,
+		rating_sum BIGINT NOT NULL DEFAULT 0
+	);`,
+	`CREATE TABLE IF NOT EXISTS collections (
+		collection_id VARCHAR(255) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS collection_albums (
+		collection_id VARCHAR(255) NOT NULL,
+		album_id VARCHAR(255) NOT NULL,
+		position INT NOT NULL,
+		PRIMARY KEY (collection_id, album_id),
+		INDEX idx_collection_albums_position (collection_id, position)
+	);`,
+	`CREATE TABLE IF NOT EXISTS users (
+		user_id VARCHAR(255) PRIMARY KEY,
+		name VARCHAR(255) NOT NULL UNIQUE,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS favorites (
+		user_id VARCHAR(255) NOT NULL,
+		album_id VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, album_id)
+	);`,
+}
+
+// schemaColumn is a column added to an existing table after it was first created.
+type schemaColumn struct {
+	Table      string
+	Column     string
+	Definition string
+}
+
+// schemaColumns are added to tables created by older versions of the server.
+var schemaColumns = []schemaColumn{
+	{"albums", "genre", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"albums", "image_hash", "CHAR(64) NOT NULL DEFAULT ''"},
+	{"albums", "artist_id", "VARCHAR(255) NULL, ADD CONSTRAINT fk_albums_artist FOREIGN KEY (artist_id) REFERENCES artists (artist_id)"},
+	{"album_images", "storage_key", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"albums", "expires_at", "TIMESTAMP NULL"},
+	{"album_images", "content_type", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"album_images", "width", "INT NOT NULL DEFAULT 0"},
+	{"album_images", "height", "INT NOT NULL DEFAULT 0"},
+	{"albums", "image_width", "INT NOT NULL DEFAULT 0"},
+	{"albums", "image_height", "INT NOT NULL DEFAULT 0"},
+	{"albums", "image_phash", "BIGINT NULL"},
+	{"albums", "dominant_color", "CHAR(7) NOT NULL DEFAULT ''"},
+	{"albums", "average_color", "CHAR(7) NOT NULL DEFAULT ''"},
+	{"albums", "owner_id", "VARCHAR(255) NULL"},
+}
+
+// schemaIndex is a secondary index created on an existing table.
+type schemaIndex struct {
+	Table   string
+	Name    string
+	Columns string
+}
+
+// schemaIndexes are created on tables that do not have them yet.
+var schemaIndexes = []schemaIndex{
+	{"albums", "idx_albums_genre", "genre"},
+	{"albums", "idx_albums_created", "created_at, album_id"},
+	{"albums", "idx_albums_image_hash", "image_hash"},
+	{"albums", "idx_albums_artist", "artist, album_id"},
+	{"albums", "idx_albums_title", "title, album_id"},
+	{"albums", "idx_albums_year", "year, album_id"},
+	{"albums", "idx_albums_artist_id", "artist_id, created_at"},
+	{"albums", "idx_albums_expires", "expires_at"},
+	{"albums", "idx_albums_owner", "owner_id"},
+}
+
+// albumChildTables hold rows keyed by album_id that are removed with their album.
+var albumChildTables = []string{
+	"album_images",
+	"reviews",
+	"album_tags",
+	"tracks",
+	"comments",
+	"ratings",
+	"collection_albums",
+	"favorites",
+}
+
+// resetTables lists the tables truncated by the /reset endpoint. Images kept
+// in an external store such as S3 are not removed by a reset.
+var resetTables = []string{
+	"albums",
+	"album_images",
+	"image_blobs",
+	"image_chunks",
+	"image_objects",
+	"image_variants",
+	"reviews",
+	"tags",
+	"album_tags",
+	"tracks",
+	"artists",
+	"comments",
+	"ratings",
+	"collections",
+	"collection_albums",
+	"users",
+	"user_identities",
+	"favorites",
+	"album_write_failures",
+}
+
+// upgradeLegacySchema runs every schema query in order, adds any missing
+// columns and indexes, and upgrades data written by older versions of the
+// server, bringing a database created before versioned migrations to
+// baselineVersion.
+func upgradeLegacySchema() error {
+	for _, query := range schemaQueries {
+		for _, stmt := range dialect.schema(query) {
+			if _, err := db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	for _, col := range schemaColumns {
+		if err := addColumnIfMissing(col); err != nil {
+			return err
+		}
+	}
+	for _, idx := range schemaIndexes {
+		if err := addIndexIfMissing(idx); err != nil {
+			return err
+		}
+	}
+	if err := moveAlbumImages(); err != nil {
+		return err
+	}
+	if err := backfillImageObjects(); err != nil {
+		return err
+	}
+	return backfillAlbumArtists()
+}
+
+// moveAlbumImages moves the images stored in the database by older versions
+// of the server into the image store: first the albums.image_data column,
+// then the album_images.image_data column. Both columns are dropped afterwards.
+func moveAlbumImages() error {
+	exists, err := columnExists("albums", "image_data")
+	if err != nil {
+		return err
+	}
+	if exists {
+		where := `image_data IS NOT NULL AND album_id NOT IN (SELECT album_id FROM album_images)`
+		if err := copyLegacyImages("albums", "album_id", where); err != nil {
+			return err
+		}
+		query := `INSERT INTO album_images (image_id, album_id, storage_key, image_size, image_hash, label, is_primary)
+			SELECT album_id, album_id, album_id, image_size, image_hash, ?, TRUE FROM albums WHERE ` + where
+		if _, err := db.Exec(query, primaryImageLabel); err != nil {
+			return err
+		}
+		if _, err := db.Exec("ALTER TABLE albums DROP COLUMN image_data"); err != nil {
+			return err
+		}
+	}
+
+	exists, err = columnExists("album_images", "image_data")
+	if err != nil || !exists {
+		return err
+	}
+	if err := copyLegacyImages("album_images", "image_id", "storage_key = ''"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE album_images SET storage_key = image_id WHERE storage_key = ''`); err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE album_images DROP COLUMN image_data")
+	return err
+}
+
+// copyLegacyImages writes the image_data of the rows of table matching where
+// to the image store, keyed by keyColumn. The database store gets them with a
+// single INSERT ... SELECT; other stores get one image at a time.
+func copyLegacyImages(table, keyColumn, where string) error {
+	if _, ok := imageStore.(dbImageStore); ok {
+		query := `INSERT INTO image_blobs (blob_key, data) SELECT ` + keyColumn + `, image_data FROM ` + table + ` WHERE ` + where
+		_, err := db.Exec(dialect.insertIgnore(query))
+		return err
+	}
+
+	rows, err := db.Query(`SELECT ` + keyColumn + ` FROM ` + table + ` WHERE ` + where)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, key := range keys {
+		var data []byte
+		err := db.QueryRow(`SELECT image_data FROM `+table+` WHERE `+keyColumn+` = ?`, key).Scan(&data)
+		if err != nil {
+			return err
+		}
+		if err := imageStore.Put(ctx, key, bytes.NewReader(data), http.DetectContentType(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillImageObjects registers the images stored before deduplication in
+// image_objects when the table is still empty. Only one object per hash can be
+// registered; the other copies stay owned by their single album_images row.
+func backfillImageObjects() error {
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM image_objects)`).Scan(&exists); err != nil || exists {
+		return err
+	}
+	query := `INSERT INTO image_objects (storage_key, image_hash, ref_count)
+		SELECT storage_key, image_hash, COUNT(*) FROM album_images
+		WHERE image_hash <> '' GROUP BY storage_key, image_hash`
+	_, err := db.Exec(dialect.insertIgnore(query))
+	return err
+}
+
+// backfillAlbumArtists creates artist records for albums that have no artist_id.
+// Only MySQL databases can predate artist records.
+func backfillAlbumArtists() error {
+	if dialect != mysqlDialect {
+		return nil
+	}
+	query := `INSERT IGNORE INTO artists (artist_id, name)
+		SELECT UUID(), artist FROM albums WHERE artist_id IS NULL AND artist <> '' GROUP BY artist`
+	if _, err := db.Exec(query); err != nil {
+		return err
+	}
+	query = `UPDATE albums a JOIN artists ar ON ar.name = a.artist
+		SET a.artist_id = ar.artist_id WHERE a.artist_id IS NULL`
+	_, err := db.Exec(query)
+	return err
+}
+
+// columnExists reports whether table has a column with the given name.
+func columnExists(table, column string) (bool, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = ` + dialect.currentSchema() + ` AND table_name = ? AND column_name = ?`
+	if dialect == sqliteDialect {
+		query = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	}
+	err := db.QueryRow(query, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// addColumnIfMissing adds col to its table unless the column already exists.
+func addColumnIfMissing(col schemaColumn) error {
+	exists, err := columnExists(col.Table, col.Column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + col.Table + " ADD COLUMN " + col.Column + " " + dialect.columnTypes(col.Definition))
+	return err
+}
+
+// addIndexIfMissing creates idx on its table unless an index with that name exists.
+func addIndexIfMissing(idx schemaIndex) error {
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`
+	if dialect == postgresDialect {
+		query = `SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND tablename = ? AND indexname = ?`
+	} else if dialect == sqliteDialect {
+		query = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?`
+	}
+	if err := db.QueryRow(query, idx.Table, idx.Name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec("CREATE INDEX " + idx.Name + " ON " + idx.Table + " (" + idx.Columns + ")")
+	return err
+}
+
+// allowDataReset enables truncateTables, set by ALLOW_DATA_RESET. It is off by
+// default so a stray request to /reset cannot wipe a production database.
+var allowDataReset bool
+
+// errDataResetDisabled is returned by truncateTables unless allowDataReset is set.
+var errDataResetDisabled = errors.New("data reset is disabled")
+
+// truncateTables removes all data from the tables in resetTables. Foreign key
+// checks are disabled on a dedicated connection because MySQL refuses to
+// truncate a table that is referenced by a foreign key; Postgres truncates
+// referencing tables together in one statement. SQLite has no TRUNCATE, so
+// its tables are emptied with DELETE.
+func truncateTables() error {
+	if !allowDataReset {
+		return errDataResetDisabled
+	}
+	ctx := context.Background()
+	if dialect == postgresDialect {
+		_, err := db.ExecContext(ctx, "TRUNCATE TABLE "+strings.Join(resetTables, ", "))
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	disable, enable, truncate := "SET FOREIGN_KEY_CHECKS = 0;", "SET FOREIGN_KEY_CHECKS = 1;", "TRUNCATE TABLE "
+	if dialect == sqliteDialect {
+		disable, enable, truncate = "PRAGMA foreign_keys = OFF;", "PRAGMA foreign_keys = ON;", "DELETE FROM "
+	}
+	if _, err := conn.ExecContext(ctx, disable); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, enable)
+	for _, table := range resetTables {
+		if _, err := conn.ExecContext(ctx, truncate+table+";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
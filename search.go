@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 20  // Default number of albums per page
+	maxPageLimit     = 100 // Maximum number of albums per page
+)
+
+// albumFilter holds the optional filters applied to album listing queries.
+// Artist and title use substring matching, the other fields use exact matching.
+type albumFilter struct {
+	Artist string
+	Title  string
+	Year   albumYear
+	Genre  string
+	Tag    string
+}
+
+// parseAlbumFilter reads the album filters from the query string.
+func parseAlbumFilter(c *gin.Context) (albumFilter, error) {
+	filter := albumFilter{
+		Artist: strings.TrimSpace(c.Query("artist")),
+		Title:  strings.TrimSpace(c.Query("title")),
+		Genre:  normalizeGenre(c.Query("genre")),
+		Tag:    normalizeTag(c.Query("tag")),
+	}
+	var err error
+	filter.Year, err = parseAlbumYear(c.Query("year"))
+	return filter, err
+}
+
+// conditions returns the SQL conditions and their arguments for the filter.
+func (f albumFilter) conditions() ([]string, []any) {
+	var conds []string
+	var args []any
+	if f.Artist != "" {
+		conds = append(conds, "artist "+dialect.like())
+		args = append(args, "%"+escapeLike(f.Artist)+"%")
+	}
+	if f.Title != "" {
+		conds = append(conds, "title "+dialect.like())
+		args = append(args, "%"+escapeLike(f.Title)+"%")
+	}
+	if f.Year != 0 {
+		conds = append(conds, "year = ?")
+		args = append(args, f.Year)
+	}
+	if f.Genre != "" {
+		conds = append(conds, "genre = ?")
+		args = append(args, f.Genre)
+	}
+	if f.Tag != "" {
+		conds = append(conds, `album_id IN (SELECT at.album_id FROM album_tags at
+			JOIN tags t ON t.tag_id = at.tag_id WHERE t.name = ?)`)
+		args = append(args, f.Tag)
+	}
+	return conds, args
+}
+
+// escapeLike escapes the LIKE wildcard characters in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// parsePagination reads the limit and offset query parameters.
+func parsePagination(c *gin.Context) (limit, offset int, err error) {
+	limit, offset = defaultPageLimit, 0
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// listAlbums handles GET /albums and GET /albums/search with artist, title,
+// year, genre and tag filters, a whitelisted sort order and offset or cursor
+// pagination. GET /albums?ids= fetches specific albums instead.
+func listAlbums(c *gin.Context) {
+	if _, ok := c.GetQuery("ids"); ok {
+		getAlbumsByIDs(c)
+		return
+	}
+
+	page, err := parseAlbumPage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	filter, err := parseAlbumFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest(err))
+		return
+	}
+
+	// Query the matching albums in the requested order.
+	albums, err := albumRepo.List(c.Request.Context(), filter, page)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to search albums"})
+		return
+	}
+	c.JSON(http.StatusOK, page.response(albums))
+}
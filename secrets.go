@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dbCredentials are the user and password the database is opened with, in
+// place of those of DB_DSN.
+type dbCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// secretStore fetches the database credentials from a secrets manager. It
+// returns how long they are valid for, or 0 when they do not expire.
+type secretStore interface {
+	fetch(ctx context.Context) (dbCredentials, time.Duration, error)
+}
+
+const (
+	secretFetchTimeout = 10 * time.Second
+	// minSecretFetchInterval is how often a failed connection may fetch the
+	// credentials again, so a database that is down does not flood the store.
+	minSecretFetchInterval = 10 * time.Second
+)
+
+// dbSecrets holds the latest credentials of the database when they are
+// fetched from a secrets manager, or is nil when DB_DSN has them.
+var dbSecrets *secretCredentials
+
+// secretCredentials keeps the credentials of a secretStore up to date. Every
+// new connection is opened with the latest credentials, and when they are
+// rotated the idle connections of the pools are closed, so the pools
+// reconnect without a restart. Connections in use are replaced when they
+// reach DB_CONN_MAX_LIFETIME, which should be shorter than the lease of
+// dynamic credentials.
+type secretCredentials struct {
+	store   secretStore
+	current atomic.Pointer[dbCredentials]
+
+	mu      sync.Mutex // Serializes fetches and guards the fields below
+	fetched time.Time
+	pools   []trackedPool
+}
+
+// trackedPool is a pool to reconnect on rotation with its idle connection
+// limit, which is restored once the idle connections are closed.
+type trackedPool struct {
+	db           *sql.DB
+	maxIdleConns int
+}
+
+// newDBSecrets fetches the credentials of the AWS Secrets Manager secret
+// DB_SECRET_ID, or of the Vault secret at DB_VAULT_PATH, and refreshes them
+// every DB_SECRET_REFRESH_INTERVAL in the background. It returns nil when
+// neither is set.
+func newDBSecrets(ctx context.Context) (*secretCredentials, error) {
+	secretID, vaultPath := os.Getenv("DB_SECRET_ID"), os.Getenv("DB_VAULT_PATH")
+	var store secretStore
+	switch {
+	case secretID != "" && vaultPath != "":
+		return nil, errors.New("DB_SECRET_ID and DB_VAULT_PATH cannot be combined")
+	case secretID != "":
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store = &awsSecretStore{client: secretsmanager.NewFromConfig(cfg), secretID: secretID}
+	case vaultPath != "":
+		addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return nil, errors.New("DB_VAULT_PATH needs VAULT_ADDR and VAULT_TOKEN")
+		}
+		store = &vaultSecretStore{addr: strings.TrimSuffix(addr, "/"), token: token,
+			namespace: os.Getenv("VAULT_NAMESPACE"), path: strings.Trim(vaultPath, "/")}
+	default:
+		return nil, nil
+	}
+	interval := getEnvDuration("DB_SECRET_REFRESH_INTERVAL", 5*time.Minute)
+	if interval <= 0 {
+		return nil, fmt.Errorf("DB_SECRET_REFRESH_INTERVAL must be positive, got %v", interval)
+	}
+
+	s := &secretCredentials{store: store}
+	ttl, err := s.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go s.refreshEvery(interval, ttl)
+	return s, nil
+}
+
+// refreshEvery refreshes the credentials every interval, or after two thirds
+// of their lease when it is shorter. Failed refreshes are retried within a
+// minute and the previous credentials are kept meanwhile.
+func (s *secretCredentials) refreshEvery(interval, ttl time.Duration) {
+	for {
+		wait := interval
+		if ttl > 0 {
+			wait = min(wait, ttl*2/3)
+		}
+		time.Sleep(wait)
+		var err error
+		ttl, err = s.refresh(context.Background())
+		for err != nil {
+			logger.Error().Err(err).Msg("Error refreshing database credentials")
+			time.Sleep(min(interval, time.Minute))
+			ttl, err = s.refresh(context.Background())
+		}
+	}
+}
+
+// refresh fetches the credentials and reconnects the pools when they changed.
+func (s *secretCredentials) refresh(ctx context.Context) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshLocked(ctx)
+}
+
+func (s *secretCredentials) refreshLocked(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretFetchTimeout)
+	defer cancel()
+	s.fetched = time.Now()
+	creds, ttl, err := s.store.fetch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if creds.Username == "" || creds.Password == "" {
+		return 0, errors.New("the secret has no username or password")
+	}
+	if old := s.current.Swap(&creds); old != nil && *old != creds {
+		logger.Info().Int("pools", len(s.pools)).Msg("Database credentials were rotated, reconnecting")
+		for _, pool := range s.pools {
+			pool.db.SetMaxIdleConns(0)
+			pool.db.SetMaxIdleConns(pool.maxIdleConns)
+		}
+	}
+	return ttl, nil
+}
+
+// credentials returns the latest credentials.
+func (s *secretCredentials) credentials() dbCredentials {
+	return *s.current.Load()
+}
+
+// refreshAfterFailure is called when a connection opened with creds failed;
+// the credentials may have been rotated since they were last fetched. It
+// reports whether newer credentials are available.
+func (s *secretCredentials) refreshAfterFailure(ctx context.Context, creds dbCredentials) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credentials() == creds && time.Since(s.fetched) >= minSecretFetchInterval {
+		if _, err := s.refreshLocked(ctx); err != nil {
+			logger.Error().Err(err).Msg("Error refreshing database credentials")
+		}
+	}
+	return s.credentials() != creds
+}
+
+// track reconnects pool when the credentials are rotated.
+func (s *secretCredentials) track(pool *sql.DB, maxIdleConns int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pools = append(s.pools, trackedPool{pool, maxIdleConns})
+}
+
+// credentialsConnector opens connections with the latest credentials of
+// dbSecrets, which connect applies to the configuration of DB_DSN.
+type credentialsConnector struct {
+	driver  driver.Driver
+	connect func(ctx context.Context, creds dbCredentials) (driver.Conn, error)
+}
+
+func (c credentialsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	creds := dbSecrets.credentials()
+	conn, err := c.connect(ctx, creds)
+	if err != nil && dbSecrets.refreshAfterFailure(ctx, creds) {
+		return c.connect(ctx, dbSecrets.credentials())
+	}
+	return conn, err
+}
+
+func (c credentialsConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// parseCredentials decodes a secret holding a username and a password, as
+// the secrets of Amazon RDS and the database engine of Vault do.
+func parseCredentials(data []byte) (dbCredentials, error) {
+	var creds dbCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return creds, errors.New("the secret is not a JSON object with a username and a password")
+	}
+	return creds, nil
+}
+
+// awsSecretStore reads the credentials from an AWS Secrets Manager secret,
+// using the standard AWS configuration chain.
+type awsSecretStore struct {
+	client   *secretsmanager.Client
+	secretID string
+}
+
+func (s *awsSecretStore) fetch(ctx context.Context) (dbCredentials, time.Duration, error) {
+	out, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(s.secretID)})
+	if err != nil {
+		return dbCredentials{}, 0, err
+	}
+	if out.SecretString == nil {
+		return dbCredentials{}, 0, errors.New("the secret has no string value")
+	}
+	creds, err := parseCredentials([]byte(*out.SecretString))
+	return creds, 0, err
+}
+
+// vaultSecretStore reads the credentials from a Vault secret with the token
+// VAULT_TOKEN, either from a KV engine or from the database engine, whose
+// credentials expire with their lease.
+type vaultSecretStore struct {
+	addr      string
+	token     string
+	namespace string
+	path      string
+}
+
+func (s *vaultSecretStore) fetch(ctx context.Context) (dbCredentials, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.addr+"/v1/"+s.path, nil)
+	if err != nil {
+		return dbCredentials{}, 0, err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return dbCredentials{}, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dbCredentials{}, 0, fmt.Errorf("reading Vault secret %s: %s", s.path, resp.Status)
+	}
+	var secret struct {
+		LeaseDuration int64           `json:"lease_duration"`
+		Data          json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return dbCredentials{}, 0, fmt.Errorf("decoding Vault secret %s: %w", s.path, err)
+	}
+	// Version 2 of the KV engine nests the secret under data with its metadata.
+	var kv struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	data := secret.Data
+	if json.Unmarshal(data, &kv) == nil && kv.Data != nil && kv.Metadata != nil {
+		data = kv.Data
+	}
+	creds, err := parseCredentials(data)
+	return creds, time.Duration(secret.LeaseDuration) * time.Second, err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rotatingStore returns the credentials in password, which the test rotates.
+type rotatingStore struct {
+	password string
+}
+
+func (s *rotatingStore) fetch(ctx context.Context) (dbCredentials, time.Duration, error) {
+	return dbCredentials{Username: "albums", Password: s.password}, 0, nil
+}
+
+func TestCredentialsConnectorRotation(t *testing.T) {
+	store := &rotatingStore{password: "old"}
+	dbSecrets = &secretCredentials{store: store}
+	defer func() { dbSecrets = nil }()
+	if _, err := dbSecrets.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database accepts only the rotated password, which the server has
+	// not fetched yet.
+	store.password = "new"
+	var used []string
+	connector := credentialsConnector{connect: func(ctx context.Context, creds dbCredentials) (driver.Conn, error) {
+		used = append(used, creds.Password)
+		if creds.Password != "new" {
+			return nil, errors.New("access denied")
+		}
+		return nil, nil
+	}}
+	dbSecrets.fetched = time.Time{}
+	if _, err := connector.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect after rotation: %v", err)
+	}
+	if len(used) != 2 || used[0] != "old" || used[1] != "new" {
+		t.Errorf("passwords tried = %v, want [old new]", used)
+	}
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	responses := map[string]string{
+		"/v1/database/creds/albums": `{"lease_duration":3600,"data":{"username":"v-albums","password":"dynamic"}}`,
+		"/v1/secret/data/albums":    `{"lease_duration":0,"data":{"data":{"username":"albums","password":"static"},"metadata":{"version":3}}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(responses[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		path string
+		want dbCredentials
+		ttl  time.Duration
+	}{
+		{"database/creds/albums", dbCredentials{"v-albums", "dynamic"}, time.Hour},
+		{"secret/data/albums", dbCredentials{"albums", "static"}, 0},
+	} {
+		store := &vaultSecretStore{addr: server.URL, token: "token", path: test.path}
+		creds, ttl, err := store.fetch(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if creds != test.want || ttl != test.ttl {
+			t.Errorf("%s: got %+v for %v, want %+v for %v", test.path, creds, ttl, test.want, test.ttl)
+		}
+	}
+}
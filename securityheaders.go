@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// securityConfig holds the security headers of every response, set by the
+// CONTENT_SECURITY_POLICY, REFERRER_POLICY, X_FRAME_OPTIONS, HSTS_MAX_AGE and
+// HSTS_INCLUDE_SUBDOMAINS environment variables. Empty headers are not sent.
+type securityConfig struct {
+	// ContentSecurityPolicy keeps HTML and SVG served by the API, such as a
+	// stored image opened in a browser, from running scripts or loading other
+	// resources.
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+	FrameOptions          string
+	// HSTSMaxAge is how long browsers must only use HTTPS for the host after
+	// a response over TLS, directly or through a load balancer. Zero disables
+	// Strict-Transport-Security.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+}
+
+// security is the security header configuration of the server.
+var security = securityConfig{
+	ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+	ReferrerPolicy:        "no-referrer",
+	FrameOptions:          "DENY",
+	HSTSMaxAge:            365 * 24 * time.Hour,
+}
+
+// getEnvHeader returns the header value of the environment variable key, def
+// when it is unset, or "" when it is "off".
+func getEnvHeader(key, def string) string {
+	if v := getEnv(key, def); !strings.EqualFold(v, "off") {
+		return v
+	}
+	return ""
+}
+
+// setSecurityHeaders is a middleware that adds the security headers to every
+// response, including those of other middleware that abort the request.
+func setSecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if security.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", security.ContentSecurityPolicy)
+		}
+		if security.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", security.ReferrerPolicy)
+		}
+		if security.FrameOptions != "" {
+			h.Set("X-Frame-Options", security.FrameOptions)
+		}
+		// Browsers ignore the header over plain HTTP, so a forged
+		// X-Forwarded-Proto does no harm.
+		if security.HSTSMaxAge > 0 && (c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")) {
+			hsts := "max-age=" + strconv.FormatInt(int64(security.HSTSMaxAge.Seconds()), 10)
+			if security.HSTSIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// seedBatchSize is the number of generated albums inserted per transaction.
+const seedBatchSize = 100
+
+// runSeedCommand handles "seed [-albums N] [-image-bytes N] [-workers N]" and
+// exits. It fills the database and the image store with generated albums, so
+// read benchmarks have data without running upload clients first: every album
+// has a JPEG cover of noise of about the given size, unique so it is not
+// deduplicated, and a profile with one of albums/10 artists, a year and an
+// allowed genre. Their images are pending, processed by the image workers of
+// the servers.
+func runSeedCommand(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	count := flags.Int("albums", 1000, "number of albums to create")
+	imageBytes := flags.Int("image-bytes", 100<<10, "approximate size of each cover in bytes")
+	workers := flags.Int("workers", runtime.NumCPU(), "number of albums generated in parallel")
+	flags.Parse(args)
+	if *count < 1 || *imageBytes < 1 || *workers < 1 {
+		log.Fatalf("seed: -albums, -image-bytes and -workers must be positive")
+	}
+
+	start := time.Now()
+	width, height := seedImageDimensions(*imageBytes)
+	created, err := seedAlbums(context.Background(), *count, width, height, *workers)
+	if err != nil {
+		log.Fatalf("Error seeding albums after %d albums: %v", created, err)
+	}
+	logger.Info().Int64("albums", created).Int("width", width).Int("height", height).Dur("took", time.Since(start)).Msg("Seeded albums")
+	os.Exit(0)
+}
+
+// seedAlbums generates and inserts count albums with covers of width by height
+// pixels, split between workers goroutines. It returns the number of albums
+// inserted, also when it stops at the first error.
+func seedAlbums(ctx context.Context, count, width, height, workers int) (int64, error) {
+	genres := make([]string, 0, len(allowedGenres))
+	for genre := range allowedGenres {
+		genres = append(genres, genre)
+	}
+	slices.Sort(genres)
+	artists := max(count/10, 1)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range count {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var created atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+			var batch []newAlbum
+			insert := func() {
+				if err := (sqlAlbumRepository{}).CreateBatch(ctx, batch); err != nil {
+					cancel(err)
+					return
+				}
+				if n := created.Add(int64(len(batch))); n/1000 != (n-int64(len(batch)))/1000 {
+					logger.Info().Int64("albums", n).Int("of", count).Msg("Seeding albums")
+				}
+				batch = batch[:0]
+			}
+			for i := range next {
+				profile := Profile{
+					Artist: fmt.Sprintf("Seed Artist %d", rng.IntN(artists)+1),
+					Title:  fmt.Sprintf("Seed Album %d", i+1),
+					Year:   albumYear(minAlbumYear + rng.IntN(maxAlbumYear()-minAlbumYear+1)),
+				}
+				if len(genres) > 0 {
+					profile.Genre = genres[rng.IntN(len(genres))]
+				}
+				imageData, err := seedImage(rng, width, height)
+				if err != nil {
+					cancel(err)
+					return
+				}
+				cover, err := storeImage(ctx, imageData)
+				if err != nil {
+					cancel(err)
+					return
+				}
+				batch = append(batch, newAlbum{AlbumID: uuid.New().String(), Image: cover, Profile: profile})
+				if len(batch) == seedBatchSize {
+					insert()
+				}
+			}
+			if len(batch) > 0 && ctx.Err() == nil {
+				insert()
+			}
+		}()
+	}
+	wg.Wait()
+	return created.Load(), context.Cause(ctx)
+}
+
+// seedImage encodes a JPEG of random noise over a random color, which barely
+// compresses, so its size follows its dimensions.
+func seedImage(rng *rand.Rand, width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	base := color.RGBA{uint8(rng.IntN(256)), uint8(rng.IntN(256)), uint8(rng.IntN(256)), 0xff}
+	for i := 0; i < len(img.Pix); i += 4 {
+		noise := rng.Uint32()
+		img.Pix[i] = base.R ^ uint8(noise)>>1
+		img.Pix[i+1] = base.G ^ uint8(noise>>8)>>1
+		img.Pix[i+2] = base.B ^ uint8(noise>>16)>>1
+		img.Pix[i+3] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// seedImageDimensions returns the dimensions, in a 1:1 ratio, of the covers
+// of seedImage closest to imageBytes, measured on sample covers.
+func seedImageDimensions(imageBytes int) (int, int) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	side := 256
+	for range 3 {
+		sample, err := seedImage(rng, side, side)
+		if err != nil {
+			break
+		}
+		perPixel := float64(len(sample)) / float64(side*side)
+		side = max(int(math.Sqrt(float64(imageBytes)/perPixel)), 8)
+	}
+	return side, side
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// imageURLSecret signs the expiring URLs of GET /albums/:albumID/image/url,
+// set by IMAGE_URL_SECRET. Signed URLs are disabled when it is empty. They
+// are valid for imageURLExpiry (IMAGE_URL_EXPIRY) unless the caller asks for
+// another duration, up to maxImageURLExpiry (IMAGE_URL_MAX_EXPIRY).
+var (
+	imageURLSecret    []byte
+	imageURLExpiry    = time.Hour
+	maxImageURLExpiry = 7 * 24 * time.Hour
+)
+
+// signedImageRoute is the route whose requests may carry the signature of an
+// expiring URL instead of credentials.
+const signedImageRoute = "/albums/:albumID/image"
+
+// signImageURL returns the hex-encoded HMAC-SHA256 of the album ID and the
+// Unix time the URL expires at. The thumbnail, size and format parameters are
+// not signed, as they select copies of the same image.
+func signImageURL(albumID string, expires int64) string {
+	mac := hmac.New(sha256.New, imageURLSecret)
+	mac.Write([]byte(albumID + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedImageRequest reports whether a request to the image route carries a
+// signature, which the handler verifies with verifyImageURL.
+func signedImageRequest(c *gin.Context) bool {
+	return c.FullPath() == signedImageRoute && c.Query("signature") != ""
+}
+
+// verifyImageURL checks the signature and expiry of a signed image URL. It
+// responds with 403 and returns false when they are not valid, and returns
+// true for unsigned requests, which are authorized by their credentials.
+func verifyImageURL(c *gin.Context, albumID string) bool {
+	if !signedImageRequest(c) {
+		return true
+	}
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if len(imageURLSecret) == 0 || err != nil ||
+		!hmac.Equal([]byte(c.Query("signature")), []byte(signImageURL(albumID, expires))) {
+		c.JSON(http.StatusForbidden, gin.H{"msg": "invalid image URL signature"})
+		return false
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusForbidden, gin.H{"msg": "image URL has expired"})
+		return false
+	}
+	return true
+}
+
+// createImageURL handles GET /albums/:albumID/image/url and returns a URL of
+// the cover image that works without credentials until it expires, so the
+// image can be shared or embedded in pages. ?expiresIn= sets how long it is
+// valid, such as 10m or 24h.
+func createImageURL(c *gin.Context) {
+	if len(imageURLSecret) == 0 {
+		c.JSON(http.StatusNotImplemented, gin.H{"msg": "signed image URLs require IMAGE_URL_SECRET"})
+		return
+	}
+	expiry := imageURLExpiry
+	if s := c.Query("expiresIn"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxImageURLExpiry {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: expiresIn must be a positive duration of at most " + maxImageURLExpiry.String()})
+			return
+		}
+		expiry = d
+	}
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	expiresAt := time.Now().Add(expiry).Truncate(time.Second).UTC()
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expiresAt.Unix(), 10)},
+		"signature": {signImageURL(albumID, expiresAt.Unix())},
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":       "/albums/" + url.PathEscape(albumID) + "/image?" + query.Encode(),
+		"expiresAt": expiresAt,
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"image"
+	"net/http"
+	"strconv"
+)
+
+// similarMaxDistance is the largest Hamming distance between the perceptual
+// hashes of two covers that are considered similar, set by
+// SIMILAR_MAX_DISTANCE.
+var similarMaxDistance = 10
+
+// warnSimilarImages makes POST /albums report existing albums whose cover is
+// similar to the uploaded one. It is set by WARN_SIMILAR_IMAGES.
+var warnSimilarImages bool
+
+// similarAlbum is an album returned by GET /albums/:albumID/similar with the
+// distance between its cover and the requested album's cover.
+type similarAlbum struct {
+	Album
+	Distance int `json:"distance"`
+}
+
+// differenceHash returns the 64-bit dHash of an image: the image is scaled to
+// 9x8 grayscale pixels and each bit records whether a pixel is darker than its
+// right neighbour. Resized, recompressed or slightly edited copies of an image
+// have hashes that differ in only a few bits.
+func differenceHash(src image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), src, src.Bounds(), draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// ensurePerceptualHash returns the perceptual hash of an album's cover,
+// computing and storing it when the cover has none yet. The cover colors are
+// stored along with the hash, so the cover is decoded only once. It reports
+// false when the album has no cover or only a generated placeholder, which
+// would make all placeholders look alike. Covers that cannot be decoded fail
+// with image.ErrFormat.
+func ensurePerceptualHash(ctx context.Context, albumID string) (uint64, bool, error) {
+	var hash sql.Null[int64]
+	var key, label sql.NullString
+	query := `SELECT a.image_phash, i.storage_key, i.label FROM albums a
+		LEFT JOIN album_images i ON i.album_id = a.album_id AND i.is_primary WHERE a.album_id = ?`
+	if err := db.QueryRowContext(ctx, query, albumID).Scan(&hash, &key, &label); err != nil {
+		return 0, false, err
+	}
+	if hash.Valid {
+		return uint64(hash.V), true, nil
+	}
+	if !key.Valid || label.String == placeholderImageLabel {
+		return 0, false, nil
+	}
+
+	imageData, err := imageStore.Get(ctx, key.String)
+	if err != nil {
+		return 0, false, err
+	}
+	src, err := decodeImage(imageData)
+	if err != nil {
+		return 0, false, err
+	}
+	// The hash is stored in a signed BIGINT column, which every database has.
+	hash.V = int64(differenceHash(src))
+	colors := extractColors(src)
+	query = `UPDATE albums SET image_phash = ?, dominant_color = ?, average_color = ? WHERE album_id = ?`
+	if _, err := db.ExecContext(ctx, query, hash.V, colors.Dominant, colors.Average, albumID); err != nil {
+		return 0, false, err
+	}
+	invalidateAlbum(ctx, albumID)
+	return uint64(hash.V), true, nil
+}
+
+// findSimilarAlbums returns up to limit albums other than albumID whose cover
+// hash is within maxDistance bits of hash, closest first. Every hashed album
+// is compared, which is fine for catalogues of up to a few hundred thousand
+// albums.
+func findSimilarAlbums(ctx context.Context, albumID string, hash uint64, maxDistance, limit int) ([]similarAlbum, error) {
+	query := `SELECT * FROM (SELECT ` + albumColumns + `, ` + dialect.hammingDistance("image_phash") + ` AS distance
+		FROM albums WHERE image_phash IS NOT NULL AND album_id <> ? AND deleted_at IS NULL) candidates
+		WHERE distance <= ? ORDER BY distance, album_id LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, int64(hash), albumID, maxDistance, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	albums := []similarAlbum{}
+	for rows.Next() {
+		var album similarAlbum
+		if err := rows.Scan(&album.AlbumID, &album.Artist, &album.Title, &album.Year, &album.Genre, &album.CreatedAt,
+			&album.ImageWidth, &album.ImageHeight, &album.Distance); err != nil {
+			return nil, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+// similarAlbumIDs returns the IDs of albums whose cover is similar to the
+// cover of albumID, for the upload warning of POST /albums. Covers that
+// cannot be decoded have no similar albums.
+func similarAlbumIDs(ctx context.Context, albumID string) ([]string, error) {
+	hash, ok, err := ensurePerceptualHash(ctx, albumID)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil
+	} else if err != nil || !ok {
+		return nil, err
+	}
+	albums, err := findSimilarAlbums(ctx, albumID, hash, similarMaxDistance, defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(albums))
+	for i, album := range albums {
+		ids[i] = album.AlbumID
+	}
+	return ids, nil
+}
+
+// getSimilarAlbums handles GET /albums/:albumID/similar and returns the albums
+// with a visually similar cover. The optional maxDistance parameter (0-64)
+// overrides SIMILAR_MAX_DISTANCE.
+func getSimilarAlbums(c *gin.Context) {
+	albumID := c.Param("albumID")
+	limit, _, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+	maxDistance := similarMaxDistance
+	if v := c.Query("maxDistance"); v != "" {
+		maxDistance, err = strconv.Atoi(v)
+		if err != nil || maxDistance < 0 || maxDistance > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: maxDistance must be between 0 and 64"})
+			return
+		}
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	hash, ok, err := ensurePerceptualHash(ctx, albumID)
+	if errors.Is(err, image.ErrFormat) || (err == nil && !ok) {
+		c.JSON(http.StatusOK, gin.H{"albumID": albumID, "albums": []similarAlbum{}})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve similar albums"})
+		return
+	}
+	albums, err := findSimilarAlbums(ctx, albumID, hash, maxDistance, limit)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve similar albums"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "albums": albums})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// slowQueryThreshold is the duration above which database calls are logged
+// with their statement and arguments, and counted by
+// albumserver_db_slow_queries_total, set by DB_SLOW_QUERY_THRESHOLD. Slow
+// queries are not tracked when it is 0. Their arguments are left out of the
+// logs when logSlowQueryArgs (DB_SLOW_QUERY_LOG_ARGS) is false.
+var (
+	slowQueryThreshold time.Duration
+	logSlowQueryArgs   = true
+)
+
+const maxLoggedArgLength = 64 // Longest string argument logged as is
+
+// logSlowQuery logs and counts a database call that started at start when it
+// took longer than slowQueryThreshold. The time taken by a query does not
+// include reading its rows.
+func logSlowQuery(ctx context.Context, query string, args []driver.NamedValue, start time.Time) {
+	took := time.Since(start)
+	if took < slowQueryThreshold {
+		return
+	}
+	slowQueries.Inc()
+	event := logFor(ctx).Warn().Dur("duration", took).Str("query", strings.Join(strings.Fields(query), " "))
+	if logSlowQueryArgs {
+		event = event.Strs("args", sanitizeArgs(args))
+	}
+	event.Msg("Slow database query")
+}
+
+// sanitizeArgs formats the arguments of a query for the logs. Binary values
+// and long strings, such as images and hashed tokens, are replaced by their
+// length.
+func sanitizeArgs(args []driver.NamedValue) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.Value.(type) {
+		case nil:
+			values[i] = "NULL"
+		case []byte:
+			values[i] = fmt.Sprintf("<%d bytes>", len(v))
+		case string:
+			if len(v) > maxLoggedArgLength {
+				values[i] = fmt.Sprintf("<%d characters>", len(v))
+			} else {
+				values[i] = v
+			}
+		case time.Time:
+			values[i] = v.Format(time.RFC3339Nano)
+		default:
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
+
+// openConnector returns a connector of the driver registered as driverName,
+// for sqlOpen to wrap.
+func openConnector(driverName, dsn string) (driver.Connector, error) {
+	pool, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := pool.Driver()
+	pool.Close()
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return dsnConnector{d, dsn}, nil
+}
+
+// dsnConnector opens connections of a driver without its own connector.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// slowQueryConnector opens connections whose slow calls are logged.
+type slowQueryConnector struct {
+	driver.Connector
+}
+
+func (c slowQueryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slowQueryConn{conn}, nil
+}
+
+// slowQueryConn times the queries and executions of a connection, and passes
+// the optional interfaces of database/sql through to the driver.
+type slowQueryConn struct {
+	driver.Conn
+}
+
+func (c slowQueryConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c slowQueryConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return slowQueryStmt{stmt, query}, nil
+}
+
+func (c slowQueryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer logSlowQuery(ctx, query, args, time.Now())
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c slowQueryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer logSlowQuery(ctx, query, args, time.Now())
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c slowQueryConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c slowQueryConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c slowQueryConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c slowQueryConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c slowQueryConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// slowQueryStmt times the calls of a prepared statement.
+type slowQueryStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s slowQueryStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer logSlowQuery(ctx, s.query, args, time.Now())
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values)
+}
+
+func (s slowQueryStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	defer logSlowQuery(ctx, s.query, args, time.Now())
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values)
+}
+
+func (s slowQueryStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// namedValues returns the values of positional arguments, for drivers that
+// only take those.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("the database driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// statementKey identifies a statement prepared on one of the databases.
+type statementKey struct {
+	db    *sql.DB
+	query string
+}
+
+// preparedStatements caches the statements returned by prepared.
+var preparedStatements sync.Map
+
+// prepared returns a statement for query on database that is prepared once
+// and reused by later calls, so queries on hot paths are not parsed again for
+// every request. database/sql prepares it again by itself on each connection
+// it runs on.
+func prepared(ctx context.Context, database *sql.DB, query string) (*sql.Stmt, error) {
+	key := statementKey{database, query}
+	if stmt, ok := preparedStatements.Load(key); ok {
+		return stmt.(*sql.Stmt), nil
+	}
+	stmt, err := database.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if existing, loaded := preparedStatements.LoadOrStore(key, stmt); loaded {
+		// Another request prepared the same query first.
+		stmt.Close()
+		return existing.(*sql.Stmt), nil
+	}
+	return stmt, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// uploadRate counts album uploads handled by this process in per-minute
+// buckets covering the last hour.
+type uploadRate struct {
+	mu      sync.Mutex
+	buckets [60]int64
+	minutes [60]int64 // Unix minute each bucket was last written for
+	total   int64
+}
+
+// uploads tracks the uploads handled by this process.
+var uploads uploadRate
+
+// record adds n uploads to the bucket of the current minute.
+func (r *uploadRate) record(n int64) {
+	minute := time.Now().Unix() / 60
+	i := minute % 60
+	r.mu.Lock()
+	if r.minutes[i] != minute {
+		r.minutes[i] = minute
+		r.buckets[i] = 0
+	}
+	r.buckets[i] += n
+	r.total += n
+	r.mu.Unlock()
+}
+
+// lastHour returns the number of uploads in the last 60 minutes and since start.
+func (r *uploadRate) lastHour() (hour, total int64) {
+	minute := time.Now().Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.buckets {
+		if minute-r.minutes[i] < 60 {
+			hour += r.buckets[i]
+		}
+	}
+	return hour, r.total
+}
+
+// adminStats handles GET /admin/stats and summarizes the stored albums.
+func adminStats(c *gin.Context) {
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	var albums, imageBytes, dbLastHour int64
+	query := `SELECT COUNT(*), COALESCE(SUM(image_size), 0),
+		COUNT(CASE WHEN created_at >= ` + dialect.secondsFromNow() + ` THEN 1 END) FROM albums WHERE deleted_at IS NULL`
+	if err := readDB(ctx).QueryRowContext(ctx, query, -int64(time.Hour/time.Second)).Scan(&albums, &imageBytes, &dbLastHour); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+		return
+	}
+
+	// Count the albums released in each year.
+	perYear := map[string]int64{}
+	rows, err := readDB(ctx).QueryContext(ctx, `SELECT year, COUNT(*) FROM albums WHERE deleted_at IS NULL GROUP BY year ORDER BY year`)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var year albumYear
+		var count int64
+		if err := rows.Scan(&year, &count); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+			return
+		}
+		perYear[year.String()] = count
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+		return
+	}
+
+	// Find the largest stored images.
+	type largestImage struct {
+		AlbumID   string `json:"albumID"`
+		ImageSize int64  `json:"imageSize"`
+	}
+	largest := []largestImage{}
+	rows, err = readDB(ctx).QueryContext(ctx, `SELECT album_id, image_size FROM albums WHERE deleted_at IS NULL ORDER BY image_size DESC LIMIT 10`)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var image largestImage
+		if err := rows.Scan(&image.AlbumID, &image.ImageSize); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+			return
+		}
+		largest = append(largest, image)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to compute stats"})
+		return
+	}
+
+	instanceLastHour, instanceTotal := uploads.lastHour()
+	c.JSON(http.StatusOK, gin.H{
+		"albums":        albums,
+		"imageBytes":    imageBytes,
+		"albumsPerYear": perYear,
+		"largestImages": largest,
+		"uploads": gin.H{
+			"lastHour":         dbLastHour,
+			"instanceLastHour": instanceLastHour,
+			"instanceTotal":    instanceTotal,
+		},
+		"dbRetries": gin.H{
+			"retried":   dbRetries.retried.Load(),
+			"exhausted": dbRetries.exhausted.Load(),
+		},
+	})
+}
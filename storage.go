@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errImageNotFound is returned by an ImageStore when no object has the key.
+var errImageNotFound = errors.New("image not found")
+
+// ImageStore persists image bytes under a key. Album metadata lives in the
+// database and refers to images only by their key, so the bytes can be kept
+// in the database or in an object store. Put reads the image from r until EOF
+// and fails without storing anything when r returns an error.
+type ImageStore interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// imageStore is the ImageStore selected by the IMAGE_STORE environment variable.
+var imageStore ImageStore
+
+// newImageStore returns the ImageStore for the IMAGE_STORE environment
+// variable: "db" (default) keeps images in MySQL, "fs" in a local directory,
+// "s3" in an S3 bucket, "gcs" in a Google Cloud Storage bucket and "azure" in
+// an Azure Blob Storage container.
+func newImageStore(ctx context.Context) (ImageStore, error) {
+	switch backend := getEnv("IMAGE_STORE", "db"); backend {
+	case "db":
+		return newDBImageStore(ctx)
+	case "fs":
+		return newFSImageStore()
+	case "s3":
+		return newS3ImageStore(ctx)
+	case "gcs":
+		return newGCSImageStore(ctx)
+	case "azure":
+		return newAzureImageStore()
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORE %q", backend)
+	}
+}
+
+// deleteImages removes the objects with the given keys and their thumbnails,
+// logging failures. It is used after the metadata referring to the keys has
+// been deleted, so a failure only leaves an unreferenced object behind.
+func deleteImages(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := deleteImageVariants(ctx, key); err != nil {
+			logFor(ctx).Error().Err(err).Str("key", key).Msg("Error deleting thumbnails of image")
+		}
+		if err := imageStore.Delete(ctx, key); err != nil && !errors.Is(err, errImageNotFound) {
+			logFor(ctx).Error().Err(err).Str("key", key).Msg("Error deleting image")
+		}
+	}
+}
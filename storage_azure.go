@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"io"
+)
+
+// azureImageStore keeps image bytes as blobs in an Azure Blob Storage container.
+type azureImageStore struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+// newAzureImageStore configures an Azure store from AZURE_STORAGE_CONTAINER
+// and AZURE_STORAGE_PREFIX. It authenticates with
+// AZURE_STORAGE_CONNECTION_STRING when set, and otherwise with the default
+// Azure credential chain against the AZURE_STORAGE_ACCOUNT account.
+func newAzureImageStore() (*azureImageStore, error) {
+	container := getEnv("AZURE_STORAGE_CONTAINER", "")
+	if container == "" {
+		return nil, errors.New("AZURE_STORAGE_CONTAINER environment variable is not set")
+	}
+
+	var client *azblob.Client
+	if connectionString := getEnv("AZURE_STORAGE_CONNECTION_STRING", ""); connectionString != "" {
+		var err error
+		if client, err = azblob.NewClientFromConnectionString(connectionString, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		account := getEnv("AZURE_STORAGE_ACCOUNT", "")
+		if account == "" {
+			return nil, errors.New("AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING environment variable is not set")
+		}
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+		if client, err = azblob.NewClient("https://"+account+".blob.core.windows.net/", cred, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &azureImageStore{client: client, container: container, prefix: getEnv("AZURE_STORAGE_PREFIX", "albums/")}, nil
+}
+
+// Put uploads the image as a block blob one block at a time.
+func (s *azureImageStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	_, err := s.client.UploadStream(ctx, s.container, s.prefix+key, r, &azblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+	})
+	return err
+}
+
+func (s *azureImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.client.DownloadStream(ctx, s.container, s.prefix+key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, errImageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var size int64
+	if resp.ContentLength != nil {
+		size = *resp.ContentLength
+	}
+	return readImage(resp.Body, size)
+}
+
+func (s *azureImageStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteBlob(ctx, s.container, s.prefix+key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return errImageNotFound
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"os"
+	"sync"
+)
+
+// dbImageStore keeps image bytes in the image_chunks table, in chunks of up
+// to dbImageChunkSize bytes numbered by seq. Images written before chunking
+// are read from the image_blobs table.
+type dbImageStore struct{}
+
+// dbImageChunkSize is the size of the chunks images are written in, set by
+// DB_IMAGE_CHUNK_BYTES, so an image is never held whole in memory or sent to
+// the database in a single packet.
+var dbImageChunkSize = 1 << 20
+
+// chunkPacketOverhead is the room left in a MySQL packet for the rest of the
+// insert of a chunk: the statement and the key.
+const chunkPacketOverhead = 4 << 10
+
+var dbChunks = sync.Pool{New: func() any { buf := make([]byte, dbImageChunkSize); return &buf }}
+
+// newDBImageStore reads DB_IMAGE_CHUNK_BYTES and, on MySQL, checks that a
+// chunk fits in the max_allowed_packet of the server and in the
+// maxAllowedPacket of the DSN, which are the largest packets they accept.
+func newDBImageStore(ctx context.Context) (dbImageStore, error) {
+	dbImageChunkSize = getEnvInt("DB_IMAGE_CHUNK_BYTES", dbImageChunkSize)
+	if dbImageChunkSize <= 0 {
+		return dbImageStore{}, fmt.Errorf("DB_IMAGE_CHUNK_BYTES must be positive, got %d", dbImageChunkSize)
+	}
+	if dialect != mysqlDialect {
+		return dbImageStore{}, nil
+	}
+	var maxPacket int
+	if err := db.QueryRowContext(ctx, `SELECT @@max_allowed_packet`).Scan(&maxPacket); err != nil {
+		return dbImageStore{}, err
+	}
+	cfg, err := mysql.ParseDSN(os.Getenv("DB_DSN"))
+	if err != nil {
+		return dbImageStore{}, err
+	}
+	if cfg.MaxAllowedPacket > 0 {
+		maxPacket = min(maxPacket, cfg.MaxAllowedPacket)
+	}
+	if dbImageChunkSize+chunkPacketOverhead > maxPacket {
+		return dbImageStore{}, fmt.Errorf("DB_IMAGE_CHUNK_BYTES of %d does not fit in MySQL packets of %d bytes: lower it, or raise max_allowed_packet on the server and maxAllowedPacket in DB_DSN",
+			dbImageChunkSize, maxPacket)
+	}
+	return dbImageStore{}, nil
+}
+
+// Put inserts the image a chunk at a time, replacing an image stored under
+// the same key. The chunks are not inserted in a transaction, so no connection
+// is held while a slow upload arrives: the key is only referenced once Put
+// returns, and the chunks of a failed Put are deleted again.
+func (s dbImageStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if err := s.Delete(ctx, key); err != nil {
+		return err
+	}
+	insert, err := prepared(ctx, db, `INSERT INTO image_chunks (blob_key, seq, data) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	buf := dbChunks.Get().(*[]byte)
+	defer dbChunks.Put(buf)
+	for seq := 0; ; seq++ {
+		n, readErr := io.ReadFull(r, *buf)
+		if readErr == io.EOF && seq > 0 {
+			return nil
+		} else if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			s.Delete(context.WithoutCancel(ctx), key)
+			return readErr
+		}
+		if _, err := insert.ExecContext(ctx, key, seq, (*buf)[:n]); err != nil {
+			s.Delete(context.WithoutCancel(ctx), key)
+			return err
+		}
+		if readErr != nil {
+			return nil
+		}
+	}
+}
+
+// Get joins the chunks of the image into a slice allocated once for their
+// total size.
+func (dbImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var chunks int
+	var size int64
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(LENGTH(data)), 0) FROM image_chunks WHERE blob_key = ?`, key).
+		Scan(&chunks, &size)
+	if err != nil {
+		return nil, err
+	} else if chunks == 0 {
+		return getImageBlob(ctx, key)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT data FROM image_chunks WHERE blob_key = ? ORDER BY seq`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	data := make([]byte, 0, size)
+	for rows.Next() {
+		var chunk sql.RawBytes
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+	return data, rows.Err()
+}
+
+// getImageBlob reads an image written in one row of image_blobs.
+func getImageBlob(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, `SELECT data FROM image_blobs WHERE blob_key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errImageNotFound
+	}
+	return data, err
+}
+
+func (dbImageStore) Delete(ctx context.Context, key string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM image_chunks WHERE blob_key = ?`, key); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM image_blobs WHERE blob_key = ?`, key)
+	return err
+}
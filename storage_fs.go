@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fsImageStore keeps image bytes as files below a data directory. Files are
+// sharded into two levels of subdirectories named after the first characters
+// of the key, so no single directory grows too large.
+type fsImageStore struct {
+	dir string
+}
+
+// newFSImageStore creates the IMAGE_DIR data directory (default "data/images")
+// and returns a store writing below it.
+func newFSImageStore() (*fsImageStore, error) {
+	dir := getEnv("IMAGE_DIR", filepath.Join("data", "images"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fsImageStore{dir: dir}, nil
+}
+
+// path returns the file path of key. Keys are generated by the server, but
+// the base name is still cleaned so a key can never leave the data directory.
+func (s *fsImageStore) path(key string) string {
+	name := filepath.Base(filepath.Clean("/" + key))
+	shard1, shard2 := "_", "_"
+	if len(name) >= 4 {
+		shard1, shard2 = name[0:2], name[2:4]
+	}
+	return filepath.Join(s.dir, shard1, shard2, name)
+}
+
+// Put writes the image to a temporary file and renames it into place, so
+// readers never see a partially written image.
+func (s *fsImageStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := copyImage(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fsImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errImageNotFound
+	}
+	return data, err
+}
+
+func (s *fsImageStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errImageNotFound
+	}
+	return err
+}
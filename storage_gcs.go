@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"io"
+)
+
+// gcsImageStore keeps image bytes as objects in a Google Cloud Storage bucket.
+type gcsImageStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// newGCSImageStore configures a GCS store from GCS_BUCKET and GCS_PREFIX.
+// Credentials come from the Application Default Credentials chain, e.g. the
+// file named by GOOGLE_APPLICATION_CREDENTIALS.
+func newGCSImageStore(ctx context.Context) (*gcsImageStore, error) {
+	bucket := getEnv("GCS_BUCKET", "")
+	if bucket == "" {
+		return nil, errors.New("GCS_BUCKET environment variable is not set")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsImageStore{bucket: client.Bucket(bucket), prefix: getEnv("GCS_PREFIX", "albums/")}, nil
+}
+
+// Put uploads the image in chunks of the writer's ChunkSize. Cancelling the
+// context aborts the upload when reading r fails.
+func (s *gcsImageStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := s.bucket.Object(s.prefix + key).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := copyImage(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *gcsImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.bucket.Object(s.prefix + key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, errImageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readImage(r, r.Attrs.Size)
+}
+
+func (s *gcsImageStore) Delete(ctx context.Context, key string) error {
+	err := s.bucket.Object(s.prefix + key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errImageNotFound
+	}
+	return err
+}
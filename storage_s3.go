@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"io"
+	"strconv"
+	"time"
+)
+
+// s3ImageStore keeps image bytes as objects in an S3 bucket.
+type s3ImageStore struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+// newS3ImageStore configures an S3 store from S3_BUCKET, S3_PREFIX and the
+// standard AWS configuration chain. S3_ENDPOINT points the client at an
+// S3-compatible service such as MinIO, using path-style addressing.
+func newS3ImageStore(ctx context.Context) (*s3ImageStore, error) {
+	bucket := getEnv("S3_BUCKET", "")
+	if bucket == "" {
+		return nil, errors.New("S3_BUCKET environment variable is not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint := getEnv("S3_ENDPOINT", ""); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	// Upload one part at a time so each upload buffers at most one part.
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = manager.MinUploadPartSize
+		u.Concurrency = 1
+	})
+	return &s3ImageStore{client: client, uploader: uploader, bucket: bucket, prefix: getEnv("S3_PREFIX", "albums/")}, nil
+}
+
+// Put uploads images of unknown length as a multipart upload, which is
+// aborted when reading r fails.
+func (s *s3ImageStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *s3ImageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, errImageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return readImage(out.Body, aws.ToInt64(out.ContentLength))
+}
+
+func (s *s3ImageStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
+
+// presignPut returns a URL that lets a client upload the object for key with
+// a PUT request of the given content type and length until the URL expires.
+// Both are signed, so S3 rejects uploads that declare anything else.
+func (s *s3ImageStore) presignPut(ctx context.Context, key, contentType string, contentLength int64, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.prefix + key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(contentLength),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// readHead returns up to the first n bytes of the object for key.
+func (s *s3ImageStore) readHead(ctx context.Context, key string, n int) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Range:  aws.String("bytes=0-" + strconv.Itoa(n-1)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, errImageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(io.LimitReader(out.Body, int64(n)))
+}
+
+// stat returns the size and content type of the object for key.
+func (s *s3ImageStore) stat(ctx context.Context, key string) (int64, string, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return 0, "", errImageNotFound
+	} else if err != nil {
+		return 0, "", err
+	}
+	return aws.ToInt64(out.ContentLength), aws.ToString(out.ContentType), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxTagLength      = 64 // Maximum length of a tag name
+	maxTagsPerRequest = 20 // Maximum number of tags in one request
+)
+
+// tagsRequest is the JSON body accepted by the tag endpoints.
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// normalizeTag trims and lowercases a tag name so tags match case-insensitively.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// bindTags parses and validates the tag names in the request body.
+func bindTags(c *gin.Context) ([]string, bool) {
+	var req tagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return nil, false
+	}
+	if len(req.Tags) == 0 || len(req.Tags) > maxTagsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: between 1 and " + strconv.Itoa(maxTagsPerRequest) + " tags are required"})
+		return nil, false
+	}
+	tags := make([]string, 0, len(req.Tags))
+	for _, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		if tag == "" || len(tag) > maxTagLength {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: tags must be between 1 and " + strconv.Itoa(maxTagLength) + " characters"})
+			return nil, false
+		}
+		tags = append(tags, tag)
+	}
+	return tags, true
+}
+
+// respondTags writes the current tags of an album.
+func respondTags(c *gin.Context, status int, albumID string) {
+	tags, err := albumRepo.Tags(c.Request.Context(), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve tags"})
+		return
+	}
+	c.JSON(status, gin.H{"albumID": albumID, "tags": tags})
+}
+
+// getTags handles GET /albums/:albumID/tags and lists the tags of an album.
+func getTags(c *gin.Context) {
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+	respondTags(c, http.StatusOK, albumID)
+}
+
+// addTags handles POST /albums/:albumID/tags and attaches tags to an album,
+// creating tags that do not exist yet.
+func addTags(c *gin.Context) {
+	albumID := c.Param("albumID")
+	tags, ok := bindTags(c)
+	if !ok || !requireAlbum(c, albumID) {
+		return
+	}
+
+	if err := albumRepo.AddTags(c.Request.Context(), albumID, tags); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist tags"})
+		return
+	}
+	auditDetail(c, "tags", tags)
+	respondTags(c, http.StatusOK, albumID)
+}
+
+// removeTags handles DELETE /albums/:albumID/tags and detaches tags from an album.
+func removeTags(c *gin.Context) {
+	albumID := c.Param("albumID")
+	tags, ok := bindTags(c)
+	if !ok || !requireAlbum(c, albumID) {
+		return
+	}
+
+	if err := albumRepo.RemoveTags(c.Request.Context(), albumID, tags); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to remove tags"})
+		return
+	}
+	auditDetail(c, "tags", tags)
+	respondTags(c, http.StatusOK, albumID)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	"image"
+	_ "image/gif" // Register the GIF decoder
+	_ "image/png" // Register the PNG decoder
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// thumbnailSize is a named thumbnail variant whose longest side is at most
+// Max pixels.
+type thumbnailSize struct {
+	Name string
+	Max  int
+}
+
+// thumbnailSizes are the thumbnails generated for every stored image, set by
+// THUMBNAIL_SIZES as comma-separated name=pixels pairs (e.g. "small=150").
+var thumbnailSizes = []thumbnailSize{{"small", 150}, {"medium", 600}}
+
+// parseThumbnailSizes parses name=pixels pairs such as "small=150".
+func parseThumbnailSizes(specs []string) ([]thumbnailSize, error) {
+	var sizes []thumbnailSize
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		pixels, err := strconv.Atoi(value)
+		if !ok || name == "" || err != nil || pixels < 1 {
+			return nil, errors.New("invalid thumbnail size " + spec)
+		}
+		sizes = append(sizes, thumbnailSize{Name: name, Max: pixels})
+	}
+	return sizes, nil
+}
+
+// findThumbnailSize returns the thumbnail size with the given name.
+func findThumbnailSize(name string) (thumbnailSize, bool) {
+	for _, size := range thumbnailSizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return thumbnailSize{}, false
+}
+
+// ensureThumbnail returns the storage key of the thumbnail of the image stored
+// under sourceKey in the given output format, generating it when it does not
+// exist yet.
+func ensureThumbnail(ctx context.Context, sourceKey string, size thumbnailSize, format string) (string, error) {
+	return ensureVariant(ctx, sourceKey, variantName(size.Name, format), func(imageData []byte) ([]byte, error) {
+		return makeThumbnail(imageData, size.Max, format)
+	})
+}
+
+// ensureVariant returns the storage key of the named variant of the image
+// stored under sourceKey. A missing variant is rendered from the source image
+// and stored, so it is only rendered once. Rendering fails with
+// image.ErrFormat when the source image cannot be decoded.
+func ensureVariant(ctx context.Context, sourceKey, variant string, render func([]byte) ([]byte, error)) (string, error) {
+	var key string
+	query := `SELECT storage_key FROM image_variants WHERE source_key = ? AND variant = ?`
+	err := db.QueryRowContext(ctx, query, sourceKey, variant).Scan(&key)
+	if err == nil {
+		return key, nil
+	} else if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	source, err := imageStore.Get(ctx, sourceKey)
+	if err != nil {
+		return "", err
+	}
+	rendered, err := render(source)
+	if err != nil {
+		return "", err
+	}
+	key = uuid.New().String()
+	if err := imageStore.Put(ctx, key, bytes.NewReader(rendered), detectImageType(rendered)); err != nil {
+		return "", err
+	}
+
+	// A concurrent request may have stored the same variant first.
+	query = dialect.insertIgnore(`INSERT INTO image_variants (source_key, variant, storage_key, image_size) VALUES (?, ?, ?, ?)`)
+	result, err := db.ExecContext(ctx, query, sourceKey, variant, key, len(rendered))
+	if err != nil {
+		imageStore.Delete(ctx, key)
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		imageStore.Delete(ctx, key)
+		return ensureVariant(ctx, sourceKey, variant, render)
+	}
+	return key, nil
+}
+
+// makeThumbnail decodes an image and encodes it in the given output format
+// with its longest side at most maxSide pixels.
+func makeThumbnail(imageData []byte, maxSide int, format string) ([]byte, error) {
+	src, err := decodeImage(imageData)
+	if err != nil {
+		return nil, err
+	}
+	width, height := fitWithin(src.Bounds(), maxSide, maxSide)
+	dst := newCanvas(width, height)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+	return encodeImage(dst, format)
+}
+
+// maxImagePixels is the largest width x height of an image that is decoded,
+// set by MAX_IMAGE_PIXELS. A small file can declare huge dimensions, so the
+// limit keeps such images from exhausting memory when they are decoded.
+var maxImagePixels = 50_000_000
+
+// decodeImage decodes an image in any registered format. Images that cannot
+// be decoded or have more than maxImagePixels pixels fail with
+// image.ErrFormat.
+func decodeImage(imageData []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", image.ErrFormat, err)
+	}
+	if int64(config.Width)*int64(config.Height) > int64(maxImagePixels) {
+		return nil, fmt.Errorf("%w: %dx%d image exceeds %d pixels", image.ErrFormat, config.Width, config.Height, maxImagePixels)
+	}
+	src, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", image.ErrFormat, err)
+	}
+	return src, nil
+}
+
+// fitWithin returns the size of bounds scaled down to fit within maxWidth x
+// maxHeight with its aspect ratio preserved. Smaller images keep their size.
+func fitWithin(bounds image.Rectangle, maxWidth, maxHeight int) (int, int) {
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxWidth {
+		width, height = maxWidth, height*maxWidth/width
+	}
+	if height > maxHeight {
+		width, height = width*maxHeight/height, maxHeight
+	}
+	return max(width, 1), max(height, 1)
+}
+
+// newCanvas returns a white RGBA image, so transparent areas of the image
+// drawn onto it are rendered on white.
+func newCanvas(width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	return dst
+}
+
+// deleteImageVariants removes the thumbnails generated from the image stored
+// under sourceKey.
+func deleteImageVariants(ctx context.Context, sourceKey string) error {
+	rows, err := db.QueryContext(ctx, `SELECT storage_key FROM image_variants WHERE source_key = ?`, sourceKey)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if thumbnailCache != nil {
+		thumbnailCache.remove(keys...)
+	}
+	for _, key := range keys {
+		if err := imageStore.Delete(ctx, key); err != nil && !errors.Is(err, errImageNotFound) {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM image_variants WHERE source_key = ?`, sourceKey)
+	return err
+}
+
+// serveImageVariant serves the image stored under key, or the variant selected
+// by the request: a thumbnail with ?size=, a resized image with ?w= and ?h=,
+// and another output format with ?format= or the Accept header. Images that
+// cannot be decoded are served as stored.
+func serveImageVariant(c *gin.Context, key string) {
+	format, err := negotiateFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var variantKey string
+	if name := c.Query("size"); name != "" && name != "original" {
+		size, ok := findThumbnailSize(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: unknown size '" + name + "'"})
+			return
+		}
+		variantKey, err = ensureThumbnail(ctx, key, size, formatOrDefault(format))
+		if err == nil {
+			serveThumbnail(c, variantKey)
+			return
+		}
+	} else if c.Query("w") != "" || c.Query("h") != "" {
+		resize, parseErr := parseResize(c)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: " + parseErr.Error()})
+			return
+		}
+		variantKey, err = ensureResized(ctx, key, resize, formatOrDefault(format))
+	} else if format != "" {
+		variantKey, err = ensureConverted(ctx, key, format)
+	} else {
+		serveStoredImage(c, key)
+		return
+	}
+
+	if errors.Is(err, image.ErrFormat) {
+		serveStoredImage(c, key)
+		return
+	} else if errors.Is(err, errImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "image not found"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album image"})
+		return
+	}
+	serveStoredImage(c, variantKey)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// queryTimeout bounds the database work done for a request, set by
+// DB_QUERY_TIMEOUT, so slow or stuck queries are canceled instead of piling
+// up goroutines. Image transfers around the queries are not bounded by it.
+var queryTimeout = 10 * time.Second
+
+// withQueryTimeout returns a copy of ctx that is also canceled after
+// queryTimeout. Callers run their queries with it and cancel it when done.
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serverConfig selects how the server listens, set by the LISTEN_ADDR, TLS_*,
+// ACME_*, HTTP_* and HTTP2_* environment variables.
+type serverConfig struct {
+	Addr string // Address of the API, ":8080" or ":443" with ACME by default
+
+	// CertFile and KeyFile hold a certificate and its key, in PEM, for
+	// serving the API over TLS.
+	CertFile string
+	KeyFile  string
+
+	// ACMEHosts are the hostnames for which certificates are obtained from
+	// Let's Encrypt and renewed automatically. They are kept in ACMECacheDir.
+	// HTTP-01 challenges are answered on ACMEHTTPAddr, which redirects every
+	// other request to HTTPS.
+	ACMEHosts    []string
+	ACMECacheDir string
+	ACMEEmail    string
+	ACMEHTTPAddr string
+
+	// The timeouts of the connections, set by HTTP_READ_HEADER_TIMEOUT,
+	// HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT, and the
+	// largest request header, set by HTTP_MAX_HEADER_BYTES, so slow or idle
+	// clients cannot hold connections open. ReadTimeout bounds reading the
+	// whole request, including uploads, and WriteTimeout the time from the end
+	// of the request header to the end of the response, including exports.
+	// Zero disables a timeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// HTTP/2 is negotiated over TLS. Without TLS, H2C (HTTP_H2C) also accepts
+	// cleartext HTTP/2 on the same port from clients with prior knowledge,
+	// such as load balancers and gRPC clients that leave TLS to the edge.
+	// Upgrades of HTTP/1.1 requests to h2c are refused, as through a proxy
+	// they would open a tunnel of requests the proxy does not see.
+	// HTTP2MaxStreams (HTTP2_MAX_CONCURRENT_STREAMS) bounds the requests
+	// multiplexed on one connection.
+	H2C             bool
+	HTTP2MaxStreams int
+}
+
+// loadServerConfig reads the server configuration from the environment.
+func loadServerConfig() (serverConfig, error) {
+	cfg := serverConfig{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ACMEHosts:    getEnvList("ACME_HOSTS", nil),
+		ACMECacheDir: getEnv("ACME_CACHE_DIR", "acme-cache"),
+		ACMEEmail:    os.Getenv("ACME_EMAIL"),
+		ACMEHTTPAddr: getEnv("ACME_HTTP_ADDR", ":80"),
+
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 5*time.Minute),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 10*time.Minute),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    getEnvInt("HTTP_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
+
+		H2C:             getEnvBool("HTTP_H2C", false),
+		HTTP2MaxStreams: getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+	}
+	if min(cfg.ReadHeaderTimeout, cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout) < 0 {
+		return cfg, errors.New("HTTP_READ_HEADER_TIMEOUT, HTTP_READ_TIMEOUT, HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT must not be negative")
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		return cfg, errors.New("HTTP_MAX_HEADER_BYTES must be positive")
+	}
+	if cfg.HTTP2MaxStreams <= 0 {
+		return cfg, errors.New("HTTP2_MAX_CONCURRENT_STREAMS must be positive")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return cfg, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.CertFile != "" && len(cfg.ACMEHosts) > 0 {
+		return cfg, errors.New("TLS_CERT_FILE cannot be combined with ACME_HOSTS")
+	}
+	defaultAddr := ":8080"
+	if len(cfg.ACMEHosts) > 0 {
+		defaultAddr = ":443"
+	}
+	cfg.Addr = getEnv("LISTEN_ADDR", defaultAddr)
+	return cfg, nil
+}
+
+// httpServer returns a server of handler on addr with the timeouts of cfg.
+func (cfg serverConfig) httpServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// priorKnowledgeH2C serves cleartext HTTP/2 connections opened with prior
+// knowledge with handler, and rejects requests asking to upgrade to h2c.
+func priorKnowledgeH2C(handler http.Handler, h2 *http2.Server) http.Handler {
+	h2cHandler := h2c.NewHandler(handler, h2)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if httpguts.HeaderValuesContainsToken(r.Header.Values("Upgrade"), "h2c") {
+			http.Error(w, "h2c upgrades are not supported, use HTTP/2 with prior knowledge", http.StatusBadRequest)
+			return
+		}
+		h2cHandler.ServeHTTP(w, r)
+	})
+}
+
+// runServer serves handler over plain HTTP, or over TLS with the configured
+// certificate or with certificates obtained through ACME, in HTTP/1.1 or
+// HTTP/2.
+func runServer(cfg serverConfig, handler http.Handler) error {
+	tlsEnabled := cfg.CertFile != "" || len(cfg.ACMEHosts) > 0
+	h2 := &http2.Server{MaxConcurrentStreams: uint32(cfg.HTTP2MaxStreams)}
+	if cfg.H2C && !tlsEnabled {
+		handler = priorKnowledgeH2C(handler, h2)
+	}
+	server := cfg.httpServer(cfg.Addr, handler)
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	var manager *autocert.Manager
+	if len(cfg.ACMEHosts) > 0 {
+		manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEHosts...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+	// The HTTP/2 settings are applied to the final TLS configuration, which
+	// they add the h2 protocol to.
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.CertFile != "":
+		logger.Info().Str("addr", cfg.Addr).Msg("Listening with TLS")
+		return server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+	case manager != nil:
+		challenges := cfg.httpServer(cfg.ACMEHTTPAddr, manager.HTTPHandler(nil))
+		go func() {
+			if err := challenges.ListenAndServe(); err != nil {
+				log.Fatalf("Error serving ACME challenges on %s: %v", cfg.ACMEHTTPAddr, err)
+			}
+		}()
+		logger.Info().Str("addr", cfg.Addr).Strs("hosts", cfg.ACMEHosts).Msg("Listening with TLS certificates from Let's Encrypt")
+		return server.ListenAndServeTLS("", "")
+	}
+	logger.Info().Str("addr", cfg.Addr).Bool("h2c", cfg.H2C).Msg("Listening")
+	return server.ListenAndServe()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/XSAM/otelsql"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+// tracingEnabled is set when spans are exported to an OTLP collector, so the
+// database calls are only instrumented when their spans go somewhere.
+var tracingEnabled bool
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporter, the sampler and
+// the resource are configured by the standard OTEL_* environment variables,
+// with the service name albumserver by default. Incoming W3C traceparent and
+// baggage headers are continued. It returns a function flushing the spans
+// that are not exported yet.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("albumserver")),
+		resource.WithFromEnv(), resource.WithTelemetrySDK(), resource.WithHost())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracingEnabled = true
+	return provider.Shutdown, nil
+}
+
+// traceRequests is a middleware that starts a span for every request but the
+// health probes and /metrics, continuing the trace of the caller.
+func traceRequests() gin.HandlerFunc {
+	return otelgin.Middleware("albumserver", otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return !publicRoutes[c.FullPath()]
+	}))
+}
+
+// annotateSpan adds the request ID and the trace ID given by the ALB in
+// X-Amzn-Trace-Id to the span of a request, so traces can be found from the
+// logs of the server and of the load balancer.
+func annotateSpan(c *gin.Context, requestID string) {
+	span := trace.SpanFromContext(c.Request.Context())
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attribute.String("http.request.id", requestID))
+	if albTraceID := c.GetHeader("X-Amzn-Trace-Id"); albTraceID != "" {
+		span.SetAttributes(attribute.String("aws.alb.trace_id", albTraceID))
+	}
+}
+
+// dbTraceOptions records a span for every query, execution and transaction
+// of the database, without the noise of the connection housekeeping.
+func dbTraceOptions() []otelsql.Option {
+	system := semconv.DBSystemMySQL
+	switch dialect {
+	case postgresDialect:
+		system = semconv.DBSystemPostgreSQL
+	case sqliteDialect:
+		system = semconv.DBSystemSqlite
+	}
+	return []otelsql.Option{
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true, OmitConnectorConnect: true}),
+	}
+}
+
+// sqlOpen is sql.Open, with the calls of the database traced when tracing is
+// enabled and slow queries logged when slowQueryThreshold is set.
+func sqlOpen(driverName, dsn string) (*sql.DB, error) {
+	if slowQueryThreshold > 0 {
+		connector, err := openConnector(driverName, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return sqlOpenDB(connector), nil
+	}
+	if tracingEnabled {
+		return otelsql.Open(driverName, dsn, dbTraceOptions()...)
+	}
+	return sql.Open(driverName, dsn)
+}
+
+// sqlOpenDB is sql.OpenDB, with the calls of the database traced when
+// tracing is enabled and slow queries logged when slowQueryThreshold is set.
+func sqlOpenDB(connector driver.Connector) *sql.DB {
+	if slowQueryThreshold > 0 {
+		connector = slowQueryConnector{connector}
+	}
+	if tracingEnabled {
+		return otelsql.OpenDB(connector, dbTraceOptions()...)
+	}
+	return sql.OpenDB(connector)
+}
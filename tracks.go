@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxTracksPerAlbum = 200 // Maximum number of tracks in a track list
+
+// Track is a single entry of an album's track list. Duration is in seconds.
+type Track struct {
+	Position int    `json:"position"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"`
+}
+
+// tracksRequest is the JSON body accepted by POST /albums/:albumID/tracks.
+type tracksRequest struct {
+	Tracks []Track `json:"tracks"`
+}
+
+// getTracks handles GET /albums/:albumID/tracks and returns the track list.
+func getTracks(c *gin.Context) {
+	albumID := c.Param("albumID")
+	if !requireAlbum(c, albumID) {
+		return
+	}
+	tracks, err := albumRepo.Tracks(c.Request.Context(), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve tracks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "tracks": tracks})
+}
+
+// setTracks handles POST /albums/:albumID/tracks and replaces the album's
+// track list. Tracks are numbered from 1 in the order they are given.
+func setTracks(c *gin.Context) {
+	albumID := c.Param("albumID")
+	var req tracksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	if len(req.Tracks) > maxTracksPerAlbum {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: at most " + strconv.Itoa(maxTracksPerAlbum) + " tracks are allowed"})
+		return
+	}
+	for i := range req.Tracks {
+		req.Tracks[i].Position = i + 1
+		req.Tracks[i].Title = strings.TrimSpace(req.Tracks[i].Title)
+		if req.Tracks[i].Title == "" || req.Tracks[i].Duration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: each track needs a title and a non-negative duration"})
+			return
+		}
+	}
+	if !requireAlbum(c, albumID) {
+		return
+	}
+
+	if err := albumRepo.SetTracks(c.Request.Context(), albumID, req.Tracks); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist tracks"})
+		return
+	}
+	auditDetail(c, "tracks", len(req.Tracks))
+
+	if req.Tracks == nil {
+		req.Tracks = []Track{}
+	}
+	c.JSON(http.StatusOK, gin.H{"albumID": albumID, "tracks": req.Tracks})
+}
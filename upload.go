@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// presignExpiry is how long a URL returned by POST /albums/upload-url stays
+// valid, set by PRESIGN_EXPIRY.
+var presignExpiry = 15 * time.Minute
+
+// uploadURLRequest is the JSON body accepted by POST /albums/upload-url.
+type uploadURLRequest struct {
+	ContentType   string `json:"contentType"`
+	ContentLength int64  `json:"contentLength"`
+}
+
+// completeUploadRequest is the JSON body accepted by POST /albums/complete.
+type completeUploadRequest struct {
+	UploadKey string  `json:"uploadKey" binding:"uuid"`
+	Profile   Profile `json:"profile"`
+}
+
+// directUploadStore returns the S3 image store, or writes a 501 response and
+// returns nil when images are kept elsewhere.
+func directUploadStore(c *gin.Context) *s3ImageStore {
+	store, ok := imageStore.(*s3ImageStore)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"msg": "direct uploads require IMAGE_STORE=s3"})
+		return nil
+	}
+	return store
+}
+
+// createUploadURL handles POST /albums/upload-url and returns a presigned S3
+// PUT URL for a new image of contentLength bytes. The client uploads the image
+// bytes to the URL with the same Content-Type and Content-Length and then
+// registers the album with POST /albums/complete.
+func createUploadURL(c *gin.Context) {
+	store := directUploadStore(c)
+	if store == nil {
+		return
+	}
+	var req uploadURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	if !strings.HasPrefix(req.ContentType, "image/") {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: contentType must be an image type"})
+		return
+	}
+	if !allowedImageTypes[req.ContentType] {
+		respondUnsupportedImage(c, &unsupportedImageError{ContentType: req.ContentType})
+		return
+	}
+	if req.ContentLength <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: contentLength must be positive"})
+		return
+	}
+	if req.ContentLength > int64(maxImageSize) {
+		respondTooLarge(c, errImageTooLarge)
+		return
+	}
+
+	uploadKey := uuid.New().String()
+	url, err := store.presignPut(c.Request.Context(), uploadKey, req.ContentType, req.ContentLength, presignExpiry)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to create upload URL"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"uploadKey": uploadKey,
+		"url":       url,
+		"method":    http.MethodPut,
+		"headers":   gin.H{"Content-Type": req.ContentType, "Content-Length": strconv.FormatInt(req.ContentLength, 10)},
+		"expiresAt": time.Now().UTC().Add(presignExpiry),
+	})
+}
+
+// completeUpload handles POST /albums/complete and creates an album for an
+// image uploaded to a URL from POST /albums/upload-url. The image bytes are
+// never read by the server, so the album has no image hash and is not checked
+// against REJECT_DUPLICATE_IMAGES.
+func completeUpload(c *gin.Context) {
+	store := directUploadStore(c)
+	if store == nil {
+		return
+	}
+	var req completeUploadRequest
+	// The profile is validated with the request, so every invalid field of
+	// both is listed.
+	if !bindJSON(c, &req) {
+		return
+	}
+	profile := req.Profile
+	profile.Genre = normalizeGenre(profile.Genre)
+
+	// The uploaded object must exist and must not belong to an album yet.
+	ctx := c.Request.Context()
+	imageSize, contentType, err := store.stat(ctx, req.UploadKey)
+	if err == nil {
+		addUploadSize(ctx, imageSize)
+	}
+	if errors.Is(err, errImageNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: image has not been uploaded"})
+		return
+	} else if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve uploaded image"})
+		return
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: uploaded object is not an image"})
+		return
+	}
+	// The signed Content-Length should stop larger uploads, but an object over
+	// the limit is deleted rather than kept around unreferenced.
+	if imageSize > int64(maxImageSize) {
+		if err := store.Delete(ctx, req.UploadKey); err != nil {
+			logFor(ctx).Error().Err(err).Str("uploadKey", req.UploadKey).Msg("Error deleting oversized upload")
+		}
+		respondTooLarge(c, errImageTooLarge)
+		return
+	}
+
+	// The declared Content-Type is chosen by the client, so the type is
+	// detected from the first bytes of the object instead, along with the
+	// image dimensions.
+	head, err := store.readHead(ctx, req.UploadKey, imageHeadSize)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve uploaded image"})
+		return
+	}
+	if contentType, err = checkImageType(head); respondUnsupportedImage(c, err) {
+		return
+	}
+	var one int
+	queryCtx, cancel := withQueryTimeout(ctx)
+	err = db.QueryRowContext(queryCtx, `SELECT 1 FROM album_images WHERE storage_key = ? LIMIT 1`, req.UploadKey).Scan(&one)
+	cancel()
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"msg": "upload already completed"})
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album data"})
+		return
+	}
+
+	if !respondQuota(c, checkQuota(ctx, c.GetString(userIDKey), 1, imageSize)) {
+		return
+	}
+
+	albumID := uuid.New().String()
+	image := storedImage{Key: req.UploadKey, Size: imageSize, ContentType: contentType}
+	image.Width, image.Height = imageDimensions(head)
+	album := newAlbum{AlbumID: albumID, OwnerID: c.GetString(userIDKey), Image: image, Profile: profile}
+	if err := albumRepo.Create(ctx, album); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist album data"})
+		return
+	}
+	uploads.record(1)
+	queueImageJob(ctx, albumID)
+	auditTarget(c, albumID)
+	c.JSON(http.StatusOK, gin.H{
+		"albumID":   albumID,
+		"imageSize": strconv.FormatInt(imageSize, 10),
+	})
+}
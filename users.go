@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+)
+
+// userIDKey is the Gin context key holding the authenticated user's ID.
+const userIDKey = "userID"
+
+// userAccount is an authenticated user and their role.
+type userAccount struct {
+	UserID string
+	Role   string
+}
+
+// userRequest is the JSON body accepted by POST /users.
+type userRequest struct {
+	Name string `json:"name"`
+}
+
+// newToken returns a random hex-encoded token and its SHA-256 hash.
+func newToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createUser handles POST /users. The returned token identifies the user in
+// the X-User-Token header and is only shown once; the server keeps its hash.
+func createUser(c *gin.Context) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: name must be between 1 and 255 characters"})
+		return
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to generate token"})
+		return
+	}
+	userID := uuid.New().String()
+	ctx, cancel := withQueryTimeout(c.Request.Context())
+	defer cancel()
+	result, err := db.ExecContext(ctx, dialect.insertIgnore(`INSERT INTO users (user_id, name, token_hash) VALUES (?, ?, ?)`), userID, name, hash)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to persist user"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"msg": "user already exists"})
+		return
+	}
+	auditTarget(c, userID)
+	c.JSON(http.StatusCreated, gin.H{"userID": userID, "name": name, "token": token})
+}
+
+// requireUser is a middleware that resolves the X-User-Token header to a user
+// and stores the userID in the context, rejecting unauthenticated requests.
+// Users signed in with an OpenID Connect bearer token are already resolved.
+func requireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(userIDKey) != "" {
+			c.Next()
+			return
+		}
+		if c.GetHeader("X-User-Token") == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "authentication required"})
+			return
+		}
+		if authenticateUser(c) {
+			c.Next()
+		}
+	}
+}
+
+// optionalUser is a middleware like requireUser that lets requests without an
+// X-User-Token header through anonymously. Albums uploaded with a token are
+// owned by the user and count against the per-user quotas.
+func optionalUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(userIDKey) != "" || c.GetHeader("X-User-Token") == "" || authenticateUser(c) {
+			c.Next()
+		}
+	}
+}
+
+// authenticateUser stores the userID and role of the user of the
+// X-User-Token header in the context. It aborts the request and returns false
+// when the token is invalid.
+func authenticateUser(c *gin.Context) bool {
+	hash := hashToken(c.GetHeader("X-User-Token"))
+	user, err := withRetry(c.Request.Context(), func() (userAccount, error) {
+		ctx, cancel := withQueryTimeout(c.Request.Context())
+		defer cancel()
+		stmt, err := prepared(ctx, db, `SELECT user_id, role FROM users WHERE token_hash = ?`)
+		if err != nil {
+			return userAccount{}, err
+		}
+		var user userAccount
+		err = stmt.QueryRowContext(ctx, hash).Scan(&user.UserID, &user.Role)
+		return user, err
+	})
+	if err == sql.ErrNoRows {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"msg": "invalid user token"})
+		return false
+	} else if err != nil {
+		c.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"msg": "failed to authenticate user"})
+		return false
+	}
+	c.Set(userIDKey, user.UserID)
+	c.Set(userRoleKey, user.Role)
+	return true
+}
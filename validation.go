@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Request bodies are validated with the `binding` struct tags, as Gin does for
+// ShouldBindJSON, using the validators of album fields registered by init.
+
+// fieldError is an invalid field of a request body or profile. Field is the
+// JSON name of the field, with the names of its enclosing objects for nested
+// fields, such as profile.year.
+type fieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+}
+
+func (e *fieldError) Error() string {
+	return e.Msg
+}
+
+// fieldErrors lists every invalid field of a request body.
+type fieldErrors []*fieldError
+
+func (errs fieldErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Msg
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// invalidFields returns the invalid fields reported by err, or nil when err
+// is not about fields.
+func invalidFields(err error) fieldErrors {
+	var errs fieldErrors
+	var fieldErr *fieldError
+	if errors.As(err, &errs) {
+		return errs
+	} else if errors.As(err, &fieldErr) {
+		return fieldErrors{fieldErr}
+	}
+	return nil
+}
+
+// invalidRequest returns the body of a 400 response for err, which lists the
+// invalid fields when err is about fields. The first one is also named in
+// field, as before the list was added.
+func invalidRequest(err error) gin.H {
+	body := gin.H{"msg": "invalid request: " + err.Error()}
+	if errs := invalidFields(err); len(errs) > 0 {
+		body["field"] = errs[0].Field
+		body["fields"] = errs
+	}
+	return body
+}
+
+// The genre and albumyear validators are added to Gin's validator, which
+// reports fields by their JSON names.
+func init() {
+	v := binding.Validator.Engine().(*validator.Validate)
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	mustRegister := func(tag string, fn validator.Func) {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			panic(err)
+		}
+	}
+	mustRegister("genre", func(fl validator.FieldLevel) bool {
+		genre := normalizeGenre(fl.Field().String())
+		return genre == "" || allowedGenres[genre]
+	})
+	mustRegister("albumyear", func(fl validator.FieldLevel) bool {
+		return albumYear(fl.Field().Int()).validate() == nil
+	})
+}
+
+// validateStruct checks the `binding` tags of v and returns the fieldErrors
+// of every invalid field.
+func validateStruct(v any) error {
+	return validationErrors(binding.Validator.ValidateStruct(v))
+}
+
+// validationErrors converts the errors of the validator to fieldErrors.
+func validationErrors(err error) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	fields := make(fieldErrors, len(errs))
+	for i, fe := range errs {
+		// The namespace starts with the name of the validated struct type.
+		_, field, _ := strings.Cut(fe.Namespace(), ".")
+		fields[i] = &fieldError{Field: field, Msg: fieldMessage(field, fe)}
+	}
+	return fields
+}
+
+// fieldMessage describes why a field failed a validation tag.
+func fieldMessage(field string, fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return field + " is required"
+	case "max":
+		if fe.Kind() == reflect.String {
+			return field + " must be at most " + fe.Param() + " characters"
+		}
+		return field + " must be at most " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.String {
+			return field + " must be at least " + fe.Param() + " characters"
+		}
+		return field + " must be at least " + fe.Param()
+	case "genre":
+		return "genre '" + normalizeGenre(fe.Value().(string)) + "' is not allowed"
+	case "albumyear":
+		return yearRangeError().Msg
+	}
+	return field + " is not valid"
+}
+
+// bindJSON decodes and validates the JSON body of a request into v. It
+// responds with 400, listing the invalid fields, or 413 and returns false
+// when the body cannot be used.
+func bindJSON(c *gin.Context, v any) bool {
+	err := c.ShouldBindJSON(v)
+	if err == nil {
+		return true
+	}
+	var bodyErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &bodyErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"msg": bodyTooLargeMsg(bodyErr.Limit)})
+	} else if errors.As(err, &typeErr) && typeErr.Field != "" {
+		c.JSON(http.StatusBadRequest, invalidRequest(typeFieldError(typeErr)))
+	} else if errs := invalidFields(validationErrors(err)); errs != nil {
+		c.JSON(http.StatusBadRequest, invalidRequest(errs))
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: body is not valid JSON"})
+	}
+	return false
+}
+
+// typeFieldError returns the fieldError for a JSON value of the wrong type.
+func typeFieldError(err *json.UnmarshalTypeError) *fieldError {
+	return &fieldError{Field: err.Field, Msg: err.Field + " must be a JSON " + jsonKind(err.Type)}
+}
+
+// jsonKind names the JSON type decoded into values of t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errVersionConflict is returned when an album was changed since the version
+// a request expected.
+var errVersionConflict = errors.New("album was changed by another request")
+
+// albumETag returns the ETag of an album at a version. Every change to an
+// album increments the version column.
+func albumETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// albumResponseETag returns the ETag of the GET /albums/:albumID response
+// body of an album at a version: the version, which If-Match takes back,
+// followed by a hash of the body, so it also changes with the ratings and
+// colors, which leave the version as is.
+func albumResponseETag(version int64, body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + strconv.FormatInt(version, 10) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// notModified answers a GET request with 304 and reports true when its
+// If-None-Match header holds etag, comparing the ETags weakly, so clients
+// polling a resource and CDNs revalidating it do not download it again.
+func notModified(c *gin.Context, etag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	match := strings.TrimSpace(header) == "*"
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			match = true
+		}
+	}
+	if !match {
+		return false
+	}
+	c.Header("ETag", etag)
+	c.Status(http.StatusNotModified)
+	c.Writer.WriteHeaderNow()
+	return true
+}
+
+// requireIfMatch reads the album version a change is based on from the
+// If-Match header, which must hold the ETag returned by GET /albums/:albumID.
+// It responds with 428 when the header is missing and reports false.
+func requireIfMatch(c *gin.Context) (int64, bool) {
+	v := c.GetHeader("If-Match")
+	if v == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"msg": "invalid request: If-Match header with the album ETag is required"})
+		return 0, false
+	}
+	// The ETag of GET /albums/:albumID also holds a hash of the body after the
+	// version.
+	v, _, _ = strings.Cut(strings.Trim(strings.TrimPrefix(v, "W/"), `"`), "-")
+	version, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"msg": "invalid request: If-Match must be an album ETag"})
+		return 0, false
+	}
+	return version, true
+}
+
+// checkVersionTx locks an album within tx and returns errVersionConflict
+// unless it is at the expected version, or errAlbumNotFound.
+func checkVersionTx(ctx context.Context, tx *sql.Tx, albumID string, expected int64) error {
+	var version int64
+	query := `SELECT version FROM albums WHERE album_id = ? AND deleted_at IS NULL` + dialect.forUpdate()
+	err := tx.QueryRowContext(ctx, query, albumID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return errAlbumNotFound
+	} else if err != nil {
+		return err
+	}
+	if version != expected {
+		return errVersionConflict
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Concurrent album creations are grouped into one transaction of multi-row
+// inserts when albumBatchWindow (DB_BATCH_WINDOW) is set: the first album of a
+// batch waits that long for others, up to albumBatchSize (DB_BATCH_MAX_SIZE),
+// so bursts of uploads take a few round trips to the database per batch
+// instead of several per album.
+var (
+	albumBatchWindow time.Duration
+	albumBatchSize   = 50
+)
+
+// batchingAlbumRepository creates albums through an albumBatcher and passes
+// the other calls to another AlbumRepository.
+type batchingAlbumRepository struct {
+	AlbumRepository
+	batcher *albumBatcher
+}
+
+func newBatchingAlbumRepository(next AlbumRepository) batchingAlbumRepository {
+	return batchingAlbumRepository{AlbumRepository: next, batcher: &albumBatcher{next: next}}
+}
+
+// Create waits for the batch of the album to be inserted. Its insertion is not
+// canceled with ctx, as the other albums of the batch do not share it.
+func (r batchingAlbumRepository) Create(ctx context.Context, album newAlbum) error {
+	done := make(chan error, 1)
+	r.batcher.add(pendingAlbum{album, done})
+	return <-done
+}
+
+// pendingAlbum is an album waiting in a batch, with the channel its error is
+// sent on.
+type pendingAlbum struct {
+	album newAlbum
+	done  chan error
+}
+
+// albumBatcher collects the albums created within albumBatchWindow.
+type albumBatcher struct {
+	next AlbumRepository
+
+	mu      sync.Mutex
+	pending []pendingAlbum
+	timer   *time.Timer
+}
+
+// add puts an album in the current batch, which is inserted once it is full
+// or albumBatchWindow after its first album.
+func (b *albumBatcher) add(p pendingAlbum) {
+	b.mu.Lock()
+	b.pending = append(b.pending, p)
+	if len(b.pending) >= albumBatchSize {
+		batch := b.take()
+		b.mu.Unlock()
+		b.insert(batch)
+		return
+	}
+	if len(b.pending) == 1 {
+		b.timer = time.AfterFunc(albumBatchWindow, func() {
+			b.mu.Lock()
+			batch := b.take()
+			b.mu.Unlock()
+			b.insert(batch)
+		})
+	}
+	b.mu.Unlock()
+}
+
+// take removes the current batch. b.mu must be held.
+func (b *albumBatcher) take() []pendingAlbum {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+// insert creates the albums of a batch and sends each its error. When the
+// batch fails, its albums are created one by one, so an album that cannot be
+// inserted, such as one with a taken albumID, only fails its own request,
+// unless it failed to commit.
+func (b *albumBatcher) insert(batch []pendingAlbum) {
+	ctx := context.Background()
+	if len(batch) > 1 {
+		albums := make([]newAlbum, len(batch))
+		for i, p := range batch {
+			albums[i] = p.album
+		}
+		err := retryErr(ctx, func() error { return sqlAlbumRepository{}.CreateBatch(ctx, albums) })
+		if err == nil {
+			albumBatches.Observe(float64(len(batch)))
+			for _, p := range batch {
+				p.done <- nil
+			}
+			return
+		}
+		var commitErr *commitError
+		if errors.As(err, &commitErr) {
+			// The batch may be committed, so inserting its albums again
+			// could only fail.
+			for _, p := range batch {
+				p.done <- err
+			}
+			return
+		}
+		logger.Warn().Err(err).Int("albums", len(batch)).Msg("Error inserting batch of albums, inserting them one by one")
+	}
+	for _, p := range batch {
+		p.done <- b.next.Create(ctx, p.album)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"time"
+)
+
+// writeQueue, set by WRITE_QUEUE (sqs or rabbitmq), makes POST /albums store
+// the image and enqueue the album instead of inserting it, answering 202
+// Accepted. writeConsumers goroutines (WRITE_QUEUE_CONSUMERS) insert the
+// queued albums, so bursts of uploads reach the database at the pace of the
+// pool; servers without consumers only enqueue. An album that fails to be
+// inserted is attempted up to writeAttempts times (WRITE_QUEUE_MAX_ATTEMPTS).
+var (
+	writeQueue     albumWriteQueue
+	writeConsumers = 4
+	writeAttempts  = 5
+)
+
+// writeRetryDelay is the delay before the second attempt to insert a queued
+// album, doubled for each later attempt.
+const writeRetryDelay = 5 * time.Second
+
+// writePendingWindow is how long after its upload an album that is neither
+// created nor failed is reported pending. Past it the message of the album is
+// taken as lost, and the album as not found.
+const writePendingWindow = time.Hour
+
+// writeQueueErrorDelay is the pause of a consumer after failing to receive.
+const writeQueueErrorDelay = 5 * time.Second
+
+// albumWriteQueue is a message queue holding the albums waiting to be
+// inserted.
+type albumWriteQueue interface {
+	// Send enqueues a message, delivered after delay.
+	Send(ctx context.Context, body []byte, delay time.Duration) error
+	// Receive waits for the next message. It returns no message when none
+	// arrived within the wait time of the queue.
+	Receive(ctx context.Context) (*queuedMessage, error)
+}
+
+// queuedMessage is a message received from an albumWriteQueue. Ack removes
+// it from the queue; Release makes it available to the consumers again.
+type queuedMessage struct {
+	Body    []byte
+	Ack     func(ctx context.Context) error
+	Release func(ctx context.Context) error
+}
+
+// albumWrite is the message of an album enqueued by POST /albums.
+type albumWrite struct {
+	Album     newAlbum
+	RequestID string // ID of the upload request, logged with the insertion
+	Attempt   int    // Number of the failed attempts to insert the album
+}
+
+// newAlbumWriteQueue returns the queue selected by WRITE_QUEUE, or nil when
+// albums are inserted by the upload request.
+func newAlbumWriteQueue(ctx context.Context) (albumWriteQueue, error) {
+	switch backend := getEnv("WRITE_QUEUE", ""); backend {
+	case "":
+		return nil, nil
+	case "sqs":
+		return newSQSWriteQueue(ctx)
+	case "rabbitmq":
+		return newRabbitMQWriteQueue()
+	default:
+		return nil, fmt.Errorf("unknown WRITE_QUEUE %q", backend)
+	}
+}
+
+// enqueueAlbum sends an album to writeQueue to be inserted by a consumer.
+func enqueueAlbum(ctx context.Context, album newAlbum, requestID string) error {
+	body, err := json.Marshal(albumWrite{Album: album, RequestID: requestID})
+	if err != nil {
+		return err
+	}
+	if err := writeQueue.Send(ctx, body, 0); err != nil {
+		return err
+	}
+	albumWrites.WithLabelValues("queued").Inc()
+	return nil
+}
+
+// newQueuedAlbumID returns the ID of an album to be enqueued: a version 7
+// UUID, which holds the time of the upload.
+func newQueuedAlbumID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
+}
+
+// queuedRecently reports whether albumID is the ID of an album enqueued within
+// writePendingWindow. IDs that are not version 7 UUIDs were not enqueued.
+func queuedRecently(albumID string) bool {
+	id, err := uuid.Parse(albumID)
+	if err != nil || id.Version() != 7 {
+		return false
+	}
+	age := time.Since(time.Unix(id.Time().UnixTime()))
+	return age > -time.Minute && age < writePendingWindow
+}
+
+// startWriteConsumers starts the writeConsumers goroutines inserting the
+// albums of writeQueue.
+func startWriteConsumers() {
+	for range writeConsumers {
+		go func() {
+			ctx := context.Background()
+			for {
+				msg, err := writeQueue.Receive(ctx)
+				if err != nil {
+					logger.Error().Err(err).Msg("Error receiving queued albums")
+					time.Sleep(writeQueueErrorDelay)
+					continue
+				}
+				if msg != nil {
+					processAlbumWrite(ctx, msg)
+				}
+			}
+		}()
+	}
+}
+
+// processAlbumWrite inserts a queued album. Messages may be delivered more
+// than once, so albums that were already inserted or failed are skipped. An
+// album rejected as a duplicate or over quota fails at once; other errors are
+// retried by sending the album again with a delay, until writeAttempts.
+// Failures are recorded for GET /albums/:albumID/status and the image of the
+// album is deleted.
+func processAlbumWrite(ctx context.Context, msg *queuedMessage) {
+	var write albumWrite
+	if err := json.Unmarshal(msg.Body, &write); err != nil {
+		logger.Error().Err(err).Msg("Dropping malformed queued album")
+		settleMessage(ctx, msg.Ack)
+		return
+	}
+	album := write.Album
+	l := logger.With().Str("requestID", write.RequestID).Str("albumID", album.AlbumID).Logger()
+	ctx = l.WithContext(ctx)
+
+	status, _, err := albumWriteStatus(ctx, album.AlbumID)
+	if err == nil && status != "pending" {
+		settleMessage(ctx, msg.Ack)
+		return
+	}
+	if err == nil {
+		err = createAlbumRecord(ctx, album)
+	}
+	if err == nil {
+		uploads.record(1)
+		queueImageJob(ctx, album.AlbumID)
+		albumWrites.WithLabelValues("created").Inc()
+		settleMessage(ctx, msg.Ack)
+		return
+	}
+
+	failure, permanent := albumWriteError(err)
+	if write.Attempt++; !permanent && write.Attempt < writeAttempts {
+		logFor(ctx).Warn().Err(err).Int("attempt", write.Attempt).Msg("Error inserting queued album, retrying")
+		body, _ := json.Marshal(write)
+		if err := writeQueue.Send(ctx, body, writeRetryDelay<<(write.Attempt-1)); err != nil {
+			logFor(ctx).Error().Err(err).Msg("Error queueing album again")
+			settleMessage(ctx, msg.Release)
+			return
+		}
+		albumWrites.WithLabelValues("retried").Inc()
+		settleMessage(ctx, msg.Ack)
+		return
+	}
+	if !permanent {
+		logFor(ctx).Error().Err(err).Int("attempts", write.Attempt).Msg("Error inserting queued album")
+	}
+	if err := recordAlbumWriteFailure(ctx, album.AlbumID, failure); err != nil {
+		logFor(ctx).Error().Err(err).Msg("Error recording failed album")
+		settleMessage(ctx, msg.Release)
+		return
+	}
+	deleteImages(ctx, []string{album.Image.Key})
+	albumWrites.WithLabelValues("failed").Inc()
+	settleMessage(ctx, msg.Ack)
+}
+
+// settleMessage acknowledges or releases a message with settle, logging
+// failures: the message is then delivered again.
+func settleMessage(ctx context.Context, settle func(context.Context) error) {
+	if err := settle(ctx); err != nil {
+		logFor(ctx).Error().Err(err).Msg("Error settling queued album")
+	}
+}
+
+// albumWriteFailure is the reason a queued album was not inserted, with the
+// status POST /albums answers the same failure with.
+type albumWriteFailure struct {
+	Status int    `json:"statusCode"`
+	Msg    string `json:"msg"`
+}
+
+// albumWriteError returns the failure of a queued album that could not be
+// inserted, and whether retrying fails the same way.
+func albumWriteError(err error) (albumWriteFailure, bool) {
+	var dup *duplicateImageError
+	if errors.As(err, &dup) {
+		return albumWriteFailure{http.StatusConflict, "image already uploaded as album " + dup.AlbumID}, true
+	}
+	var quotaErr *quotaError
+	if errors.As(err, &quotaErr) {
+		return albumWriteFailure{quotaErr.Status, quotaErr.Msg}, true
+	}
+	return albumWriteFailure{http.StatusInternalServerError, "failed to persist album data"}, false
+}
+
+// recordAlbumWriteFailure keeps the failure of a queued album.
+func recordAlbumWriteFailure(ctx context.Context, albumID string, failure albumWriteFailure) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO album_write_failures (album_id, status, msg) VALUES (?, ?, ?)`,
+		albumID, failure.Status, failure.Msg)
+	return err
+}
+
+// albumWriteStatus returns whether an album is created, failed with the
+// returned failure, deleted, or pending: not inserted yet by the consumers.
+func albumWriteStatus(ctx context.Context, albumID string) (string, albumWriteFailure, error) {
+	exists, err := albumRepo.Exists(ctx, albumID)
+	if err != nil {
+		return "", albumWriteFailure{}, err
+	} else if exists {
+		return "created", albumWriteFailure{}, nil
+	}
+	var failure albumWriteFailure
+	err = db.QueryRowContext(ctx, `SELECT status, msg FROM album_write_failures WHERE album_id = ?`, albumID).
+		Scan(&failure.Status, &failure.Msg)
+	if err == nil {
+		return "failed", failure, nil
+	} else if err != sql.ErrNoRows {
+		return "", albumWriteFailure{}, err
+	}
+	var one int
+	err = db.QueryRowContext(ctx, `SELECT 1 FROM albums WHERE album_id = ?`, albumID).Scan(&one)
+	if err == nil {
+		return "deleted", albumWriteFailure{}, nil
+	} else if err != sql.ErrNoRows {
+		return "", albumWriteFailure{}, err
+	}
+	return "pending", albumWriteFailure{}, nil
+}
+
+// getAlbumStatus handles GET /albums/:albumID/status and tells whether an
+// album uploaded while writes are queued is created and readable, failed,
+// with the reason, or still pending. Without a queue albums are created by
+// the upload, so unknown albums are not found, and neither are deleted albums
+// or those pending for longer than writePendingWindow.
+func getAlbumStatus(c *gin.Context) {
+	albumID := c.Param("albumID")
+	status, failure, err := albumWriteStatus(c.Request.Context(), albumID)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"msg": "failed to retrieve album status"})
+		return
+	}
+	switch {
+	case status == "failed":
+		c.JSON(http.StatusOK, gin.H{"albumID": albumID, "status": status, "error": failure})
+	case status == "deleted", status == "pending" && (writeQueue == nil || !queuedRecently(albumID)):
+		c.JSON(http.StatusNotFound, gin.H{"msg": "album not found"})
+	case status == "pending":
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusOK, gin.H{"albumID": albumID, "status": status})
+	default:
+		c.JSON(http.StatusOK, gin.H{"albumID": albumID, "status": status})
+	}
+}
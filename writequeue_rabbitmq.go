@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
+	"time"
+)
+
+// rabbitMQWriteQueue keeps the queued albums in a durable RabbitMQ queue. The
+// connection is opened again when it is lost. Delayed messages wait in a
+// delay queue per delay, whose messages expire after the delay and are then
+// dead-lettered to the queue.
+type rabbitMQWriteQueue struct {
+	url   string
+	queue string
+
+	mu         sync.Mutex
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	deliveries <-chan amqp.Delivery
+}
+
+// rabbitMQWait is how long Receive waits for a message.
+const rabbitMQWait = 20 * time.Second
+
+// newRabbitMQWriteQueue connects to the broker at RABBITMQ_URL and declares
+// the RABBITMQ_QUEUE queue, album-writes by default.
+func newRabbitMQWriteQueue() (*rabbitMQWriteQueue, error) {
+	url := getEnv("RABBITMQ_URL", "")
+	if url == "" {
+		return nil, errors.New("RABBITMQ_URL environment variable is not set")
+	}
+	q := &rabbitMQWriteQueue{url: url, queue: getEnv("RABBITMQ_QUEUE", "album-writes")}
+	if _, err := q.open(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// open returns the channel to the broker, connecting again when the
+// connection or the channel was closed. Publishes are confirmed by the broker,
+// so an album is only accepted once it is queued.
+func (q *rabbitMQWriteQueue) open() (*amqp.Channel, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.channel != nil && !q.channel.IsClosed() {
+		return q.channel, nil
+	}
+	q.deliveries = nil
+	if q.conn == nil || q.conn.IsClosed() {
+		conn, err := amqp.Dial(q.url)
+		if err != nil {
+			return nil, err
+		}
+		q.conn = conn
+	}
+	channel, err := q.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := channel.QueueDeclare(q.queue, true, false, false, false, nil); err != nil {
+		channel.Close()
+		return nil, err
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, err
+	}
+	q.channel = channel
+	return channel, nil
+}
+
+// consume returns the deliveries of the queue, starting to consume on the
+// first call. The broker sends the consumers one message each at a time.
+func (q *rabbitMQWriteQueue) consume() (<-chan amqp.Delivery, error) {
+	channel, err := q.open()
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.deliveries != nil {
+		return q.deliveries, nil
+	}
+	if err := channel.Qos(writeConsumers, 0, false); err != nil {
+		return nil, err
+	}
+	deliveries, err := channel.Consume(q.queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	q.deliveries = deliveries
+	return deliveries, nil
+}
+
+// Send publishes a message to the queue, or to the delay queue of delay, as
+// RabbitMQ has no delayed delivery without a plugin. It returns once the
+// broker has the message, so a consumer retrying an album does not wait for
+// the delay.
+func (q *rabbitMQWriteQueue) Send(ctx context.Context, body []byte, delay time.Duration) error {
+	channel, err := q.open()
+	if err != nil {
+		return err
+	}
+	routingKey := q.queue
+	if delay > 0 {
+		if routingKey, err = q.declareDelayQueue(channel, delay); err != nil {
+			return err
+		}
+	}
+	confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, "", routingKey, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+	if acked, err := confirmation.WaitContext(ctx); err != nil {
+		return err
+	} else if !acked {
+		return errors.New("RabbitMQ refused the message")
+	}
+	return nil
+}
+
+// declareDelayQueue declares the delay queue of delay and returns its name.
+// All its messages have the same time to live, so they expire in order. The
+// queue is deleted once unused for longer than its messages wait.
+func (q *rabbitMQWriteQueue) declareDelayQueue(channel *amqp.Channel, delay time.Duration) (string, error) {
+	ttl := delay.Milliseconds()
+	name := fmt.Sprintf("%s.delay.%d", q.queue, ttl)
+	_, err := channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": q.queue,
+		"x-expires":                 ttl + time.Minute.Milliseconds(),
+	})
+	return name, err
+}
+
+func (q *rabbitMQWriteQueue) Receive(ctx context.Context) (*queuedMessage, error) {
+	deliveries, err := q.consume()
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(rabbitMQWait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil
+	case d, ok := <-deliveries:
+		if !ok {
+			return nil, errors.New("RabbitMQ channel closed")
+		}
+		return &queuedMessage{
+			Body:    d.Body,
+			Ack:     func(context.Context) error { return d.Ack(false) },
+			Release: func(context.Context) error { return d.Nack(false, true) },
+		}, nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"time"
+)
+
+// sqsWriteQueue keeps the queued albums in an SQS queue.
+type sqsWriteQueue struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+// sqsMaxDelay is the longest delay SQS accepts for a message.
+const sqsMaxDelay = 15 * time.Minute
+
+// newSQSWriteQueue configures an SQS queue from SQS_QUEUE_URL and the standard
+// AWS configuration chain. SQS_ENDPOINT points the client at an
+// SQS-compatible service such as LocalStack or ElasticMQ. The visibility
+// timeout of the queue must exceed the time taken to insert an album.
+func newSQSWriteQueue(ctx context.Context) (*sqsWriteQueue, error) {
+	queueURL := getEnv("SQS_QUEUE_URL", "")
+	if queueURL == "" {
+		return nil, errors.New("SQS_QUEUE_URL environment variable is not set")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		if endpoint := getEnv("SQS_ENDPOINT", ""); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &sqsWriteQueue{client: client, queueURL: queueURL}, nil
+}
+
+func (q *sqsWriteQueue) Send(ctx context.Context, body []byte, delay time.Duration) error {
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(q.queueURL),
+		MessageBody:  aws.String(string(body)),
+		DelaySeconds: int32(min(delay, sqsMaxDelay) / time.Second),
+	})
+	return err
+}
+
+// Receive long-polls the queue for up to 20 seconds.
+func (q *sqsWriteQueue) Receive(ctx context.Context) (*queuedMessage, error) {
+	out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.queueURL),
+		MaxNumberOfMessages: 1,
+		WaitTimeSeconds:     20,
+	})
+	if err != nil || len(out.Messages) == 0 {
+		return nil, err
+	}
+	receipt := out.Messages[0].ReceiptHandle
+	return &queuedMessage{
+		Body: []byte(aws.ToString(out.Messages[0].Body)),
+		Ack: func(ctx context.Context) error {
+			_, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(q.queueURL), ReceiptHandle: receipt})
+			return err
+		},
+		Release: func(ctx context.Context) error {
+			_, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl: aws.String(q.queueURL), ReceiptHandle: receipt, VisibilityTimeout: 0,
+			})
+			return err
+		},
+	}, nil
+}
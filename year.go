@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minAlbumYear = 1900 // Earliest release year accepted for an album
+
+// invalidAlbumYear is decoded from JSON values that are not a year, so that
+// validate reports them together with the other invalid fields.
+const invalidAlbumYear albumYear = -1
+
+// albumYear is the release year of an album, zero when it is unknown. It is
+// stored in an integer column and accepted in JSON as a number or, as older
+// clients send it, a string of digits.
+type albumYear int
+
+// yearRangeError returns the fieldError for a year outside the accepted range.
+func yearRangeError() *fieldError {
+	return &fieldError{Field: "year", Msg: fmt.Sprintf("year must be an integer between %d and %d", minAlbumYear, maxAlbumYear())}
+}
+
+// maxAlbumYear returns the latest release year accepted for an album, which
+// allows announcing the albums of next year.
+func maxAlbumYear() int {
+	return time.Now().Year() + 1
+}
+
+// parseAlbumYear parses a year given as text. An empty string is an unknown
+// year.
+func parseAlbumYear(s string) (albumYear, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	year, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, yearRangeError()
+	}
+	return albumYear(year), albumYear(year).validate()
+}
+
+// validate checks that a known year is within minAlbumYear and maxAlbumYear.
+func (y albumYear) validate() error {
+	if y != 0 && (y < minAlbumYear || int(y) > maxAlbumYear()) {
+		return yearRangeError()
+	}
+	return nil
+}
+
+// String returns the year in decimal, or "" when it is unknown.
+func (y albumYear) String() string {
+	if y == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(y))
+}
+
+// MarshalJSON encodes the year as a number, or null when it is unknown.
+func (y albumYear) MarshalJSON() ([]byte, error) {
+	if y == 0 {
+		return []byte("null"), nil
+	}
+	return strconv.AppendInt(nil, int64(y), 10), nil
+}
+
+// UnmarshalJSON decodes a number, a string of digits or null. Other values
+// decode to invalidAlbumYear; the range is checked by validate.
+func (y *albumYear) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*y = invalidAlbumYear
+	switch v := v.(type) {
+	case nil:
+		*y = 0
+	case float64:
+		if v == float64(int(v)) && v >= 0 {
+			*y = albumYear(v)
+		}
+	case string:
+		if v = strings.TrimSpace(v); v == "" {
+			*y = 0
+		} else if year, err := strconv.Atoi(v); err == nil && year >= 0 {
+			*y = albumYear(year)
+		}
+	}
+	return nil
+}
+
+// Value stores the year as an integer, zero when it is unknown.
+func (y albumYear) Value() (driver.Value, error) {
+	return int64(y), nil
+}
+
+// Scan reads an integer year, also when the driver returns it as text.
+func (y *albumYear) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*y = 0
+	case int64:
+		*y = albumYear(v)
+	case []byte:
+		return y.Scan(string(v))
+	case string:
+		year, _ := strconv.Atoi(v)
+		*y = albumYear(year)
+	default:
+		return fmt.Errorf("cannot scan %T into a year", src)
+	}
+	return nil
+}